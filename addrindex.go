@@ -0,0 +1,334 @@
+package coin
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+
+	"github.com/thesoenke/go-coin/wire"
+)
+
+const (
+	// addrHistoryBucket maps a pubkey hash and an outpoint to an
+	// addressOutput for every output of the main chain
+	addrHistoryBucket = "addrhistory"
+
+	// addrUTXOBucket holds a key for every unspent output of the main chain.
+	// The keys are the same as in addrHistoryBucket.
+	addrUTXOBucket = "addrutxo"
+
+	addressOutputVersion = 1
+)
+
+// AddressOutput is an output of the main chain that pays to an address
+type AddressOutput struct {
+	Txid   []byte
+	Vout   int
+	Value  int
+	Height int
+
+	// SpentBy is the ID of the transaction of the main chain that spends the
+	// output. It is nil if the output is unspent.
+	SpentBy []byte
+}
+
+func (o *AddressOutput) serialize() []byte {
+	w := &wire.Writer{}
+	w.WriteUint8(addressOutputVersion)
+	w.WriteInt64(int64(o.Value))
+	w.WriteInt64(int64(o.Height))
+	w.WriteVarBytes(o.SpentBy)
+
+	return w.Bytes()
+}
+
+func deserializeAddressOutput(key, d []byte) (*AddressOutput, error) {
+	var out AddressOutput
+
+	r := wire.NewReader(d)
+	r.ReadVersion(addressOutputVersion)
+	out.Value = int(r.ReadInt64())
+	out.Height = int(r.ReadInt64())
+	out.SpentBy = r.ReadVarBytes()
+
+	err := r.Finish()
+	if err != nil {
+		return nil, err
+	}
+
+	out.Txid, out.Vout = parseAddrIndexKey(key)
+	return &out, nil
+}
+
+// addrIndexPrefix returns the common prefix of the keys of a pubkey hash. The
+// length of the hash is part of the prefix, so hashes of different lengths
+// never share keys.
+func addrIndexPrefix(pubKeyHash []byte) []byte {
+	return append([]byte{byte(len(pubKeyHash))}, pubKeyHash...)
+}
+
+func addrIndexKey(pubKeyHash, txid []byte, vout int) []byte {
+	var v [4]byte
+	binary.BigEndian.PutUint32(v[:], uint32(vout))
+
+	key := addrIndexPrefix(pubKeyHash)
+	key = append(key, txid...)
+	return append(key, v[:]...)
+}
+
+func parseAddrIndexKey(key []byte) ([]byte, int) {
+	outpoint := key[1+int(key[0]):]
+	txid := append([]byte{}, outpoint[:len(outpoint)-4]...)
+	vout := int(binary.BigEndian.Uint32(outpoint[len(outpoint)-4:]))
+
+	return txid, vout
+}
+
+// HasAddrIndex reports whether the address index is enabled
+func (bc *Blockchain) HasAddrIndex() (bool, error) {
+	enabled := false
+
+	err := bc.DB.View(func(tx StoreTx) error {
+		enabled = tx.Bucket([]byte(addrHistoryBucket)) != nil
+		return nil
+	})
+
+	return enabled, err
+}
+
+// ReindexAddresses enables the address index and rebuilds it from the blocks
+// of the main chain and their undo data. Once enabled, the index is kept in
+// sync when blocks are connected and disconnected.
+func (bc *Blockchain) ReindexAddresses() error {
+	return bc.DB.Update(func(tx StoreTx) error {
+		for _, bucketName := range []string{addrHistoryBucket, addrUTXOBucket} {
+			err := tx.DeleteBucket([]byte(bucketName))
+			if err != nil && err != ErrBucketNotFound {
+				return err
+			}
+
+			_, err = tx.CreateBucket([]byte(bucketName))
+			if err != nil {
+				return err
+			}
+		}
+
+		best, err := bestHeight(tx)
+		if err != nil {
+			return err
+		}
+
+		b := tx.Bucket([]byte(blocksBucket))
+		for height := 0; height <= best; height++ {
+			hash, err := blockHashAtHeight(tx, height)
+			if err != nil {
+				return err
+			}
+
+			block, err := getBlock(b, hash)
+			if err != nil {
+				return err
+			}
+
+			err = connectAddrIndex(tx, block)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// AddressHistory returns every output of the main chain that pays to the
+// pubkey hash. It requires the address index.
+func (bc *Blockchain) AddressHistory(pubKeyHash []byte) ([]AddressOutput, error) {
+	var history []AddressOutput
+
+	err := bc.DB.View(func(tx StoreTx) error {
+		h := tx.Bucket([]byte(addrHistoryBucket))
+		if h == nil {
+			return fmt.Errorf("address index is not enabled")
+		}
+
+		prefix := addrIndexPrefix(pubKeyHash)
+		c := h.Cursor()
+		for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+			out, err := deserializeAddressOutput(k, v)
+			if err != nil {
+				return err
+			}
+
+			history = append(history, *out)
+		}
+
+		return nil
+	})
+
+	return history, err
+}
+
+// connectAddrIndex adds the outputs of a block that was connected to the main
+// chain to the address index and marks the outputs it spends. The undo data
+// of the block has to be stored already.
+func connectAddrIndex(tx StoreTx, block *Block) error {
+	h := tx.Bucket([]byte(addrHistoryBucket))
+	if h == nil {
+		return nil
+	}
+	u := tx.Bucket([]byte(addrUTXOBucket))
+
+	undo, err := getBlockUndo(tx, block)
+	if err != nil {
+		return err
+	}
+
+	spentIdx := 0
+	for _, t := range block.Transactions {
+		if !t.IsCoinbase() {
+			for _, vin := range t.Vin {
+				spent := undo.Spent[spentIdx]
+				spentIdx++
+
+				key := addrIndexKey(spent.Output.PubKeyHash, vin.Txid, vin.Vout)
+				out := AddressOutput{Value: spent.Output.Value, Height: spent.Height, SpentBy: t.ID}
+				err := h.Put(key, out.serialize())
+				if err != nil {
+					return err
+				}
+
+				err = u.Delete(key)
+				if err != nil {
+					return err
+				}
+			}
+		}
+
+		for vout, txout := range t.Vout {
+			key := addrIndexKey(txout.PubKeyHash, t.ID, vout)
+			out := AddressOutput{Value: txout.Value, Height: block.Height}
+			err := h.Put(key, out.serialize())
+			if err != nil {
+				return err
+			}
+
+			err = u.Put(key, []byte{})
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// disconnectAddrIndex reverts connectAddrIndex for a block that is
+// disconnected from the main chain. It has to be called before the undo data
+// of the block is removed.
+func disconnectAddrIndex(tx StoreTx, block *Block) error {
+	h := tx.Bucket([]byte(addrHistoryBucket))
+	if h == nil {
+		return nil
+	}
+	u := tx.Bucket([]byte(addrUTXOBucket))
+
+	undo, err := getBlockUndo(tx, block)
+	if err != nil {
+		return err
+	}
+
+	// Transactions are reverted from the last to the first, so outputs
+	// created and spent within the block are restored before they are
+	// removed
+	spentIdx := len(undo.Spent)
+	for i := len(block.Transactions) - 1; i >= 0; i-- {
+		t := block.Transactions[i]
+
+		for vout, txout := range t.Vout {
+			key := addrIndexKey(txout.PubKeyHash, t.ID, vout)
+			err := h.Delete(key)
+			if err != nil {
+				return err
+			}
+
+			err = u.Delete(key)
+			if err != nil {
+				return err
+			}
+		}
+
+		if t.IsCoinbase() {
+			continue
+		}
+
+		for j := len(t.Vin) - 1; j >= 0; j-- {
+			vin := t.Vin[j]
+			spentIdx--
+			spent := undo.Spent[spentIdx]
+
+			key := addrIndexKey(spent.Output.PubKeyHash, vin.Txid, vin.Vout)
+			out := AddressOutput{Value: spent.Output.Value, Height: spent.Height}
+			err := h.Put(key, out.serialize())
+			if err != nil {
+				return err
+			}
+
+			err = u.Put(key, []byte{})
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// forEachAddressEntry calls fn for every unspent output that pays to the
+// pubkey hash. The address index is used if it is enabled, otherwise the
+// whole UTXO set is scanned.
+func forEachAddressEntry(tx StoreTx, pubKeyHash []byte, fn func(txid []byte, vout int, entry *UtxoEntry) error) error {
+	b := tx.Bucket([]byte(utxoBucket))
+
+	u := tx.Bucket([]byte(addrUTXOBucket))
+	if u == nil {
+		return b.ForEach(func(k, v []byte) error {
+			outs := DeserializeOutputs(v)
+			for vout := range outs.Outputs {
+				entry, _ := outs.entry(vout)
+				if !entry.Output.IsLockedWithKey(pubKeyHash) {
+					continue
+				}
+
+				err := fn(k, vout, entry)
+				if err != nil {
+					return err
+				}
+			}
+
+			return nil
+		})
+	}
+
+	prefix := addrIndexPrefix(pubKeyHash)
+	c := u.Cursor()
+	for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+		txid, vout := parseAddrIndexKey(k)
+
+		outsBytes := b.Get(txid)
+		if outsBytes == nil {
+			return fmt.Errorf("indexed output %x:%d is not in the UTXO set", txid, vout)
+		}
+
+		entry, ok := DeserializeOutputs(outsBytes).entry(vout)
+		if !ok {
+			return fmt.Errorf("indexed output %x:%d is not in the UTXO set", txid, vout)
+		}
+
+		err := fn(txid, vout, entry)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -11,7 +11,7 @@ func TestBase58(t *testing.T) {
 		_, public, _ := newKeyPair()
 		pubKeyHash := HashPubKey(public)
 
-		versionedPayload := append([]byte{version}, pubKeyHash...)
+		versionedPayload := append([]byte{MainNetParams.AddressVersion}, pubKeyHash...)
 		checksum := checksum(versionedPayload)
 
 		fullPayload := append(versionedPayload, checksum...)
@@ -19,9 +19,13 @@ func TestBase58(t *testing.T) {
 
 		assert.Equal(
 			t,
-			ValidateAddress(string(address[:])),
+			ValidateAddress(string(address[:]), &MainNetParams),
 			true,
 			"Address: %s is invalid", address,
 		)
+
+		// Addresses of one network are rejected on the others
+		assert.False(t, ValidateAddress(string(address), &TestNetParams))
+		assert.False(t, ValidateAddress(string(address), &RegTestParams))
 	}
 }
@@ -1,36 +1,55 @@
 package coin
 
 import (
-	"bytes"
-	"encoding/gob"
-	"log"
+	"context"
 	"time"
+
+	"github.com/thesoenke/go-coin/wire"
 )
 
+// MaxBlockSize is the maximum size of a serialized block in bytes
+const MaxBlockSize = 1000000
+
+// Block represents a block of the Blockchain
 type Block struct {
-	Timestamp     int64
-	Transactions  []*Transaction
-	PrevBlockHash []byte
-	Hash          []byte
-	Nonce         int
-	Height        int
+	BlockHeader
+	Transactions []*Transaction
+	Hash         []byte
+	Height       int
 }
 
-// NewBlock creates and returns Block
-func NewBlock(transactions []*Transaction, prevBlockHash []byte, height int) *Block {
-	block := &Block{time.Now().Unix(), transactions, prevBlockHash, []byte{}, 0, height}
-	pow := NewProofOfWork(block)
-	nonce, hash := pow.Run()
+// NewBlock creates and returns Block mined against the target in compact form
+func NewBlock(transactions []*Transaction, prevBlockHash []byte, height int, bits uint32) *Block {
+	block := NewBlockTemplate(transactions, prevBlockHash, height, bits)
 
-	block.Hash = hash[:]
-	block.Nonce = nonce
+	// Mining only fails if the context is cancelled
+	NewMiner().Mine(context.Background(), block)
+
+	return block
+}
+
+// NewBlockTemplate creates a Block that still needs to be mined
+func NewBlockTemplate(transactions []*Transaction, prevBlockHash []byte, height int, bits uint32) *Block {
+	block := &Block{
+		BlockHeader: BlockHeader{
+			Version:       blockHeaderVersion,
+			PrevBlockHash: prevBlockHash,
+			Timestamp:     time.Now().Unix(),
+			Bits:          bits,
+			Nonce:         0,
+		},
+		Transactions: transactions,
+		Hash:         []byte{},
+		Height:       height,
+	}
+	block.MerkleRoot = block.HashTransactions()
 
 	return block
 }
 
 // NewGenesisBlock creates the initial Blockchain block
-func NewGenesisBlock(coinbase *Transaction) *Block {
-	return NewBlock([]*Transaction{coinbase}, []byte{}, 0)
+func NewGenesisBlock(coinbase *Transaction, bits uint32) *Block {
+	return NewBlock([]*Transaction{coinbase}, []byte{}, 0, bits)
 }
 
 // HashTransactions in a block with a Merkle Tree
@@ -45,25 +64,40 @@ func (b *Block) HashTransactions() []byte {
 	return mTree.RootNode.Data
 }
 
-// Serialize a block
+// Serialize a block. The hash is not serialized as it is derived from the
+// header.
 func (b *Block) Serialize() []byte {
-	var result bytes.Buffer
-	encoder := gob.NewEncoder(&result)
-	err := encoder.Encode(b)
-	if err != nil {
-		log.Fatal(err)
+	w := &wire.Writer{}
+	b.BlockHeader.serialize(w)
+	w.WriteInt64(int64(b.Height))
+
+	w.WriteUint32(uint32(len(b.Transactions)))
+	for _, tx := range b.Transactions {
+		tx.serialize(w)
 	}
 
-	return result.Bytes()
+	return w.Bytes()
 }
 
-func DeserializeBlock(d []byte) *Block {
+// DeserializeBlock deserializes a block
+func DeserializeBlock(d []byte) (*Block, error) {
 	var block Block
-	decoder := gob.NewDecoder(bytes.NewReader(d))
-	err := decoder.Decode(&block)
+
+	r := wire.NewReader(d)
+	block.BlockHeader = readBlockHeader(r)
+	block.Height = int(r.ReadInt64())
+
+	count := r.ReadCount()
+	for i := 0; i < count; i++ {
+		tx := readTransaction(r)
+		block.Transactions = append(block.Transactions, &tx)
+	}
+
+	err := r.Finish()
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
 
-	return &block
+	block.Hash = block.BlockHash()
+	return &block, nil
 }
@@ -0,0 +1,74 @@
+package coin
+
+import (
+	"crypto/sha256"
+
+	"github.com/thesoenke/go-coin/wire"
+)
+
+// blockHeaderVersion is the only header version accepted by the consensus
+// rules
+const blockHeaderVersion = 1
+
+// BlockHeader contains the fields of a block that are covered by its proof of
+// work. The transactions are committed to through the merkle root, so a header
+// can be validated without the body of the block.
+type BlockHeader struct {
+	Version       uint32
+	PrevBlockHash []byte
+	MerkleRoot    []byte
+	Timestamp     int64
+	Bits          uint32
+	Nonce         uint32
+}
+
+// BlockHash returns the hash of the block, which is the SHA-256 hash of the
+// serialized header
+func (h *BlockHeader) BlockHash() []byte {
+	hash := sha256.Sum256(h.Serialize())
+	return hash[:]
+}
+
+// Serialize a block header. The nonce is always the last field, so miners
+// only need to replace the last four bytes of the serialized header.
+func (h *BlockHeader) Serialize() []byte {
+	w := &wire.Writer{}
+	h.serialize(w)
+
+	return w.Bytes()
+}
+
+func (h *BlockHeader) serialize(w *wire.Writer) {
+	w.WriteUint32(h.Version)
+	w.WriteVarBytes(h.PrevBlockHash)
+	w.WriteVarBytes(h.MerkleRoot)
+	w.WriteInt64(h.Timestamp)
+	w.WriteUint32(h.Bits)
+	w.WriteUint32(h.Nonce)
+}
+
+// DeserializeBlockHeader deserializes a block header
+func DeserializeBlockHeader(d []byte) (*BlockHeader, error) {
+	r := wire.NewReader(d)
+	header := readBlockHeader(r)
+
+	err := r.Finish()
+	if err != nil {
+		return nil, err
+	}
+
+	return &header, nil
+}
+
+func readBlockHeader(r *wire.Reader) BlockHeader {
+	var header BlockHeader
+
+	header.Version = r.ReadUint32()
+	header.PrevBlockHash = r.ReadVarBytes()
+	header.MerkleRoot = r.ReadVarBytes()
+	header.Timestamp = r.ReadInt64()
+	header.Bits = r.ReadUint32()
+	header.Nonce = r.ReadUint32()
+
+	return header
+}
@@ -2,52 +2,90 @@ package coin
 
 import (
 	"bytes"
+	"context"
 	"crypto/ecdsa"
 	"encoding/hex"
 	"errors"
 	"fmt"
 	"log"
+	"math/big"
 	"os"
+	"path/filepath"
 	"strconv"
+	"sync"
 	"time"
-
-	"github.com/boltdb/bolt"
 )
 
 const (
-	dbFile              = "blockchain_%d.db"
-	blocksBucket        = "blocks"
-	genesisCoinbaseData = "It's me, Mario!"
+	dbFile          = "blockchain_%d.db"
+	blocksBucket    = "blocks"
+	chainWorkBucket = "chainwork"
+	invalidBucket   = "invalid"
 )
 
-// Blockchain references the DB
+// ErrOrphanBlock is returned when the parent of a block is not known
+var ErrOrphanBlock = ruleError(ErrMissingParent, "parent block not found")
+
+// Blockchain references the store of the chain. It is safe for concurrent
+// use.
 type Blockchain struct {
-	tip []byte
-	DB  *bolt.DB
+	tipMu  sync.RWMutex
+	tip    []byte
+	DB     ChainStore
+	params *ChainParams
+
+	// notifyMu is taken before tipMu is released, so notifications are sent
+	// in the order of the tip changes
+	notifyMu      sync.Mutex
+	subscribersMu sync.RWMutex
+	subscribers   []NotificationCallback
 }
 
 // BlockchainIterator used to iterate over blocks
 type BlockchainIterator struct {
 	currentHash []byte
-	db          *bolt.DB
+	db          ChainStore
 }
 
-// CreateBlockchain creates a new blockchain DB
-func CreateBlockchain(address string, nodeID int) (*Blockchain, error) {
-	dbFile := fmt.Sprintf(dbFile, nodeID)
+// CreateBlockchain creates a new blockchain DB for the node ID in the
+// directory. The directory is created if it does not exist.
+func CreateBlockchain(dir, address string, nodeID int, params *ChainParams) (*Blockchain, error) {
+	dbFile := filepath.Join(dir, fmt.Sprintf(dbFile, nodeID))
 	if dbExists(dbFile) {
 		return nil, fmt.Errorf("blockchain '%s' already exists", dbFile)
 	}
 
-	var tip []byte
-	db, err := bolt.Open(dbFile, 0600, nil)
+	err := ensureDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	store, err := NewBoltStore(dbFile)
 	if err != nil {
 		return nil, err
 	}
 
-	err = db.Update(func(tx *bolt.Tx) error {
-		cbtx := NewCoinbaseTX(address, genesisCoinbaseData)
-		genesis := NewGenesisBlock(cbtx)
+	bc, err := CreateBlockchainWithStore(store, address, params)
+	if err != nil {
+		store.Close()
+		return nil, err
+	}
+
+	return bc, nil
+}
+
+// CreateBlockchainWithStore creates a new blockchain of the network in an
+// empty store and sends the genesis block reward to the address
+func CreateBlockchainWithStore(store ChainStore, address string, params *ChainParams) (*Blockchain, error) {
+	if !ValidateAddress(address, params) {
+		return nil, fmt.Errorf("address '%s' is not valid on the %s network", address, params.Name)
+	}
+
+	var tip []byte
+
+	err := store.Update(func(tx StoreTx) error {
+		cbtx := NewCoinbaseTX(address, params.GenesisCoinbaseData, CalcBlockSubsidy(0, params))
+		genesis := NewGenesisBlock(cbtx, params.PowLimitBits)
 
 		b, err := tx.CreateBucket([]byte(blocksBucket))
 		if err != nil {
@@ -64,6 +102,51 @@ func CreateBlockchain(address string, nodeID int) (*Blockchain, error) {
 			return err
 		}
 
+		_, err = tx.CreateBucket([]byte(chainWorkBucket))
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.CreateBucket([]byte(headersBucket))
+		if err != nil {
+			return err
+		}
+
+		_, err = putHeader(tx, &genesis.BlockHeader, 0)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.CreateBucket([]byte(utxoBucket))
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.CreateBucket([]byte(undoBucket))
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.CreateBucket([]byte(invalidBucket))
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.CreateBucket([]byte(heightsBucket))
+		if err != nil {
+			return err
+		}
+
+		err = connectUTXO(tx, genesis)
+		if err != nil {
+			return err
+		}
+
+		err = connectHeightIndex(tx, genesis)
+		if err != nil {
+			return err
+		}
+
 		tip = genesis.Hash
 		return nil
 	})
@@ -72,75 +155,155 @@ func CreateBlockchain(address string, nodeID int) (*Blockchain, error) {
 		return nil, err
 	}
 
-	bc := Blockchain{tip, db}
+	bc := Blockchain{tip: tip, DB: store, params: params}
 	return &bc, nil
 }
 
-// NewBlockchain creates a new Blockchain with genesis Block
-func NewBlockchain(nodeID int) (*Blockchain, error) {
-	dbFile := fmt.Sprintf(dbFile, nodeID)
+// Params returns the consensus parameters of the blockchain
+func (bc *Blockchain) Params() *ChainParams {
+	return bc.params
+}
+
+// NewBlockchain opens the blockchain DB of the node ID in the directory
+func NewBlockchain(dir string, nodeID int, params *ChainParams) (*Blockchain, error) {
+	dbFile := filepath.Join(dir, fmt.Sprintf(dbFile, nodeID))
 	if dbExists(dbFile) == false {
 		return nil, fmt.Errorf("no existing blockchain found")
 	}
 
-	var tip []byte
-	db, err := bolt.Open(dbFile, 0600, nil)
+	store, err := NewBoltStore(dbFile)
+	if err != nil {
+		return nil, err
+	}
+
+	bc, err := NewBlockchainWithStore(store, params)
 	if err != nil {
+		store.Close()
 		return nil, err
 	}
 
-	err = db.View(func(tx *bolt.Tx) error {
+	return bc, nil
+}
+
+// NewBlockchainWithStore opens the blockchain in a store that was set up by
+// CreateBlockchainWithStore. The genesis block has to belong to the network.
+func NewBlockchainWithStore(store ChainStore, params *ChainParams) (*Blockchain, error) {
+	var tip []byte
+
+	err := store.Update(func(tx StoreTx) error {
 		b := tx.Bucket([]byte(blocksBucket))
-		tip = b.Get([]byte("l"))
-		return nil
+		if b == nil {
+			return fmt.Errorf("no existing blockchain found")
+		}
+		tip = append([]byte{}, b.Get([]byte("l"))...)
+
+		if tx.Bucket([]byte(heightsBucket)) == nil {
+			err := buildHeightIndex(tx)
+			if err != nil {
+				return err
+			}
+		}
+
+		return checkGenesis(tx, params)
 	})
+	if err != nil {
+		return nil, err
+	}
 
-	bc := Blockchain{tip, db}
-	return &bc, err
+	bc := Blockchain{tip: tip, DB: store, params: params}
+	return &bc, nil
 }
 
 // MineBlock mines a new block with the provided transactions
 func (bc *Blockchain) MineBlock(transactions []*Transaction) (*Block, error) {
-	var lastHash []byte
-	var lastHeight int
-
-	for _, tx := range transactions {
-		// TODO: ignore transaction if it's not valid
-		if bc.VerifyTransaction(tx) != true {
-			return nil, fmt.Errorf("invalid transaction")
-		}
+	newBlock, err := bc.NewBlockTemplate(transactions)
+	if err != nil {
+		return nil, err
 	}
 
-	err := bc.DB.View(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte(blocksBucket))
-		lastHash = b.Get([]byte("l"))
-		blockData := b.Get(lastHash)
-		block := DeserializeBlock(blockData)
-		lastHeight = block.Height
-		return nil
-	})
+	err = NewMiner().Mine(context.Background(), newBlock)
 	if err != nil {
 		return nil, err
 	}
 
-	newBlock := NewBlock(transactions, lastHash, lastHeight+1)
-	err = bc.DB.Update(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte(blocksBucket))
-		err := b.Put(newBlock.Hash, newBlock.Serialize())
+	err = bc.AddBlock(newBlock)
+	return newBlock, err
+}
+
+// TemplateTxError is returned for a block template with a transaction that
+// is invalid on the current tip. Err is the RuleError of the transaction.
+type TemplateTxError struct {
+	Tx  *Transaction
+	Err error
+}
+
+func (e *TemplateTxError) Error() string {
+	return fmt.Sprintf("transaction %x of the template is invalid: %s", e.Tx.ID, e.Err)
+}
+
+// NewBlockTemplate creates a block with the provided transactions on top of
+// the current tip. The block still needs to be mined.
+func (bc *Blockchain) NewBlockTemplate(transactions []*Transaction) (*Block, error) {
+	return bc.newBlockTemplate(func(height int) []*Transaction {
+		return transactions
+	})
+}
+
+// NewBlockTemplateWithCoinbase creates a block on top of the current tip
+// whose coinbase pays the subsidy of the block plus fees to the address. The
+// coinbase is followed by the provided transactions. The block still needs to
+// be mined. A *TemplateTxError names the first transaction that is invalid.
+func (bc *Blockchain) NewBlockTemplateWithCoinbase(address string, fees int, transactions []*Transaction) (*Block, error) {
+	return bc.newBlockTemplate(func(height int) []*Transaction {
+		coinbase := NewCoinbaseTX(address, "", CalcBlockSubsidy(height, bc.params)+fees)
+		return append([]*Transaction{coinbase}, transactions...)
+	})
+}
+
+// newBlockTemplate creates a block on top of the current tip with the
+// transactions returned for the height of the block. The height is read in
+// the same transaction as the tip, so it cannot change in between.
+func (bc *Blockchain) newBlockTemplate(transactionsAt func(height int) []*Transaction) (*Block, error) {
+	var block *Block
+
+	err := bc.DB.View(func(tx StoreTx) error {
+		h := tx.Bucket([]byte(headersBucket))
+		lastHash := tx.Bucket([]byte(blocksBucket)).Get([]byte("l"))
+		parent, err := getHeaderNode(h, lastHash)
 		if err != nil {
 			return err
 		}
 
-		err = b.Put([]byte("l"), newBlock.Hash)
+		bits, err := calcNextRequiredBits(h, parent, bc.params)
 		if err != nil {
 			return err
 		}
 
-		bc.tip = newBlock.Hash
-		return nil
+		height := parent.Height + 1
+		block = NewBlockTemplate(transactionsAt(height), lastHash, height, bits)
+
+		// Blocks may be found faster than the clock advances
+		medianTime, err := calcPastMedianTime(h, parent)
+		if err != nil {
+			return err
+		}
+		if block.Timestamp <= medianTime {
+			block.Timestamp = medianTime + 1
+		}
+
+		// Transactions may spend outputs of earlier transactions in the
+		// template, so they are checked together against the UTXO set
+		i, err := checkConnectTransactions(tx, block, bc.params)
+		if err != nil && i > 0 {
+			return &TemplateTxError{Tx: block.Transactions[i], Err: err}
+		}
+		return err
 	})
+	if err != nil {
+		return nil, err
+	}
 
-	return newBlock, err
+	return block, nil
 }
 
 // VerifyTransaction verifies transaction input signatures
@@ -162,37 +325,320 @@ func (bc *Blockchain) VerifyTransaction(tx *Transaction) bool {
 	return tx.Verify(prevTXs)
 }
 
-// AddBlock saves the block into the blockchain
+// AddBlock validates the block and saves it into the blockchain. The main
+// chain switches to the branch of the block if it has more cumulative work
+// than the current tip. A block that violates a consensus rule is rejected
+// with a RuleError before anything is persisted.
+//
+// Checks against the UTXO set are performed when a block is connected to the
+// main chain. Blocks of a side branch are validated once the branch gets
+// more work than the main chain.
 func (bc *Blockchain) AddBlock(block *Block) error {
-	err := bc.DB.Update(func(tx *bolt.Tx) error {
+	err := CheckBlockSanity(block, bc.params)
+	if err != nil {
+		return err
+	}
+
+	// Writes are serialized by the DB anyway. Holding the lock until the tip
+	// is updated keeps it in the order of the commits.
+	bc.tipMu.Lock()
+	change, err := bc.addBlock(block)
+	bc.notifyMu.Lock()
+	bc.tipMu.Unlock()
+	defer bc.notifyMu.Unlock()
+
+	bc.sendNotifications(change)
+	return err
+}
+
+// addBlock saves a block that passed the sanity checks. It has to be called
+// with tipMu held.
+func (bc *Blockchain) addBlock(block *Block) (*tipChange, error) {
+	var newTip []byte
+	var invalid []byte
+	var change *tipChange
+
+	err := bc.DB.Update(func(tx StoreTx) error {
 		b := tx.Bucket([]byte(blocksBucket))
 		blockInDb := b.Get(block.Hash)
 		if blockInDb != nil {
 			return nil
 		}
 
+		err := checkBlockContext(tx, block, bc.params)
+		if err != nil {
+			return err
+		}
+
+		work, err := putHeader(tx, &block.BlockHeader, block.Height)
+		if err != nil {
+			return err
+		}
+
 		blockData := block.Serialize()
-		err := b.Put(block.Hash, blockData)
+		err = b.Put(block.Hash, blockData)
 		if err != nil {
 			return err
 		}
 
+		w := tx.Bucket([]byte(chainWorkBucket))
 		lastHash := b.Get([]byte("l"))
-		lastBlockData := b.Get(lastHash)
-		lastBlock := DeserializeBlock(lastBlockData)
+		tipWork := new(big.Int).SetBytes(w.Get(lastHash))
+		if work.Cmp(tipWork) <= 0 {
+			return nil
+		}
 
-		if block.Height > lastBlock.Height {
-			err = b.Put([]byte("l"), block.Hash)
+		change, err = setTip(tx, block, bc.params)
+		if cerr, ok := err.(*connectError); ok && bytes.Compare(cerr.hash, block.Hash) != 0 {
+			invalid = cerr.hash
+		}
+		if err != nil {
+			return err
+		}
+
+		newTip = block.Hash
+		return nil
+	})
+	if invalid != nil {
+		// A previously stored block of the branch failed validation. The
+		// update was rolled back, so remember it in a separate transaction
+		// to not try to connect the branch again
+		bc.DB.Update(func(tx StoreTx) error {
+			return tx.Bucket([]byte(invalidBucket)).Put(invalid, []byte{1})
+		})
+	}
+	if cerr, ok := err.(*connectError); ok {
+		return nil, cerr.err
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if newTip != nil {
+		bc.tip = newTip
+	}
+
+	return change, nil
+}
+
+// InvalidateBlock marks the block and all of its descendants as invalid. If
+// the block is part of the main chain, the chain is rolled back and the valid
+// branch with the most work becomes the main chain.
+func (bc *Blockchain) InvalidateBlock(blockHash []byte) error {
+	bc.tipMu.Lock()
+	change, err := bc.invalidateBlock(blockHash)
+	bc.notifyMu.Lock()
+	bc.tipMu.Unlock()
+	defer bc.notifyMu.Unlock()
+
+	bc.sendNotifications(change)
+	return err
+}
+
+// invalidateBlock has to be called with tipMu held
+func (bc *Blockchain) invalidateBlock(blockHash []byte) (*tipChange, error) {
+	var newTip []byte
+	var change *tipChange
+
+	err := bc.DB.Update(func(tx StoreTx) error {
+		b := tx.Bucket([]byte(blocksBucket))
+		h := tx.Bucket([]byte(headersBucket))
+		w := tx.Bucket([]byte(chainWorkBucket))
+		inv := tx.Bucket([]byte(invalidBucket))
+
+		invalidBlock, err := getHeaderNode(h, blockHash)
+		if err != nil {
+			return err
+		}
+
+		if len(invalidBlock.PrevBlockHash) == 0 {
+			return fmt.Errorf("genesis block cannot be invalidated")
+		}
+
+		var bestHash []byte
+		bestWork := new(big.Int)
+
+		err = w.ForEach(func(hash, work []byte) error {
+			descends, err := descendsFrom(h, hash, invalidBlock)
 			if err != nil {
 				return err
 			}
-			bc.tip = block.Hash
+
+			if descends {
+				return inv.Put(hash, []byte{1})
+			}
+
+			// Only blocks with a known body can become the tip
+			if b.Get(hash) != nil && inv.Get(hash) == nil && new(big.Int).SetBytes(work).Cmp(bestWork) > 0 {
+				bestHash = hash
+				bestWork.SetBytes(work)
+			}
+
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		if inv.Get(b.Get([]byte("l"))) == nil {
+			return nil
 		}
 
+		best, err := getBlock(b, bestHash)
+		if err != nil {
+			return err
+		}
+
+		change, err = setTip(tx, best, bc.params)
+		if err != nil {
+			return err
+		}
+
+		newTip = best.Hash
 		return nil
 	})
+	if err != nil {
+		return nil, err
+	}
 
-	return err
+	if newTip != nil {
+		bc.tip = newTip
+	}
+
+	return change, nil
+}
+
+// descendsFrom checks whether the header with the given hash is the ancestor
+// header or one of its descendants
+func descendsFrom(h StoreBucket, hash []byte, ancestor *headerNode) (bool, error) {
+	node, err := getHeaderNode(h, hash)
+	if err != nil {
+		return false, err
+	}
+
+	for node.Height > ancestor.Height {
+		node, err = getHeaderNode(h, node.PrevBlockHash)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	return bytes.Compare(node.Hash, ancestor.Hash) == 0, nil
+}
+
+// connectError is returned by setTip if a block of the new branch fails
+// validation against the UTXO set
+type connectError struct {
+	hash []byte
+	err  error
+}
+
+func (e *connectError) Error() string {
+	return e.err.Error()
+}
+
+// setTip makes the block the tip of the main chain. Blocks of the current main
+// chain that are not ancestors of the block are disconnected from the UTXO set
+// before the blocks of the new branch are connected.
+func setTip(tx StoreTx, block *Block, params *ChainParams) (*tipChange, error) {
+	b := tx.Bucket([]byte(blocksBucket))
+
+	oldTip, err := getBlock(b, b.Get([]byte("l")))
+	if err != nil {
+		return nil, err
+	}
+
+	var detach []*Block
+	var attach []*Block
+	oldBranch, newBranch := oldTip, block
+
+	for oldBranch.Height > newBranch.Height {
+		detach = append(detach, oldBranch)
+		oldBranch, err = getBlock(b, oldBranch.PrevBlockHash)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for newBranch.Height > oldBranch.Height {
+		attach = append([]*Block{newBranch}, attach...)
+		newBranch, err = getBlock(b, newBranch.PrevBlockHash)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for bytes.Compare(oldBranch.Hash, newBranch.Hash) != 0 {
+		detach = append(detach, oldBranch)
+		attach = append([]*Block{newBranch}, attach...)
+
+		oldBranch, err = getBlock(b, oldBranch.PrevBlockHash)
+		if err != nil {
+			return nil, err
+		}
+
+		newBranch, err = getBlock(b, newBranch.PrevBlockHash)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, block := range detach {
+		// The address index needs the undo data of the block
+		err = disconnectAddrIndex(tx, block)
+		if err != nil {
+			return nil, err
+		}
+
+		err = disconnectUTXO(tx, block)
+		if err != nil {
+			return nil, err
+		}
+
+		err = disconnectTxIndex(tx, block)
+		if err != nil {
+			return nil, err
+		}
+
+		err = disconnectHeightIndex(tx, block)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, block := range attach {
+		err = checkConnectBlock(tx, block, params)
+		if err != nil {
+			return nil, &connectError{hash: block.Hash, err: err}
+		}
+
+		err = connectUTXO(tx, block)
+		if err != nil {
+			return nil, err
+		}
+
+		err = connectTxIndex(tx, block)
+		if err != nil {
+			return nil, err
+		}
+
+		err = connectHeightIndex(tx, block)
+		if err != nil {
+			return nil, err
+		}
+
+		err = connectAddrIndex(tx, block)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = b.Put([]byte("l"), block.Hash)
+	if err != nil {
+		return nil, err
+	}
+
+	return &tipChange{tip: block, detached: detach, attached: attach}, nil
 }
 
 // FindUnspentTransactions returns a list of transactions containing unspent outputs
@@ -288,8 +734,11 @@ func (bc *Blockchain) FindUTXO() map[string]TXOutputs {
 					}
 				}
 
-				outs := UTXO[txID]
-				outs.Outputs = append(outs.Outputs, out)
+				outs, ok := UTXO[txID]
+				if !ok {
+					outs.Outputs = make(map[int]TXOutput)
+				}
+				outs.Outputs[outIdx] = out
 				UTXO[txID] = outs
 			}
 
@@ -309,8 +758,35 @@ func (bc *Blockchain) FindUTXO() map[string]TXOutputs {
 	return UTXO
 }
 
-// FindTransaction finds a transaction by its ID
+// FindTransaction finds a transaction of the main chain by its ID. The
+// transaction index is used if it is enabled, otherwise the blocks are
+// searched from the tip.
 func (bc *Blockchain) FindTransaction(ID []byte) (Transaction, error) {
+	var found *Transaction
+	indexed := false
+
+	err := bc.DB.View(func(tx StoreTx) error {
+		if tx.Bucket([]byte(txIndexBucket)) == nil {
+			return nil
+		}
+
+		indexed = true
+		var err error
+		found, err = fetchIndexedTransaction(tx, ID)
+		return err
+	})
+	if err != nil {
+		return Transaction{}, err
+	}
+
+	if indexed {
+		if found == nil {
+			return Transaction{}, fmt.Errorf("transaction not found")
+		}
+
+		return *found, nil
+	}
+
 	bci := bc.Iterator()
 
 	for {
@@ -332,31 +808,45 @@ func (bc *Blockchain) FindTransaction(ID []byte) (Transaction, error) {
 
 // GetBestHeight returns the height of the latest block
 func (bc *Blockchain) GetBestHeight() (int, error) {
-	var lastBlock Block
+	var height int
 
-	err := bc.DB.View(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte(blocksBucket))
-		lastHash := b.Get([]byte("l"))
-		blockData := b.Get(lastHash)
-		lastBlock = *DeserializeBlock(blockData)
-		return nil
+	err := bc.DB.View(func(tx StoreTx) error {
+		var err error
+		height, err = bestHeight(tx)
+		return err
 	})
 
-	return lastBlock.Height, err
+	return height, err
+}
+
+// bestHeight returns the height of the tip of the main chain
+func bestHeight(tx StoreTx) (int, error) {
+	b := tx.Bucket([]byte(blocksBucket))
+	block, err := getBlock(b, b.Get([]byte("l")))
+	if err != nil {
+		return 0, err
+	}
+
+	return block.Height, nil
 }
 
 // GetBlock finds a block by its hash and returns it
 func (bc *Blockchain) GetBlock(blockHash []byte) (Block, error) {
 	var block Block
 
-	err := bc.DB.View(func(tx *bolt.Tx) error {
+	err := bc.DB.View(func(tx StoreTx) error {
 		b := tx.Bucket([]byte(blocksBucket))
 		blockData := b.Get(blockHash)
 		if blockData == nil {
 			return errors.New("no block found")
 		}
 
-		block = *DeserializeBlock(blockData)
+		blockPtr, err := DeserializeBlock(blockData)
+		if err != nil {
+			return err
+		}
+
+		block = *blockPtr
 		return nil
 	})
 
@@ -399,6 +889,9 @@ func (bc *Blockchain) SignTransaction(tx *Transaction, privKey ecdsa.PrivateKey)
 
 // Iterator for the Blockchain
 func (bc *Blockchain) Iterator() *BlockchainIterator {
+	bc.tipMu.RLock()
+	defer bc.tipMu.RUnlock()
+
 	bci := &BlockchainIterator{currentHash: bc.tip, db: bc.DB}
 	return bci
 }
@@ -407,12 +900,11 @@ func (bc *Blockchain) Iterator() *BlockchainIterator {
 func (i *BlockchainIterator) Next() *Block {
 	var block *Block
 
-	err := i.db.View(func(tx *bolt.Tx) error {
+	err := i.db.View(func(tx StoreTx) error {
 		b := tx.Bucket([]byte(blocksBucket))
-		encodedBlock := b.Get(i.currentHash)
-		block = DeserializeBlock(encodedBlock)
-
-		return nil
+		var err error
+		block, err = getBlock(b, i.currentHash)
+		return err
 	})
 	if err != nil {
 		log.Panic(err)
@@ -433,8 +925,9 @@ func (bc *Blockchain) Print() {
 		fmt.Printf("Hash:\t%x\n", block.Hash)
 		fmt.Printf("Prev.:\t%x\n", block.PrevBlockHash)
 		fmt.Printf("Height: %d\n", block.Height)
+		fmt.Printf("Bits:\t%08x\n", block.Bits)
 		fmt.Printf("Date:\t%s\n", time.Unix(block.Timestamp, 0))
-		pow := NewProofOfWork(block)
+		pow := NewProofOfWork(&block.BlockHeader)
 		fmt.Printf("PoW:\t%s\n", strconv.FormatBool(pow.Validate()))
 		fmt.Println()
 
@@ -444,6 +937,44 @@ func (bc *Blockchain) Print() {
 	}
 }
 
+func getBlock(b StoreBucket, blockHash []byte) (*Block, error) {
+	blockData := b.Get(blockHash)
+	if blockData == nil {
+		return nil, fmt.Errorf("block %x not found", blockHash)
+	}
+
+	return DeserializeBlock(blockData)
+}
+
+// checkGenesis checks that the genesis block of the store was created for
+// the network
+func checkGenesis(tx StoreTx, params *ChainParams) error {
+	hash, err := blockHashAtHeight(tx, 0)
+	if err != nil {
+		return err
+	}
+
+	genesis, err := getBlock(tx.Bucket([]byte(blocksBucket)), hash)
+	if err != nil {
+		return err
+	}
+
+	if string(genesis.Transactions[0].Vin[0].PubKey) != params.GenesisCoinbaseData {
+		return fmt.Errorf("blockchain does not belong to the %s network", params.Name)
+	}
+
+	return nil
+}
+
+// ensureDir creates the directory if it does not exist
+func ensureDir(dir string) error {
+	if dir == "" {
+		return nil
+	}
+
+	return os.MkdirAll(dir, 0700)
+}
+
 func dbExists(dbFile string) bool {
 	if _, err := os.Stat(dbFile); os.IsNotExist(err) {
 		return false
@@ -0,0 +1,357 @@
+package coin
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestChain creates a blockchain of the regression test network in memory.
+// The parameters are a copy of RegTestParams that tests may change.
+func newTestChain(t *testing.T) (*Blockchain, *Wallet, *ChainParams) {
+	params := RegTestParams
+
+	wallet, err := NewWallet()
+	require.NoError(t, err)
+
+	bc, err := CreateBlockchainWithStore(NewMemStore(), string(wallet.GetAddress(&params)), &params)
+	require.NoError(t, err)
+
+	t.Cleanup(func() {
+		bc.DB.Close()
+	})
+
+	return bc, wallet, &params
+}
+
+// mineBlocks mines n blocks with only a coinbase on top of the tip
+func mineBlocks(t *testing.T, bc *Blockchain, wallet *Wallet, n int) []*Block {
+	var blocks []*Block
+	for i := 0; i < n; i++ {
+		height, err := bc.GetBestHeight()
+		require.NoError(t, err)
+
+		coinbase := NewCoinbaseTX(string(wallet.GetAddress(bc.Params())), "", CalcBlockSubsidy(height+1, bc.Params()))
+		block, err := bc.MineBlock([]*Transaction{coinbase})
+		require.NoError(t, err)
+		blocks = append(blocks, block)
+	}
+
+	return blocks
+}
+
+func TestTxIndex(t *testing.T) {
+	bc, wallet, _ := newTestChain(t)
+	blocks := mineBlocks(t, bc, wallet, 2)
+
+	enabled, err := bc.HasTxIndex()
+	require.NoError(t, err)
+	assert.False(t, enabled)
+
+	require.NoError(t, bc.ReindexTransactions())
+	enabled, err = bc.HasTxIndex()
+	require.NoError(t, err)
+	assert.True(t, enabled)
+
+	tx, err := bc.FindTransaction(blocks[0].Transactions[0].ID)
+	require.NoError(t, err)
+	assert.Equal(t, blocks[0].Transactions[0].ID, tx.ID)
+
+	// Blocks connected after the rebuild are indexed as well
+	newer := mineBlocks(t, bc, wallet, 1)[0]
+	tx, err = bc.FindTransaction(newer.Transactions[0].ID)
+	require.NoError(t, err)
+	assert.Equal(t, newer.Transactions[0].ID, tx.ID)
+
+	// Disconnected blocks are removed from the index
+	require.NoError(t, bc.InvalidateBlock(blocks[1].Hash))
+	_, err = bc.FindTransaction(newer.Transactions[0].ID)
+	assert.Error(t, err)
+	_, err = bc.FindTransaction(blocks[1].Transactions[0].ID)
+	assert.Error(t, err)
+	_, err = bc.FindTransaction(blocks[0].Transactions[0].ID)
+	assert.NoError(t, err)
+}
+
+func TestHeightIndex(t *testing.T) {
+	bc, wallet, _ := newTestChain(t)
+	blocks := mineBlocks(t, bc, wallet, 3)
+
+	for _, block := range blocks {
+		hash, err := bc.GetBlockHash(block.Height)
+		require.NoError(t, err)
+		assert.Equal(t, block.Hash, hash)
+	}
+
+	block, err := bc.GetBlockByHeight(2)
+	require.NoError(t, err)
+	assert.Equal(t, blocks[1].Hash, block.Hash)
+
+	_, err = bc.GetBlockByHeight(4)
+	assert.Error(t, err)
+
+	// A reorganization replaces the heights of the old branch
+	require.NoError(t, bc.InvalidateBlock(blocks[1].Hash))
+	_, err = bc.GetBlockHash(2)
+	assert.Error(t, err)
+
+	branch := mineBlocks(t, bc, wallet, 3)
+	for _, block := range branch {
+		hash, err := bc.GetBlockHash(block.Height)
+		require.NoError(t, err)
+		assert.Equal(t, block.Hash, hash)
+	}
+	assert.Equal(t, 4, branch[2].Height)
+}
+
+func TestAddressIndex(t *testing.T) {
+	bc, wallet, params := newTestChain(t)
+	params.CoinbaseMaturity = 1
+	require.NoError(t, bc.ReindexAddresses())
+
+	receiver, err := NewWallet()
+	require.NoError(t, err)
+	pubKeyHash := HashPubKey(wallet.PublicKey)
+	UTXOSet := UTXOSet{Blockchain: bc}
+
+	genesis, err := bc.GetBlockByHeight(0)
+	require.NoError(t, err)
+
+	spend, err := NewUTXOTransaction(wallet, string(receiver.GetAddress(bc.Params())), 3, 1, &UTXOSet)
+	require.NoError(t, err)
+	coinbase := NewCoinbaseTX(string(wallet.GetAddress(bc.Params())), "", CalcBlockSubsidy(1, bc.Params())+1)
+	block, err := bc.MineBlock([]*Transaction{coinbase, spend})
+	require.NoError(t, err)
+
+	history, err := bc.AddressHistory(pubKeyHash)
+	require.NoError(t, err)
+	spentBy := make(map[string][]byte)
+	for _, out := range history {
+		spentBy[fmt.Sprintf("%x:%d", out.Txid, out.Vout)] = out.SpentBy
+	}
+	assert.Len(t, history, 3)
+	assert.Equal(t, spend.ID, spentBy[fmt.Sprintf("%x:0", genesis.Transactions[0].ID)])
+	assert.Contains(t, spentBy, fmt.Sprintf("%x:0", coinbase.ID))
+	assert.Contains(t, spentBy, fmt.Sprintf("%x:1", spend.ID))
+
+	utxos, err := UTXOSet.FindUTXO(HashPubKey(receiver.PublicKey))
+	require.NoError(t, err)
+	require.Len(t, utxos, 1)
+	assert.Equal(t, 3, utxos[0].Value)
+
+	// Disconnecting the block restores the spent output
+	require.NoError(t, bc.InvalidateBlock(block.Hash))
+	history, err = bc.AddressHistory(pubKeyHash)
+	require.NoError(t, err)
+	require.Len(t, history, 1)
+	assert.Nil(t, history[0].SpentBy)
+
+	balance, _, err := UTXOSet.Balance(pubKeyHash)
+	require.NoError(t, err)
+	assert.Equal(t, genesis.Transactions[0].Vout[0].Value, balance)
+
+	utxos, err = UTXOSet.FindUTXO(HashPubKey(receiver.PublicKey))
+	require.NoError(t, err)
+	assert.Empty(t, utxos)
+}
+
+func TestFindUnspentOutputs(t *testing.T) {
+	// The outputs are found by a scan of the UTXO set and by the address index
+	for _, addrIndex := range []bool{false, true} {
+		bc, wallet, params := newTestChain(t)
+		params.CoinbaseMaturity = 1
+		if addrIndex {
+			require.NoError(t, bc.ReindexAddresses())
+		}
+
+		receiver, err := NewWallet()
+		require.NoError(t, err)
+		UTXOSet := UTXOSet{Blockchain: bc}
+
+		spend, err := NewUTXOTransaction(wallet, string(receiver.GetAddress(params)), 3, 1, &UTXOSet)
+		require.NoError(t, err)
+		coinbase := NewCoinbaseTX(string(wallet.GetAddress(params)), "", CalcBlockSubsidy(1, params)+1)
+		_, err = bc.MineBlock([]*Transaction{coinbase, spend})
+		require.NoError(t, err)
+
+		unspent, err := UTXOSet.FindUnspentOutputs(HashPubKey(receiver.PublicKey))
+		require.NoError(t, err)
+		require.Len(t, unspent, 1)
+		assert.Equal(t, spend.ID, unspent[0].Txid)
+		assert.Equal(t, 0, unspent[0].Vout)
+		assert.Equal(t, 1, unspent[0].Height)
+		assert.Equal(t, 3, unspent[0].Output.Value)
+		assert.False(t, unspent[0].Coinbase)
+
+		// The coinbase and the change are ordered by transaction ID
+		unspent, err = UTXOSet.FindUnspentOutputs(HashPubKey(wallet.PublicKey))
+		require.NoError(t, err)
+		require.Len(t, unspent, 2)
+		assert.Equal(t, -1, bytes.Compare(unspent[0].Txid, unspent[1].Txid))
+
+		outs := make(map[string]UnspentOutput)
+		for _, out := range unspent {
+			outs[fmt.Sprintf("%x:%d", out.Txid, out.Vout)] = out
+		}
+		assert.True(t, outs[fmt.Sprintf("%x:0", coinbase.ID)].Coinbase)
+		assert.False(t, outs[fmt.Sprintf("%x:1", spend.ID)].Coinbase)
+
+		unspent, err = UTXOSet.FindUnspentOutputs(HashPubKey([]byte("nobody")))
+		require.NoError(t, err)
+		assert.Empty(t, unspent)
+	}
+}
+
+// newBlockOn mines a block with the transactions on top of the parent. The
+// timestamp follows the one of the parent, as the test blocks are mined
+// faster than the clock advances.
+func newBlockOn(t *testing.T, parent *Block, transactions []*Transaction) *Block {
+	block := NewBlockTemplate(transactions, parent.Hash, parent.Height+1, parent.Bits)
+	if block.Timestamp <= parent.Timestamp {
+		block.Timestamp = parent.Timestamp + 1
+	}
+	require.NoError(t, NewMiner().Mine(context.Background(), block))
+
+	return block
+}
+
+// mineBlockOn mines a block with the transactions on top of the parent and
+// adds it to the chain. The parent does not have to be the tip.
+func mineBlockOn(t *testing.T, bc *Blockchain, parent *Block, transactions []*Transaction) *Block {
+	block := newBlockOn(t, parent, transactions)
+	require.NoError(t, bc.AddBlock(block))
+
+	return block
+}
+
+func TestReorganization(t *testing.T) {
+	bc, wallet, params := newTestChain(t)
+	params.CoinbaseMaturity = 1
+	UTXOSet := UTXOSet{Blockchain: bc}
+
+	genesis, err := bc.GetBlockByHeight(0)
+	require.NoError(t, err)
+	genesisCoinbase := genesis.Transactions[0].ID
+
+	receiver, err := NewWallet()
+	require.NoError(t, err)
+	spend, err := NewUTXOTransaction(wallet, string(receiver.GetAddress(params)), 3, 0, &UTXOSet)
+	require.NoError(t, err)
+
+	a1 := mineBlocks(t, bc, wallet, 1)[0]
+	a2, err := bc.MineBlock([]*Transaction{NewCoinbaseTX(string(wallet.GetAddress(params)), "", CalcBlockSubsidy(2, params)), spend})
+	require.NoError(t, err)
+
+	// A side branch with the same work does not replace the main chain
+	b1 := mineBlockOn(t, bc, &genesis, []*Transaction{NewCoinbaseTX(string(wallet.GetAddress(params)), "", CalcBlockSubsidy(1, params))})
+	b2 := mineBlockOn(t, bc, b1, []*Transaction{NewCoinbaseTX(string(wallet.GetAddress(params)), "", CalcBlockSubsidy(2, params))})
+	assert.Equal(t, a2.Hash, bc.Iterator().Next().Hash)
+
+	entry, err := UTXOSet.FetchEntry(genesisCoinbase, 0)
+	require.NoError(t, err)
+	assert.Nil(t, entry)
+
+	// The heavier branch disconnects a1 and a2 and restores the output they spent
+	b3 := mineBlockOn(t, bc, b2, []*Transaction{NewCoinbaseTX(string(wallet.GetAddress(params)), "", CalcBlockSubsidy(3, params))})
+	assert.Equal(t, b3.Hash, bc.Iterator().Next().Hash)
+
+	height, err := bc.GetBestHeight()
+	require.NoError(t, err)
+	assert.Equal(t, 3, height)
+
+	entry, err = UTXOSet.FetchEntry(genesisCoinbase, 0)
+	require.NoError(t, err)
+	require.NotNil(t, entry)
+	assert.Equal(t, 0, entry.Height)
+	assert.True(t, entry.Coinbase)
+
+	for _, tx := range []*Transaction{a1.Transactions[0], a2.Transactions[0], spend} {
+		entry, err = UTXOSet.FetchEntry(tx.ID, 0)
+		require.NoError(t, err)
+		assert.Nil(t, entry, "output of %x is still unspent", tx.ID)
+	}
+
+	for _, block := range []*Block{b1, b2, b3} {
+		entry, err = UTXOSet.FetchEntry(block.Transactions[0].ID, 0)
+		require.NoError(t, err)
+		require.NotNil(t, entry)
+		assert.Equal(t, block.Height, entry.Height)
+	}
+
+	utxos, err := UTXOSet.FindUTXO(HashPubKey(receiver.PublicKey))
+	require.NoError(t, err)
+	assert.Empty(t, utxos)
+
+	total, err := UTXOSet.TotalValue()
+	require.NoError(t, err)
+	assert.Equal(t, ExpectedSupply(3, params), total)
+}
+
+func TestReorganizationNotifications(t *testing.T) {
+	bc, wallet, _ := newTestChain(t)
+	genesis, err := bc.GetBlockByHeight(0)
+	require.NoError(t, err)
+	a := mineBlocks(t, bc, wallet, 2)
+
+	var notifications []*Notification
+	bc.Subscribe(func(notification *Notification) {
+		notifications = append(notifications, notification)
+	})
+
+	coinbase := func(height int) []*Transaction {
+		return []*Transaction{NewCoinbaseTX(string(wallet.GetAddress(bc.Params())), "", CalcBlockSubsidy(height, bc.Params()))}
+	}
+	b1 := mineBlockOn(t, bc, &genesis, coinbase(1))
+	b2 := mineBlockOn(t, bc, b1, coinbase(2))
+	assert.Empty(t, notifications)
+
+	// The blocks of the reorganization are followed by the change as a whole
+	b3 := mineBlockOn(t, bc, b2, coinbase(3))
+	var types []NotificationType
+	var hashes [][]byte
+	for _, notification := range notifications {
+		types = append(types, notification.Type)
+		hashes = append(hashes, notification.Block.Hash)
+	}
+	assert.Equal(t, []NotificationType{NTBlockDisconnected, NTBlockDisconnected, NTBlockConnected, NTBlockConnected, NTBlockConnected, NTTipChanged}, types)
+	assert.Equal(t, [][]byte{a[1].Hash, a[0].Hash, b1.Hash, b2.Hash, b3.Hash, b3.Hash}, hashes)
+
+	change := notifications[5]
+	assert.Equal(t, []*Block{a[1], a[0]}, change.Detached)
+	require.Len(t, change.Attached, 3)
+	assert.Equal(t, b1.Hash, change.Attached[0].Hash)
+}
+
+func TestNewBlockTemplateWithCoinbase(t *testing.T) {
+	bc, wallet, params := newTestChain(t)
+	params.SubsidyHalvingInterval = 2
+	address := string(wallet.GetAddress(params))
+
+	template, err := bc.NewBlockTemplateWithCoinbase(address, 0, nil)
+	require.NoError(t, err)
+	assert.Equal(t, 1, template.Height)
+	require.Len(t, template.Transactions, 1)
+	assert.Equal(t, CalcBlockSubsidy(1, params), template.Transactions[0].Vout[0].Value)
+
+	// The subsidy follows the tip the template is built on
+	mineBlocks(t, bc, wallet, 1)
+	template, err = bc.NewBlockTemplateWithCoinbase(address, 0, nil)
+	require.NoError(t, err)
+	assert.Equal(t, 2, template.Height)
+	assert.Equal(t, CalcBlockSubsidy(2, params), template.Transactions[0].Vout[0].Value)
+	assert.NotEqual(t, CalcBlockSubsidy(1, params), CalcBlockSubsidy(2, params))
+
+	require.NoError(t, NewMiner().Mine(context.Background(), template))
+	require.NoError(t, bc.AddBlock(template))
+
+	// An invalid transaction is named by the error
+	missing := newTestTransaction(wallet, params, []byte("missing"), 1)
+	_, err = bc.NewBlockTemplateWithCoinbase(address, 0, []*Transaction{missing})
+	require.IsType(t, &TemplateTxError{}, err)
+	assert.Equal(t, missing, err.(*TemplateTxError).Tx)
+	assertRuleError(t, err.(*TemplateTxError).Err, ErrMissingInput)
+}
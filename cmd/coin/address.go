@@ -11,11 +11,19 @@ var cmdWallet = &cobra.Command{
 	Use:   "address",
 	Short: "Generate a new address",
 	Run: func(cmd *cobra.Command, args []string) {
-		wallets, _ := coin.NewWallets(nodeID)
-		address, err := wallets.CreateWallet()
+		if client := rpcClient(); client != nil {
+			address, err := client.NewAddress()
+			printErr(err)
+
+			fmt.Printf("Your new address: %s\n", address)
+			return
+		}
+
+		wallets, _ := coin.NewWallets(dataDir(), nodeID)
+		address, err := wallets.CreateWallet(netParams())
 		printErr(err)
 
-		err = wallets.SaveToFile(nodeID)
+		err = wallets.SaveToFile(dataDir(), nodeID)
 		printErr(err)
 		fmt.Printf("Your new address: %s\n", address)
 	},
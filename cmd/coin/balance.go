@@ -12,8 +12,11 @@ var cmdBalance = &cobra.Command{
 	Use:   "balance",
 	Short: "Get balance of address",
 	Run: func(cmd *cobra.Command, args []string) {
-		balance := getBalance(balanceAddress)
+		balance, immature := getBalance(balanceAddress)
 		fmt.Printf("Balance of '%s': %d\n", balanceAddress, balance)
+		if immature > 0 {
+			fmt.Printf("Immature coinbase outputs: %d\n", immature)
+		}
 	},
 }
 
@@ -22,26 +25,30 @@ func init() {
 	RootCmd.AddCommand(cmdBalance)
 }
 
-func getBalance(address string) int {
-	if !coin.ValidateAddress(address) {
+// getBalance returns the spendable balance of the address and the value of
+// its coinbase outputs that are not mature yet
+func getBalance(address string) (int, int) {
+	if !coin.ValidateAddress(address, netParams()) {
 		err := fmt.Errorf("address '%s' is not valid", address)
 		printErr(err)
 	}
 
-	bc, err := coin.NewBlockchain(nodeID)
+	if client := rpcClient(); client != nil {
+		balance, err := client.Balance(address)
+		printErr(err)
+
+		return balance.Balance, balance.Immature
+	}
+
+	bc, err := coin.NewBlockchain(dataDir(), nodeID, netParams())
 	printErr(err)
 	defer bc.DB.Close()
 
-	balance := 0
 	pubKeyHash := coin.Base58Decode([]byte(address))
 	pubKeyHash = pubKeyHash[1 : len(pubKeyHash)-4]
 	UTXOSet := coin.UTXOSet{Blockchain: bc}
-	UTXOs, err := UTXOSet.FindUTXO(pubKeyHash)
+	balance, immature, err := UTXOSet.Balance(pubKeyHash)
 	printErr(err)
 
-	for _, out := range UTXOs {
-		balance += out.Value
-	}
-
-	return balance
+	return balance, immature
 }
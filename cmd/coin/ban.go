@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/thesoenke/go-coin/server"
+)
+
+var banDuration time.Duration
+var cmdBan = &cobra.Command{
+	Use:   "ban",
+	Short: "Manage the hosts that are banned by the node",
+}
+
+var cmdBanAdd = &cobra.Command{
+	Use:   "add <host>",
+	Short: "Ban a host",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		bans := loadBanList()
+		until := time.Now().Add(banDuration)
+		bans.Ban(args[0], until)
+		printErr(bans.Save())
+
+		fmt.Printf("Banned %s until %s\n", args[0], until.Format(time.RFC3339))
+	},
+}
+
+var cmdBanRemove = &cobra.Command{
+	Use:   "remove <host>",
+	Short: "Remove the ban of a host",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		bans := loadBanList()
+		if !bans.Unban(args[0]) {
+			printErr(fmt.Errorf("%s is not banned", args[0]))
+		}
+		printErr(bans.Save())
+
+		fmt.Printf("Removed the ban of %s\n", args[0])
+	},
+}
+
+var cmdBanList = &cobra.Command{
+	Use:   "list",
+	Short: "List the banned hosts",
+	Run: func(cmd *cobra.Command, args []string) {
+		bans := loadBanList().Bans()
+
+		var hosts []string
+		for host := range bans {
+			hosts = append(hosts, host)
+		}
+		sort.Strings(hosts)
+
+		for _, host := range hosts {
+			fmt.Printf("%s banned until %s\n", host, bans[host].Format(time.RFC3339))
+		}
+	},
+}
+
+func loadBanList() *server.BanList {
+	bans := server.NewBanList(server.BanFile(dataDir(), nodeID))
+	printErr(bans.Load())
+
+	return bans
+}
+
+func init() {
+	cmdBanAdd.PersistentFlags().DurationVar(&banDuration, "duration", 24*time.Hour, "Time the host stays banned")
+	cmdBan.AddCommand(cmdBanAdd, cmdBanRemove, cmdBanList)
+	RootCmd.AddCommand(cmdBan)
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/thesoenke/go-coin"
+)
+
+var blockHeight int
+var blockHash string
+var cmdBlock = &cobra.Command{
+	Use:   "block",
+	Short: "Print a block of the main chain and its transactions",
+	Run: func(cmd *cobra.Command, args []string) {
+		if (blockHash == "") == (blockHeight < 0) {
+			printErr(fmt.Errorf("either --height or --hash is required"))
+		}
+
+		block := fetchBlock()
+
+		fmt.Printf("Hash:\t%x\n", block.Hash)
+		fmt.Printf("Prev.:\t%x\n", block.PrevBlockHash)
+		fmt.Printf("Height: %d\n", block.Height)
+		fmt.Printf("Bits:\t%08x\n", block.Bits)
+		fmt.Printf("Nonce:\t%d\n", block.Nonce)
+		fmt.Printf("Merkle:\t%x\n", block.MerkleRoot)
+		fmt.Printf("Date:\t%s\n", time.Unix(block.Timestamp, 0))
+		fmt.Printf("Size:\t%d bytes\n", len(block.Serialize()))
+		fmt.Printf("Transactions: %d\n", len(block.Transactions))
+		for _, tx := range block.Transactions {
+			fmt.Println(tx)
+		}
+	},
+}
+
+func init() {
+	cmdBlock.PersistentFlags().IntVar(&blockHeight, "height", -1, "Height of the block in the main chain")
+	cmdBlock.PersistentFlags().StringVar(&blockHash, "hash", "", "Hash of the block")
+	RootCmd.AddCommand(cmdBlock)
+}
+
+// fetchBlock returns the block given with --hash or --height from the node
+// given with --rpcconnect or from the database
+func fetchBlock() coin.Block {
+	if client := rpcClient(); client != nil {
+		params := map[string]interface{}{"raw": true}
+		if blockHash != "" {
+			params["hash"] = blockHash
+		} else {
+			params["height"] = blockHeight
+		}
+
+		var raw string
+		printErr(client.Call("getblock", params, &raw))
+
+		data, err := hex.DecodeString(raw)
+		printErr(err)
+
+		block, err := coin.DeserializeBlock(data)
+		printErr(err)
+
+		return *block
+	}
+
+	bc, err := coin.NewBlockchain(dataDir(), nodeID, netParams())
+	printErr(err)
+	defer bc.DB.Close()
+
+	var block coin.Block
+	if blockHash != "" {
+		hash, err := hex.DecodeString(blockHash)
+		printErr(err)
+		block, err = bc.GetBlock(hash)
+		printErr(err)
+	} else {
+		block, err = bc.GetBlockByHeight(blockHeight)
+		printErr(err)
+	}
+
+	return block
+}
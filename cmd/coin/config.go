@@ -0,0 +1,175 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+const (
+	// configFileName is the name of the config file in the data directory
+	configFileName = "coin.toml"
+
+	// envPrefix starts the names of the environment variables that set flags
+	envPrefix = "COIN"
+)
+
+// loadConfig sets the flags of the command that were not given on the command
+// line. A flag is taken from the environment first, then from the config file
+// and otherwise keeps its default.
+//
+// The flags of the root command are named COIN_<FLAG> in the environment and
+// are top level keys of the config file. The flags of a command are named
+// COIN_<COMMAND>_<FLAG> and are keys of the table of the command, for example
+// COIN_SERVER_ADDRESS and the key address of the table [server].
+func loadConfig(cmd *cobra.Command) error {
+	section := commandSection(cmd)
+
+	var err error
+	cmd.Flags().VisitAll(func(f *pflag.Flag) {
+		if err != nil || f.Changed {
+			return
+		}
+
+		value, ok := os.LookupEnv(envName(flagSection(cmd, f, section), f.Name))
+		if ok {
+			err = setFlag(cmd, f, value, "environment")
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	path := configFile
+	if path == "" {
+		path = filepath.Join(dataRoot, configFileName)
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			return nil
+		}
+	}
+
+	var config map[string]interface{}
+	_, err = toml.DecodeFile(path, &config)
+	if err != nil {
+		return fmt.Errorf("config file %s: %s", path, err)
+	}
+
+	values, err := configValues(cmd, config, section)
+	if err != nil {
+		return fmt.Errorf("config file %s: %s", path, err)
+	}
+
+	cmd.Flags().VisitAll(func(f *pflag.Flag) {
+		if err != nil || f.Changed {
+			return
+		}
+
+		value, ok := values[f.Name]
+		if ok {
+			err = setFlag(cmd, f, value, "config file "+path)
+		}
+	})
+
+	return err
+}
+
+// commandSection returns the names of the command and its parents below the
+// root command
+func commandSection(cmd *cobra.Command) []string {
+	var section []string
+	for c := cmd; c.HasParent(); c = c.Parent() {
+		section = append([]string{c.Name()}, section...)
+	}
+
+	return section
+}
+
+// flagSection returns the section a flag of the command is configured in.
+// The flags of the root command are configured at the top level.
+func flagSection(cmd *cobra.Command, f *pflag.Flag, section []string) []string {
+	if cmd.Root().PersistentFlags().Lookup(f.Name) != nil {
+		return nil
+	}
+
+	return section
+}
+
+func envName(section []string, name string) string {
+	parts := append([]string{envPrefix}, section...)
+	parts = append(parts, name)
+
+	return strings.ToUpper(strings.Replace(strings.Join(parts, "_"), "-", "_", -1))
+}
+
+// configValues returns the values of the config file for the flags of the
+// command. Keys of the top level and of the table of the command that are not
+// flags are an error. Tables of other commands are ignored.
+func configValues(cmd *cobra.Command, config map[string]interface{}, section []string) (map[string]string, error) {
+	values := make(map[string]string)
+
+	for key, value := range config {
+		if _, ok := value.(map[string]interface{}); ok {
+			continue
+		}
+
+		if key == "config" || cmd.Root().PersistentFlags().Lookup(key) == nil {
+			return nil, fmt.Errorf("unknown option '%s'", key)
+		}
+
+		values[key] = configString(value)
+	}
+
+	table := config
+	for _, name := range section {
+		next, ok := table[name].(map[string]interface{})
+		if !ok {
+			return values, nil
+		}
+		table = next
+	}
+
+	for key, value := range table {
+		if _, ok := value.(map[string]interface{}); ok {
+			continue
+		}
+
+		f := cmd.Flags().Lookup(key)
+		if f == nil || flagSection(cmd, f, section) == nil {
+			return nil, fmt.Errorf("unknown option '%s' in [%s]", key, strings.Join(section, "."))
+		}
+
+		values[key] = configString(value)
+	}
+
+	return values, nil
+}
+
+// configString formats a value of the config file as a flag value. Arrays are
+// joined with commas, which is how slice flags are given on the command line.
+func configString(value interface{}) string {
+	list, ok := value.([]interface{})
+	if !ok {
+		return fmt.Sprint(value)
+	}
+
+	items := make([]string, len(list))
+	for i, item := range list {
+		items[i] = fmt.Sprint(item)
+	}
+
+	return strings.Join(items, ",")
+}
+
+func setFlag(cmd *cobra.Command, f *pflag.Flag, value, source string) error {
+	err := cmd.Flags().Set(f.Name, value)
+	if err != nil {
+		return fmt.Errorf("invalid value '%s' for --%s from the %s: %s", value, f.Name, source, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/cobra"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newConfigTestCmd returns a root command with a server command that records
+// the flags it was run with
+func newConfigTestCmd(flags map[string]interface{}) *cobra.Command {
+	root := &cobra.Command{
+		Use: "coin",
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			return loadConfig(cmd)
+		},
+	}
+	root.PersistentFlags().String("network", "main", "")
+
+	server := &cobra.Command{
+		Use: "server",
+		Run: func(cmd *cobra.Command, args []string) {
+			flags["network"], _ = cmd.Flags().GetString("network")
+			flags["address"], _ = cmd.Flags().GetString("address")
+			flags["listen"], _ = cmd.Flags().GetString("listen")
+			flags["seed"], _ = cmd.Flags().GetStringSlice("seed")
+			flags["txindex"], _ = cmd.Flags().GetBool("txindex")
+		},
+	}
+	server.Flags().String("address", "", "")
+	server.Flags().String("listen", "localhost:3000", "")
+	server.Flags().StringSlice("seed", nil, "")
+	server.Flags().Bool("txindex", false, "")
+	root.AddCommand(server)
+
+	return root
+}
+
+func TestConfigPrecedence(t *testing.T) {
+	dir, err := ioutil.TempDir("", "coin")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	configFile = filepath.Join(dir, "coin.toml")
+	defer func() {
+		configFile = ""
+	}()
+
+	err = ioutil.WriteFile(configFile, []byte(`
+network = "test"
+
+[server]
+address = "from-file"
+seed = ["localhost:1", "localhost:2"]
+txindex = true
+`), 0600)
+	require.NoError(t, err)
+
+	os.Setenv("COIN_SERVER_ADDRESS", "from-env")
+	os.Setenv("COIN_NETWORK", "regtest")
+	defer os.Unsetenv("COIN_SERVER_ADDRESS")
+	defer os.Unsetenv("COIN_NETWORK")
+
+	flags := make(map[string]interface{})
+	root := newConfigTestCmd(flags)
+	root.SetArgs([]string{"server", "--network", "main"})
+	require.NoError(t, root.Execute())
+
+	// Flags win over the environment, which wins over the file
+	assert.Equal(t, "main", flags["network"])
+	assert.Equal(t, "from-env", flags["address"])
+	assert.Equal(t, []string{"localhost:1", "localhost:2"}, flags["seed"])
+	assert.Equal(t, true, flags["txindex"])
+	assert.Equal(t, "localhost:3000", flags["listen"])
+}
+
+func TestConfigUnknownOption(t *testing.T) {
+	dir, err := ioutil.TempDir("", "coin")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	configFile = filepath.Join(dir, "coin.toml")
+	defer func() {
+		configFile = ""
+	}()
+
+	err = ioutil.WriteFile(configFile, []byte("[server]\nminer = \"typo\"\n"), 0600)
+	require.NoError(t, err)
+
+	root := newConfigTestCmd(make(map[string]interface{}))
+	root.SetArgs([]string{"server"})
+	root.SilenceErrors = true
+	root.SilenceUsage = true
+	assert.Error(t, root.Execute())
+}
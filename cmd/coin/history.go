@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"github.com/thesoenke/go-coin"
+	"github.com/thesoenke/go-coin/server"
+)
+
+var historyAddress string
+var cmdHistory = &cobra.Command{
+	Use:   "history",
+	Short: "List the outputs paid to an address and the transactions that spent them",
+	Run: func(cmd *cobra.Command, args []string) {
+		if !coin.ValidateAddress(historyAddress, netParams()) {
+			printErr(fmt.Errorf("address '%s' is not valid", historyAddress))
+		}
+
+		for _, out := range fetchHistory() {
+			fmt.Printf("%s:%d\theight %d\tvalue %d\t", out.Txid, out.Vout, out.Height, out.Value)
+			if out.SpentBy == "" {
+				fmt.Println("unspent")
+			} else {
+				fmt.Printf("spent by %s\n", out.SpentBy)
+			}
+		}
+	},
+}
+
+// fetchHistory returns the history of the address given with --address from
+// the node given with --rpcconnect or from the database
+func fetchHistory() []server.HistoryEntry {
+	if client := rpcClient(); client != nil {
+		history, err := client.AddressHistory(historyAddress)
+		printErr(err)
+
+		return history
+	}
+
+	bc, err := coin.NewBlockchain(dataDir(), nodeID, netParams())
+	printErr(err)
+	defer bc.DB.Close()
+
+	enabled, err := bc.HasAddrIndex()
+	printErr(err)
+	if !enabled {
+		printErr(fmt.Errorf("address index is not enabled, run 'coin reindex --addrindex' first"))
+	}
+
+	pubKeyHash := coin.Base58Decode([]byte(historyAddress))
+	pubKeyHash = pubKeyHash[1 : len(pubKeyHash)-4]
+	history, err := bc.AddressHistory(pubKeyHash)
+	printErr(err)
+
+	var entries []server.HistoryEntry
+	for _, out := range history {
+		entry := server.HistoryEntry{
+			Txid:   hex.EncodeToString(out.Txid),
+			Vout:   out.Vout,
+			Value:  out.Value,
+			Height: out.Height,
+		}
+		if out.SpentBy != nil {
+			entry.SpentBy = hex.EncodeToString(out.SpentBy)
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries
+}
+
+func init() {
+	cmdHistory.PersistentFlags().StringVar(&historyAddress, "address", "", "Address to list the history for")
+	RootCmd.AddCommand(cmdHistory)
+}
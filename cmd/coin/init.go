@@ -17,13 +17,9 @@ var cmdInit = &cobra.Command{
 			printErr(err)
 		}
 
-		bc, err := coin.CreateBlockchain(genesisRewardAddress, genesisNodeID)
-		printErr(err)
-		defer bc.DB.Close()
-
-		UTXOSet := coin.UTXOSet{Blockchain: bc}
-		err = UTXOSet.Reindex()
+		bc, err := coin.CreateBlockchain(dataDir(), genesisRewardAddress, genesisNodeID, netParams())
 		printErr(err)
+		bc.DB.Close()
 	},
 }
 
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"github.com/thesoenke/go-coin"
+)
+
+var invalidateHash string
+var cmdInvalidateBlock = &cobra.Command{
+	Use:   "invalidateblock",
+	Short: "Mark a block as invalid and roll back the chain",
+	Run: func(cmd *cobra.Command, args []string) {
+		blockHash, err := hex.DecodeString(invalidateHash)
+		printErr(err)
+
+		bc, err := coin.NewBlockchain(dataDir(), nodeID, netParams())
+		printErr(err)
+		defer bc.DB.Close()
+
+		err = bc.InvalidateBlock(blockHash)
+		printErr(err)
+
+		height, err := bc.GetBestHeight()
+		printErr(err)
+		fmt.Printf("Block %s invalidated. Best height is now %d\n", invalidateHash, height)
+	},
+}
+
+func init() {
+	cmdInvalidateBlock.PersistentFlags().StringVar(&invalidateHash, "hash", "", "Hash of the block to invalidate")
+	RootCmd.AddCommand(cmdInvalidateBlock)
+}
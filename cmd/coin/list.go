@@ -11,15 +11,22 @@ var cmdList = &cobra.Command{
 	Use:   "list",
 	Short: "List addresses stored in wallet file",
 	Run: func(cmd *cobra.Command, args []string) {
-		wallets, err := coin.NewWallets(nodeID)
-		if err != nil {
+		var addresses []string
+		if client := rpcClient(); client != nil {
+			var err error
+			addresses, err = client.ListAddresses()
 			printErr(err)
+		} else {
+			wallets, err := coin.NewWallets(dataDir(), nodeID)
+			if err != nil {
+				printErr(err)
+			}
+			addresses = wallets.GetAddresses()
 		}
 
-		addresses := wallets.GetAddresses()
 		for _, address := range addresses {
-			balance := getBalance(address)
-			fmt.Printf("Address: %s Balance: %d\n", address, balance)
+			balance, immature := getBalance(address)
+			fmt.Printf("Address: %s Balance: %d Immature: %d\n", address, balance, immature)
 		}
 	},
 }
@@ -7,12 +7,15 @@ import (
 	"github.com/thesoenke/go-coin"
 )
 
+var reindexTxIndex bool
+var reindexAddrIndex bool
 var cmdReindex = &cobra.Command{
 	Use:   "reindex",
 	Short: "Reindex unspent transactions (UTXO)",
 	Run: func(cmd *cobra.Command, args []string) {
-		bc, err := coin.NewBlockchain(nodeID)
+		bc, err := coin.NewBlockchain(dataDir(), nodeID, netParams())
 		printErr(err)
+		defer bc.DB.Close()
 
 		UTXOSet := coin.UTXOSet{Blockchain: bc}
 		err = UTXOSet.Reindex()
@@ -21,9 +24,23 @@ var cmdReindex = &cobra.Command{
 		count, err := UTXOSet.CountTransactions()
 		printErr(err)
 		fmt.Printf("Reindex of %d UTXO transactions successful\n", count)
+
+		if reindexTxIndex {
+			err = bc.ReindexTransactions()
+			printErr(err)
+			fmt.Println("Transaction index rebuilt")
+		}
+
+		if reindexAddrIndex {
+			err = bc.ReindexAddresses()
+			printErr(err)
+			fmt.Println("Address index rebuilt")
+		}
 	},
 }
 
 func init() {
+	cmdReindex.PersistentFlags().BoolVar(&reindexTxIndex, "txindex", false, "Enable and rebuild the transaction index")
+	cmdReindex.PersistentFlags().BoolVar(&reindexAddrIndex, "addrindex", false, "Enable and rebuild the address index")
 	RootCmd.AddCommand(cmdReindex)
 }
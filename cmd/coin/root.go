@@ -3,16 +3,24 @@ package main
 import (
 	"fmt"
 	"os"
+	"path/filepath"
 
 	"github.com/spf13/cobra"
+	"github.com/thesoenke/go-coin"
 )
 
 var nodeID int
+var network string
+var dataRoot string
+var configFile string
 
 // RootCmd represents the base command when called without any subcommands
 var RootCmd = &cobra.Command{
 	Use:   "coin",
 	Short: "CLI for coin",
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {
+		printErr(loadConfig(cmd))
+	},
 }
 
 // Execute adds all child commands to the root command sets flags appropriately.
@@ -26,4 +34,36 @@ func Execute() {
 
 func init() {
 	RootCmd.PersistentFlags().IntVar(&nodeID, "node", 1, "ID of the node to identify on a single machine")
+	RootCmd.PersistentFlags().StringVar(&network, "network", "main", "Network to use: main, test or regtest")
+	RootCmd.PersistentFlags().StringVar(&dataRoot, "datadir", defaultDataDir(), "Directory of the blockchain, wallets and config file")
+	RootCmd.PersistentFlags().StringVar(&configFile, "config", "", "Config file (default coin.toml in the data directory)")
+}
+
+// defaultDataDir returns the directory .coin in the home directory of the
+// user, or the working directory if there is no home directory
+func defaultDataDir() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "."
+	}
+
+	return filepath.Join(home, ".coin")
+}
+
+// netParams returns the parameters of the network selected by --network
+func netParams() *coin.ChainParams {
+	params, err := coin.ParamsByName(network)
+	printErr(err)
+
+	return params
+}
+
+// dataDir returns the directory of the files of the selected network
+func dataDir() string {
+	return filepath.Join(dataRoot, netParams().DataDir)
+}
+
+// defaultPeer returns the address of a local node of the selected network
+func defaultPeer() string {
+	return fmt.Sprintf("localhost:%d", netParams().DefaultPort)
 }
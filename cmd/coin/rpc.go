@@ -0,0 +1,61 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/thesoenke/go-coin/server"
+)
+
+var rpcConnect string
+var rpcUser string
+var rpcPassword string
+var cmdRPC = &cobra.Command{
+	Use:   "rpc <method> [params]",
+	Short: "Call a JSON-RPC method of the node given with --rpcconnect",
+	Long: `Call a JSON-RPC method of the node given with --rpcconnect. The params are
+a JSON object, for example:
+
+  coin rpc getblock '{"height": 1}'`,
+	Args: cobra.RangeArgs(1, 2),
+	Run: func(cmd *cobra.Command, args []string) {
+		client := rpcClient()
+		if client == nil {
+			printErr(fmt.Errorf("--rpcconnect is required"))
+		}
+
+		var params json.RawMessage
+		if len(args) == 2 {
+			params = json.RawMessage(args[1])
+			if !json.Valid(params) {
+				printErr(fmt.Errorf("params are not valid JSON"))
+			}
+		}
+
+		var result json.RawMessage
+		printErr(client.Call(args[0], params, &result))
+
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		printErr(encoder.Encode(result))
+	},
+}
+
+func init() {
+	RootCmd.PersistentFlags().StringVar(&rpcConnect, "rpcconnect", "", "Address of the RPC server of a running node. Commands use it instead of opening the database.")
+	RootCmd.PersistentFlags().StringVar(&rpcUser, "rpcuser", "", "User of the RPC server")
+	RootCmd.PersistentFlags().StringVar(&rpcPassword, "rpcpassword", "", "Password of the RPC server")
+	RootCmd.AddCommand(cmdRPC)
+}
+
+// rpcClient returns a client for the node given with --rpcconnect or nil if
+// commands open the database directly
+func rpcClient() *server.RPCClient {
+	if rpcConnect == "" {
+		return nil
+	}
+
+	return server.NewRPCClient(rpcConnect, rpcUser, rpcPassword)
+}
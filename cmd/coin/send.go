@@ -11,16 +11,18 @@ import (
 var sendFrom string
 var sendTo string
 var sendAmount int
+var sendFee int
 var mineNow bool
+var sendPeer string
 var cmdSend = &cobra.Command{
 	Use:   "send",
 	Short: "Send a transaction to an address",
 	Run: func(cmd *cobra.Command, args []string) {
-		if !coin.ValidateAddress(sendFrom) {
+		if !coin.ValidateAddress(sendFrom, netParams()) {
 			err := fmt.Errorf("sender address '%s' is not valid", sendFrom)
 			printErr(err)
 		}
-		if !coin.ValidateAddress(sendTo) {
+		if !coin.ValidateAddress(sendTo, netParams()) {
 			err := fmt.Errorf("receiver address '%s' is not valid", sendTo)
 			printErr(err)
 		}
@@ -29,31 +31,53 @@ var cmdSend = &cobra.Command{
 			err := fmt.Errorf("amount needs to be > 0")
 			printErr(err)
 		}
+		if sendFee < 0 {
+			err := fmt.Errorf("fee must not be negative")
+			printErr(err)
+		}
+
+		if client := rpcClient(); client != nil {
+			if mineNow {
+				printErr(fmt.Errorf("--mine cannot be used with --rpcconnect"))
+			}
 
-		bc, err := coin.NewBlockchain(nodeID)
+			txid, err := client.Send(sendFrom, sendTo, sendAmount, sendFee)
+			printErr(err)
+
+			fmt.Printf("Success! Transaction %s\n", txid)
+			return
+		}
+
+		bc, err := coin.NewBlockchain(dataDir(), nodeID, netParams())
 		printErr(err)
 		defer bc.DB.Close()
 
-		wallets, err := coin.NewWallets(nodeID)
+		wallets, err := coin.NewWallets(dataDir(), nodeID)
 		printErr(err)
 
 		wallet, err := wallets.GetWallet(sendFrom)
 		printErr(err)
 
 		UTXOSet := coin.UTXOSet{Blockchain: bc}
-		tx, err := coin.NewUTXOTransaction(&wallet, sendTo, sendAmount, &UTXOSet)
+		tx, err := coin.NewUTXOTransaction(&wallet, sendTo, sendAmount, sendFee, &UTXOSet)
 		printErr(err)
 
 		if mineNow {
-			cbTx := coin.NewCoinbaseTX(sendFrom, "")
-			txs := []*coin.Transaction{cbTx, tx}
-			newBlock, err := bc.MineBlock(txs)
+			height, err := bc.GetBestHeight()
 			printErr(err)
 
-			err = UTXOSet.Update(newBlock)
+			subsidy := coin.CalcBlockSubsidy(height+1, bc.Params())
+			cbTx := coin.NewCoinbaseTX(sendFrom, "", subsidy+sendFee)
+			txs := []*coin.Transaction{cbTx, tx}
+			_, err = bc.MineBlock(txs)
 			printErr(err)
 		} else {
-			server.SendTx(tx)
+			if sendPeer == "" {
+				sendPeer = defaultPeer()
+			}
+
+			err = server.SendTx(sendPeer, tx, netParams())
+			printErr(err)
 		}
 
 		fmt.Println("Success!")
@@ -64,6 +88,8 @@ func init() {
 	cmdSend.PersistentFlags().StringVar(&sendFrom, "from", "", "Sender of the transaction")
 	cmdSend.PersistentFlags().StringVar(&sendTo, "to", "", "Receiver of the transaction")
 	cmdSend.PersistentFlags().IntVar(&sendAmount, "amount", 0, "Amount that will be send")
+	cmdSend.PersistentFlags().IntVar(&sendFee, "fee", 0, "Fee that is paid to the miner of the transaction")
 	cmdSend.PersistentFlags().BoolVar(&mineNow, "mine", false, "Block will be mined by the sender node")
+	cmdSend.PersistentFlags().StringVar(&sendPeer, "peer", "", "Address of the node that relays the transaction (default localhost and the port of the network)")
 	RootCmd.AddCommand(cmdSend)
 }
@@ -9,22 +9,92 @@ import (
 )
 
 var minerAddress string
+var seeds []string
+var listenAddress string
+var serverTxIndex bool
+var serverAddrIndex bool
+var rpcListen string
+var restListen string
+var wsListen string
 var cmdServer = &cobra.Command{
 	Use:   "server",
 	Short: "Start a new node server",
 	Run: func(cmd *cobra.Command, args []string) {
-		if !coin.ValidateAddress(minerAddress) {
-			err := fmt.Errorf("miner address is not valid")
-			printErr(err)
+		if minerAddress != "" {
+			if !coin.ValidateAddress(minerAddress, netParams()) {
+				err := fmt.Errorf("miner address is not valid")
+				printErr(err)
+			}
+
+			fmt.Printf("Started mining. Address to receive rewards: %s\n", minerAddress)
+		}
+
+		if len(seeds) == 0 {
+			seeds = []string{defaultPeer()}
+		}
+
+		if listenAddress == "" {
+			listenAddress = fmt.Sprintf("localhost:%d", nodeID)
 		}
 
-		fmt.Printf("Started mining. Address to receive rewards: %s\n", minerAddress)
-		err := server.Start(nodeID, minerAddress)
+		bc, err := coin.NewBlockchain(dataDir(), nodeID, netParams())
+		printErr(err)
+		defer bc.DB.Close()
+
+		enableIndexes(bc)
+
+		err = server.Start(bc, server.Config{
+			Address:       listenAddress,
+			MiningAddress: minerAddress,
+			Seeds:         seeds,
+			PeersFile:     server.PeersFile(dataDir(), nodeID),
+			BanFile:       server.BanFile(dataDir(), nodeID),
+			RPC: server.RPCConfig{
+				Address:   rpcListen,
+				User:      rpcUser,
+				Password:  rpcPassword,
+				WalletDir: dataDir(),
+				WalletID:  nodeID,
+			},
+			RESTAddress: restListen,
+			WSAddress:   wsListen,
+		})
 		printErr(err)
 	},
 }
 
 func init() {
-	cmdServer.PersistentFlags().StringVar(&minerAddress, "address", "", "Address of the miner for rewards")
+	cmdServer.PersistentFlags().StringVar(&minerAddress, "address", "", "Address of the miner for rewards, the node does not mine without one")
+	cmdServer.PersistentFlags().StringSliceVar(&seeds, "seed", nil, "Addresses of nodes to discover the network (default localhost and the port of the network)")
+	cmdServer.PersistentFlags().StringVar(&listenAddress, "listen", "", "Address the node listens on (default localhost and the node ID as port)")
+	cmdServer.PersistentFlags().BoolVar(&serverTxIndex, "txindex", false, "Build the transaction index if it is not enabled")
+	cmdServer.PersistentFlags().BoolVar(&serverAddrIndex, "addrindex", false, "Build the address index if it is not enabled")
+	cmdServer.PersistentFlags().StringVar(&rpcListen, "rpclisten", "", "Address of the JSON-RPC server, which requires --rpcuser and --rpcpassword (default disabled)")
+	cmdServer.PersistentFlags().StringVar(&restListen, "restlisten", "", "Address of the read-only REST API (default disabled)")
+	cmdServer.PersistentFlags().StringVar(&wsListen, "wslisten", "", "Address of the WebSocket event stream (default disabled)")
 	RootCmd.AddCommand(cmdServer)
 }
+
+// enableIndexes builds the indexes requested by --txindex and --addrindex that
+// are not enabled yet. Enabled indexes are kept in sync by the blockchain.
+func enableIndexes(bc *coin.Blockchain) {
+	if serverTxIndex {
+		enabled, err := bc.HasTxIndex()
+		printErr(err)
+
+		if !enabled {
+			fmt.Println("Building the transaction index")
+			printErr(bc.ReindexTransactions())
+		}
+	}
+
+	if serverAddrIndex {
+		enabled, err := bc.HasAddrIndex()
+		printErr(err)
+
+		if !enabled {
+			fmt.Println("Building the address index")
+			printErr(bc.ReindexAddresses())
+		}
+	}
+}
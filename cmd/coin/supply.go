@@ -0,0 +1,44 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"github.com/thesoenke/go-coin"
+)
+
+var cmdSupply = &cobra.Command{
+	Use:   "supply",
+	Short: "Show the circulating supply and check it against the expected emission",
+	Run: func(cmd *cobra.Command, args []string) {
+		bc, err := coin.NewBlockchain(dataDir(), nodeID, netParams())
+		printErr(err)
+		defer bc.DB.Close()
+
+		height, err := bc.GetBestHeight()
+		printErr(err)
+
+		UTXOSet := coin.UTXOSet{Blockchain: bc}
+		circulating, err := UTXOSet.TotalValue()
+		printErr(err)
+
+		params := bc.Params()
+		expected := coin.ExpectedSupply(height, params)
+
+		fmt.Printf("Height:              %d\n", height)
+		fmt.Printf("Block subsidy:       %d\n", coin.CalcBlockSubsidy(height, params))
+		fmt.Printf("Circulating supply:  %d\n", circulating)
+		fmt.Printf("Expected emission:   %d\n", expected)
+		fmt.Printf("Unclaimed subsidies: %d\n", expected-circulating)
+		fmt.Printf("Maximum supply:      %d\n", params.MaxSupply())
+
+		// Coinbases may claim less than the subsidy, but never more
+		if circulating > expected {
+			printErr(fmt.Errorf("circulating supply exceeds the expected emission by %d", circulating-expected))
+		}
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(cmdSupply)
+}
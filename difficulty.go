@@ -0,0 +1,99 @@
+package coin
+
+import (
+	"math/big"
+)
+
+// CompactToBig converts a target in compact form to a big integer. The
+// compact form uses the most significant byte as exponent and the lower
+// three bytes as mantissa, so that target = mantissa * 256^(exponent-3).
+func CompactToBig(compact uint32) *big.Int {
+	mantissa := compact & 0x007fffff
+	isNegative := compact&0x00800000 != 0
+	exponent := uint(compact >> 24)
+
+	var bn *big.Int
+	if exponent <= 3 {
+		mantissa >>= 8 * (3 - exponent)
+		bn = big.NewInt(int64(mantissa))
+	} else {
+		bn = big.NewInt(int64(mantissa))
+		bn.Lsh(bn, 8*(exponent-3))
+	}
+
+	if isNegative {
+		bn = bn.Neg(bn)
+	}
+
+	return bn
+}
+
+// BigToCompact converts a target to its compact form
+func BigToCompact(n *big.Int) uint32 {
+	if n.Sign() == 0 {
+		return 0
+	}
+
+	var mantissa uint32
+	exponent := uint(len(n.Bytes()))
+	if exponent <= 3 {
+		mantissa = uint32(n.Bits()[0])
+		mantissa <<= 8 * (3 - exponent)
+	} else {
+		tn := new(big.Int).Set(n)
+		mantissa = uint32(tn.Rsh(tn, 8*(exponent-3)).Bits()[0])
+	}
+
+	// The sign bit is set, so shift the mantissa and increase the exponent
+	if mantissa&0x00800000 != 0 {
+		mantissa >>= 8
+		exponent++
+	}
+
+	compact := uint32(exponent<<24) | mantissa
+	if n.Sign() < 0 {
+		compact |= 0x00800000
+	}
+
+	return compact
+}
+
+// calcNextRequiredBits returns the bits a child of the parent header has to be
+// mined with. The difficulty is adjusted every retarget interval based on the
+// time the blocks of the last interval took. The ancestors of the parent are
+// looked up in the header index.
+func calcNextRequiredBits(h StoreBucket, parent *headerNode, params *ChainParams) (uint32, error) {
+	interval := params.RetargetInterval()
+	if params.NoRetargeting || (parent.Height+1)%interval != 0 {
+		return parent.Bits, nil
+	}
+
+	first := parent
+	var err error
+	for i := 0; i < interval-1; i++ {
+		first, err = getHeaderNode(h, first.PrevBlockHash)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	minTimespan := params.TargetTimespan / params.RetargetAdjustmentFactor
+	maxTimespan := params.TargetTimespan * params.RetargetAdjustmentFactor
+
+	actualTimespan := parent.Timestamp - first.Timestamp
+	if actualTimespan < minTimespan {
+		actualTimespan = minTimespan
+	} else if actualTimespan > maxTimespan {
+		actualTimespan = maxTimespan
+	}
+
+	newTarget := CompactToBig(parent.Bits)
+	newTarget.Mul(newTarget, big.NewInt(actualTimespan))
+	newTarget.Div(newTarget, big.NewInt(params.TargetTimespan))
+
+	if newTarget.Cmp(params.PowLimit) > 0 {
+		newTarget.Set(params.PowLimit)
+	}
+
+	return BigToCompact(newTarget), nil
+}
@@ -0,0 +1,130 @@
+package coin
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompactToBig(t *testing.T) {
+	tests := []struct {
+		compact uint32
+		n       string
+	}{
+		{0x00000000, "0"},
+		{0x01123456, "12"},
+		{0x02008000, "80"},
+		{0x03123456, "123456"},
+		{0x04123456, "12345600"},
+		{0x04923456, "-12345600"},
+		{0x05009234, "92340000"},
+		{0x1d00ffff, "ffff0000000000000000000000000000000000000000000000000000"},
+	}
+
+	for _, test := range tests {
+		n, ok := new(big.Int).SetString(test.n, 16)
+		require.True(t, ok)
+		assert.Equal(t, 0, n.Cmp(CompactToBig(test.compact)), "compact %08x", test.compact)
+	}
+}
+
+func TestBigToCompact(t *testing.T) {
+	tests := []struct {
+		n       string
+		compact uint32
+	}{
+		{"0", 0x00000000},
+		{"12", 0x01120000},
+		{"80", 0x02008000},
+		{"123456", 0x03123456},
+		{"12345600", 0x04123456},
+		{"-12345600", 0x04923456},
+		{"92340000", 0x05009234},
+		{"ffff0000000000000000000000000000000000000000000000000000", 0x1d00ffff},
+	}
+
+	for _, test := range tests {
+		n, ok := new(big.Int).SetString(test.n, 16)
+		require.True(t, ok)
+		assert.Equal(t, test.compact, BigToCompact(n), "target %s", test.n)
+	}
+
+	// Only the three most significant bytes survive the round trip
+	n := new(big.Int).Lsh(big.NewInt(0x123456789), 100)
+	truncated := CompactToBig(BigToCompact(n))
+	assert.Equal(t, new(big.Int).Lsh(big.NewInt(0x123456), 112), truncated)
+	assert.Equal(t, BigToCompact(n), BigToCompact(truncated))
+}
+
+func TestCalcNextRequiredBits(t *testing.T) {
+	params := &ChainParams{
+		PowLimit:                 new(big.Int).Lsh(big.NewInt(1), 201),
+		TargetTimespan:           100,
+		TargetSpacing:            10,
+		RetargetAdjustmentFactor: 4,
+	}
+	target := new(big.Int).Lsh(big.NewInt(1), 199)
+	bits := BigToCompact(target)
+
+	// nextBits stores a chain of one retarget interval whose last block is
+	// timespan seconds after the first and returns the bits of its child
+	nextBits := func(timespan int64, height int) uint32 {
+		var next uint32
+
+		err := NewMemStore().Update(func(tx StoreTx) error {
+			h, err := tx.CreateBucket([]byte(headersBucket))
+			require.NoError(t, err)
+			_, err = tx.CreateBucket([]byte(chainWorkBucket))
+			require.NoError(t, err)
+
+			var parent *headerNode
+			prevHash := []byte{}
+			start := height - params.RetargetInterval() + 1
+			for i := start; i <= height; i++ {
+				header := &BlockHeader{Version: blockHeaderVersion, PrevBlockHash: prevHash, Bits: bits}
+				if i == height {
+					header.Timestamp = timespan
+				}
+				_, err = putHeader(tx, header, i)
+				require.NoError(t, err)
+
+				parent, err = getHeaderNode(h, header.BlockHash())
+				require.NoError(t, err)
+				prevHash = parent.Hash
+			}
+
+			next, err = calcNextRequiredBits(h, parent, params)
+			return err
+		})
+		require.NoError(t, err)
+
+		return next
+	}
+	shifted := func(shift int) uint32 {
+		if shift < 0 {
+			return BigToCompact(new(big.Int).Rsh(target, uint(-shift)))
+		}
+		return BigToCompact(new(big.Int).Lsh(target, uint(shift)))
+	}
+
+	assert.Equal(t, bits, nextBits(100, 9))
+	assert.Equal(t, shifted(-1), nextBits(50, 9))
+	assert.Equal(t, shifted(1), nextBits(200, 9))
+
+	// The timespan is clamped to a quarter and four times the target timespan
+	assert.Equal(t, shifted(-2), nextBits(25, 9))
+	assert.Equal(t, shifted(-2), nextBits(1, 9))
+	assert.Equal(t, shifted(-2), nextBits(-1000, 9))
+
+	// The target never exceeds the proof of work limit
+	assert.Equal(t, BigToCompact(params.PowLimit), nextBits(400, 9))
+	assert.Equal(t, BigToCompact(params.PowLimit), nextBits(10000, 9))
+
+	// The difficulty only changes at the end of an interval
+	assert.Equal(t, bits, nextBits(10, 8))
+	assert.Equal(t, bits, nextBits(10, 10))
+	params.NoRetargeting = true
+	assert.Equal(t, bits, nextBits(10, 9))
+}
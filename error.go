@@ -0,0 +1,158 @@
+package coin
+
+import "fmt"
+
+// ErrorCode identifies the consensus rule a block or transaction violates
+type ErrorCode int
+
+const (
+	// ErrMissingParent indicates that the parent of a block is not known
+	ErrMissingParent ErrorCode = iota
+
+	// ErrInvalidAncestor indicates that a block builds on an invalid block
+	ErrInvalidAncestor
+
+	// ErrBadHeight indicates that the height of a block is not the height of
+	// its parent plus one
+	ErrBadHeight
+
+	// ErrBadHash indicates that the hash of a block does not match its data
+	ErrBadHash
+
+	// ErrBadBits indicates that the target of a block is not positive or
+	// higher than the proof of work limit
+	ErrBadBits
+
+	// ErrUnexpectedDifficulty indicates that the bits of a block do not match
+	// the difficulty computed from its ancestors
+	ErrUnexpectedDifficulty
+
+	// ErrHighHash indicates that the hash of a block does not meet the target
+	ErrHighHash
+
+	// ErrBadMerkleRoot indicates that the merkle root of a block does not
+	// match its transactions
+	ErrBadMerkleRoot
+
+	// ErrNoTransactions indicates that a block has no transactions
+	ErrNoTransactions
+
+	// ErrFirstTxNotCoinbase indicates that the first transaction of a block
+	// is not a coinbase
+	ErrFirstTxNotCoinbase
+
+	// ErrMultipleCoinbases indicates that a block has more than one coinbase
+	ErrMultipleCoinbases
+
+	// ErrBadCoinbaseValue indicates that a coinbase pays out more than the
+	// subsidy plus the fees of the block
+	ErrBadCoinbaseValue
+
+	// ErrBadTxID indicates that the ID of a transaction does not match its hash
+	ErrBadTxID
+
+	// ErrBadTxOutValue indicates a negative output value or output values
+	// that exceed the maximum supply on their own or in sum
+	ErrBadTxOutValue
+
+	// ErrNoTxInputs indicates a transaction without inputs
+	ErrNoTxInputs
+
+	// ErrNoTxOutputs indicates a transaction without outputs
+	ErrNoTxOutputs
+
+	// ErrMissingInput indicates that an input references an unknown or
+	// already spent output
+	ErrMissingInput
+
+	// ErrDoubleSpend indicates that an output is spent twice in a block
+	ErrDoubleSpend
+
+	// ErrSpendTooHigh indicates that a transaction spends more than its inputs
+	ErrSpendTooHigh
+
+	// ErrBadSignature indicates that an input signature is not valid
+	ErrBadSignature
+
+	// ErrBadBlockVersion indicates that the version of a block header is not
+	// supported
+	ErrBadBlockVersion
+
+	// ErrBlockTooBig indicates that a serialized block is larger than
+	// MaxBlockSize
+	ErrBlockTooBig
+
+	// ErrImmatureSpend indicates that a transaction spends a coinbase output
+	// before it reached the coinbase maturity
+	ErrImmatureSpend
+
+	// ErrBadFees indicates a negative transaction fee or fees of a block that
+	// exceed the maximum supply
+	ErrBadFees
+
+	// ErrOverwriteTx indicates a transaction with the same ID as a transaction
+	// that still has unspent outputs
+	ErrOverwriteTx
+
+	// ErrTimeTooOld indicates that the timestamp of a block is not after the
+	// median timestamp of its recent ancestors
+	ErrTimeTooOld
+
+	// ErrTimeTooNew indicates that the timestamp of a block is too far ahead
+	// of the local time
+	ErrTimeTooNew
+)
+
+var errorCodeStrings = map[ErrorCode]string{
+	ErrMissingParent:        "ErrMissingParent",
+	ErrInvalidAncestor:      "ErrInvalidAncestor",
+	ErrBadHeight:            "ErrBadHeight",
+	ErrBadHash:              "ErrBadHash",
+	ErrBadBits:              "ErrBadBits",
+	ErrUnexpectedDifficulty: "ErrUnexpectedDifficulty",
+	ErrHighHash:             "ErrHighHash",
+	ErrBadMerkleRoot:        "ErrBadMerkleRoot",
+	ErrNoTransactions:       "ErrNoTransactions",
+	ErrFirstTxNotCoinbase:   "ErrFirstTxNotCoinbase",
+	ErrMultipleCoinbases:    "ErrMultipleCoinbases",
+	ErrBadCoinbaseValue:     "ErrBadCoinbaseValue",
+	ErrBadTxID:              "ErrBadTxID",
+	ErrBadTxOutValue:        "ErrBadTxOutValue",
+	ErrNoTxInputs:           "ErrNoTxInputs",
+	ErrNoTxOutputs:          "ErrNoTxOutputs",
+	ErrMissingInput:         "ErrMissingInput",
+	ErrDoubleSpend:          "ErrDoubleSpend",
+	ErrSpendTooHigh:         "ErrSpendTooHigh",
+	ErrBadSignature:         "ErrBadSignature",
+	ErrBadBlockVersion:      "ErrBadBlockVersion",
+	ErrBlockTooBig:          "ErrBlockTooBig",
+	ErrImmatureSpend:        "ErrImmatureSpend",
+	ErrBadFees:              "ErrBadFees",
+	ErrOverwriteTx:          "ErrOverwriteTx",
+	ErrTimeTooOld:           "ErrTimeTooOld",
+	ErrTimeTooNew:           "ErrTimeTooNew",
+}
+
+// String returns the name of the ErrorCode
+func (e ErrorCode) String() string {
+	if s := errorCodeStrings[e]; s != "" {
+		return s
+	}
+
+	return fmt.Sprintf("Unknown ErrorCode (%d)", int(e))
+}
+
+// RuleError describes why a block or transaction was rejected
+type RuleError struct {
+	ErrorCode   ErrorCode
+	Description string
+}
+
+// Error satisfies the error interface
+func (e RuleError) Error() string {
+	return e.Description
+}
+
+func ruleError(c ErrorCode, desc string) RuleError {
+	return RuleError{ErrorCode: c, Description: desc}
+}
@@ -3,8 +3,11 @@ module github.com/thesoenke/go-coin
 go 1.14
 
 require (
+	github.com/BurntSushi/toml v0.3.1
 	github.com/boltdb/bolt v1.3.1
+	github.com/gorilla/websocket v1.4.2
 	github.com/spf13/cobra v0.0.6
+	github.com/spf13/pflag v1.0.3
 	github.com/stretchr/testify v1.2.2
 	golang.org/x/crypto v0.17.0
 )
@@ -0,0 +1,267 @@
+package coin
+
+import (
+	"bytes"
+	"fmt"
+	"math/big"
+
+	"github.com/thesoenke/go-coin/wire"
+)
+
+const (
+	headersBucket     = "headers"
+	headerNodeVersion = 1
+)
+
+// headerNode is an entry of the header index. Every header that passed
+// validation is stored, whether the body of its block is known or not.
+type headerNode struct {
+	BlockHeader
+	Hash   []byte
+	Height int
+}
+
+func (n *headerNode) serialize() []byte {
+	w := &wire.Writer{}
+	w.WriteUint8(headerNodeVersion)
+	n.BlockHeader.serialize(w)
+	w.WriteInt64(int64(n.Height))
+
+	return w.Bytes()
+}
+
+func deserializeHeaderNode(d []byte) (*headerNode, error) {
+	var node headerNode
+
+	r := wire.NewReader(d)
+	r.ReadVersion(headerNodeVersion)
+	node.BlockHeader = readBlockHeader(r)
+	node.Height = int(r.ReadInt64())
+
+	err := r.Finish()
+	if err != nil {
+		return nil, err
+	}
+
+	node.Hash = node.BlockHash()
+	return &node, nil
+}
+
+// AddHeaders validates the headers and adds them to the header index. The
+// headers have to be ordered so that every parent comes before its children.
+// Headers that are already known are skipped. If a header is invalid, none
+// of the headers are added.
+func (bc *Blockchain) AddHeaders(headers []*BlockHeader) error {
+	return bc.DB.Update(func(tx StoreTx) error {
+		h := tx.Bucket([]byte(headersBucket))
+
+		for _, header := range headers {
+			if h.Get(header.BlockHash()) != nil {
+				continue
+			}
+
+			err := CheckHeaderSanity(header, bc.params)
+			if err != nil {
+				return err
+			}
+
+			parent, err := checkHeaderContext(tx, header, bc.params)
+			if err != nil {
+				return err
+			}
+
+			_, err = putHeader(tx, header, parent.Height+1)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// BestHeaderHash returns the hash of the header with the most cumulative
+// work. Its block is not necessarily known.
+func (bc *Blockchain) BestHeaderHash() ([]byte, error) {
+	var hash []byte
+
+	err := bc.DB.View(func(tx StoreTx) error {
+		hash = append(hash, tx.Bucket([]byte(headersBucket)).Get([]byte("h"))...)
+		return nil
+	})
+
+	return hash, err
+}
+
+// BlockLocator returns hashes of the header with the given hash and its
+// ancestors, ordered from the newest to the oldest. The first ten hashes are
+// consecutive, after that the distance doubles with every step. The genesis
+// hash is always the last element. A peer uses the locator to find the last
+// block both nodes have in common.
+func (bc *Blockchain) BlockLocator(hash []byte) ([][]byte, error) {
+	var locator [][]byte
+
+	err := bc.DB.View(func(tx StoreTx) error {
+		h := tx.Bucket([]byte(headersBucket))
+		node, err := getHeaderNode(h, hash)
+		if err != nil {
+			return err
+		}
+
+		step := 1
+		for {
+			locator = append(locator, node.Hash)
+			if len(node.PrevBlockHash) == 0 {
+				return nil
+			}
+
+			if len(locator) >= 10 {
+				step *= 2
+			}
+
+			for i := 0; i < step && len(node.PrevBlockHash) > 0; i++ {
+				node, err = getHeaderNode(h, node.PrevBlockHash)
+				if err != nil {
+					return err
+				}
+			}
+		}
+	})
+
+	return locator, err
+}
+
+// GetHeaders returns up to max headers of the main chain that follow the
+// last block of the locator that is part of the main chain. If no block of the
+// locator is known, the headers following the genesis block are returned.
+func (bc *Blockchain) GetHeaders(locator [][]byte, max int) ([]*BlockHeader, error) {
+	var headers []*BlockHeader
+
+	err := bc.DB.View(func(tx StoreTx) error {
+		h := tx.Bucket([]byte(headersBucket))
+		forkHeight := locateFork(tx, locator)
+
+		tipHeight, err := bestHeight(tx)
+		if err != nil {
+			return err
+		}
+
+		// The height index is walked up from the fork point, so no more than
+		// max headers are read however far behind the peer is
+		for height := forkHeight + 1; height <= tipHeight && len(headers) < max; height++ {
+			hash, err := blockHashAtHeight(tx, height)
+			if err != nil {
+				return err
+			}
+
+			node, err := getHeaderNode(h, hash)
+			if err != nil {
+				return err
+			}
+			headers = append(headers, &node.BlockHeader)
+		}
+
+		return nil
+	})
+
+	return headers, err
+}
+
+// locateFork returns the height of the first block of the locator that is
+// part of the main chain. Both chains start with the genesis block, so its
+// height is returned if no block of the locator is found.
+func locateFork(tx StoreTx, locator [][]byte) int {
+	h := tx.Bucket([]byte(headersBucket))
+	heights := tx.Bucket([]byte(heightsBucket))
+
+	for _, hash := range locator {
+		node, err := getHeaderNode(h, hash)
+		if err != nil {
+			continue
+		}
+
+		if bytes.Compare(heights.Get(heightKey(node.Height)), node.Hash) == 0 {
+			return node.Height
+		}
+	}
+
+	return 0
+}
+
+// MissingBlocks returns the hashes of the blocks of the best header chain
+// whose bodies are not known yet, ordered from the oldest to the newest
+func (bc *Blockchain) MissingBlocks() ([][]byte, error) {
+	var missing [][]byte
+
+	err := bc.DB.View(func(tx StoreTx) error {
+		b := tx.Bucket([]byte(blocksBucket))
+		h := tx.Bucket([]byte(headersBucket))
+		inv := tx.Bucket([]byte(invalidBucket))
+
+		hash := h.Get([]byte("h"))
+		for b.Get(hash) == nil {
+			if inv.Get(hash) != nil {
+				// The best header chain contains an invalid block
+				missing = nil
+				return nil
+			}
+
+			missing = append([][]byte{append([]byte{}, hash...)}, missing...)
+
+			node, err := getHeaderNode(h, hash)
+			if err != nil {
+				return err
+			}
+			hash = node.PrevBlockHash
+		}
+
+		return nil
+	})
+
+	return missing, err
+}
+
+// putHeader adds the header to the header index and stores its cumulative
+// work. The best header is updated if the header has more work. The parent of
+// the header has to be in the index already.
+func putHeader(tx StoreTx, header *BlockHeader, height int) (*big.Int, error) {
+	h := tx.Bucket([]byte(headersBucket))
+	w := tx.Bucket([]byte(chainWorkBucket))
+
+	node := &headerNode{BlockHeader: *header, Hash: header.BlockHash(), Height: height}
+	if h.Get(node.Hash) != nil {
+		return new(big.Int).SetBytes(w.Get(node.Hash)), nil
+	}
+
+	work := new(big.Int)
+	if len(header.PrevBlockHash) > 0 {
+		work.SetBytes(w.Get(header.PrevBlockHash))
+	}
+	work.Add(work, NewProofOfWork(header).Work())
+
+	err := h.Put(node.Hash, node.serialize())
+	if err != nil {
+		return nil, err
+	}
+
+	err = w.Put(node.Hash, work.Bytes())
+	if err != nil {
+		return nil, err
+	}
+
+	bestHash := h.Get([]byte("h"))
+	if bestHash != nil && work.Cmp(new(big.Int).SetBytes(w.Get(bestHash))) <= 0 {
+		return work, nil
+	}
+
+	return work, h.Put([]byte("h"), node.Hash)
+}
+
+func getHeaderNode(b StoreBucket, hash []byte) (*headerNode, error) {
+	data := b.Get(hash)
+	if data == nil {
+		return nil, fmt.Errorf("header %x not found", hash)
+	}
+
+	return deserializeHeaderNode(data)
+}
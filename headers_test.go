@@ -0,0 +1,44 @@
+package coin
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetHeaders(t *testing.T) {
+	bc, wallet, params := newTestChain(t)
+	blocks := mineBlocks(t, bc, wallet, 5)
+
+	// A side branch that forks off after the second block
+	side := mineBlockOn(t, bc, blocks[1], []*Transaction{NewCoinbaseTX(string(wallet.GetAddress(params)), "", CalcBlockSubsidy(3, params))})
+
+	hashes := func(headers []*BlockHeader) [][]byte {
+		var hashes [][]byte
+		for _, header := range headers {
+			hashes = append(hashes, header.BlockHash())
+		}
+		return hashes
+	}
+
+	locator, err := bc.BlockLocator(side.Hash)
+	require.NoError(t, err)
+
+	headers, err := bc.GetHeaders(locator, 10)
+	require.NoError(t, err)
+	assert.Equal(t, [][]byte{blocks[2].Hash, blocks[3].Hash, blocks[4].Hash}, hashes(headers))
+
+	headers, err = bc.GetHeaders(locator, 2)
+	require.NoError(t, err)
+	assert.Equal(t, [][]byte{blocks[2].Hash, blocks[3].Hash}, hashes(headers))
+
+	// Unknown hashes are skipped and the genesis block is always shared
+	headers, err = bc.GetHeaders([][]byte{{1, 2, 3}}, 1)
+	require.NoError(t, err)
+	assert.Equal(t, [][]byte{blocks[0].Hash}, hashes(headers))
+
+	headers, err = bc.GetHeaders([][]byte{blocks[4].Hash}, 10)
+	require.NoError(t, err)
+	assert.Empty(t, headers)
+}
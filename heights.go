@@ -0,0 +1,104 @@
+package coin
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// heightsBucket maps the height of every block of the main chain to its hash
+const heightsBucket = "heights"
+
+func heightKey(height int) []byte {
+	var key [8]byte
+	binary.BigEndian.PutUint64(key[:], uint64(height))
+	return key[:]
+}
+
+// GetBlockHash returns the hash of the block of the main chain at the height
+func (bc *Blockchain) GetBlockHash(height int) ([]byte, error) {
+	var hash []byte
+
+	err := bc.DB.View(func(tx StoreTx) error {
+		var err error
+		hash, err = blockHashAtHeight(tx, height)
+		return err
+	})
+
+	return hash, err
+}
+
+// GetBlockByHeight returns the block of the main chain at the height
+func (bc *Blockchain) GetBlockByHeight(height int) (Block, error) {
+	var block Block
+
+	err := bc.DB.View(func(tx StoreTx) error {
+		hash, err := blockHashAtHeight(tx, height)
+		if err != nil {
+			return err
+		}
+
+		blockPtr, err := getBlock(tx.Bucket([]byte(blocksBucket)), hash)
+		if err != nil {
+			return err
+		}
+
+		block = *blockPtr
+		return nil
+	})
+
+	return block, err
+}
+
+func blockHashAtHeight(tx StoreTx, height int) ([]byte, error) {
+	if height < 0 {
+		return nil, fmt.Errorf("no block at height %d", height)
+	}
+
+	hash := tx.Bucket([]byte(heightsBucket)).Get(heightKey(height))
+	if hash == nil {
+		return nil, fmt.Errorf("no block at height %d", height)
+	}
+
+	// The slice is only valid during the transaction
+	return append([]byte{}, hash...), nil
+}
+
+// buildHeightIndex creates the height index from the blocks of the main
+// chain. Databases created before the index existed get it when they are
+// opened.
+func buildHeightIndex(tx StoreTx) error {
+	_, err := tx.CreateBucket([]byte(heightsBucket))
+	if err != nil {
+		return err
+	}
+
+	b := tx.Bucket([]byte(blocksBucket))
+	hash := b.Get([]byte("l"))
+	for len(hash) > 0 {
+		block, err := getBlock(b, hash)
+		if err != nil {
+			return err
+		}
+
+		err = connectHeightIndex(tx, block)
+		if err != nil {
+			return err
+		}
+
+		hash = block.PrevBlockHash
+	}
+
+	return nil
+}
+
+// connectHeightIndex records a block that was connected to the main chain
+func connectHeightIndex(tx StoreTx, block *Block) error {
+	return tx.Bucket([]byte(heightsBucket)).Put(heightKey(block.Height), block.Hash)
+}
+
+// disconnectHeightIndex removes a block that was disconnected from the main
+// chain. Blocks are disconnected from the tip downwards, so the block is
+// always the highest entry.
+func disconnectHeightIndex(tx StoreTx, block *Block) error {
+	return tx.Bucket([]byte(heightsBucket)).Delete(heightKey(block.Height))
+}
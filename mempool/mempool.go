@@ -0,0 +1,464 @@
+// Package mempool keeps the validated transactions that wait to be included
+// in a block.
+package mempool
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	coin "github.com/thesoenke/go-coin"
+)
+
+var (
+	// ErrDuplicate is returned if the transaction is already in the mempool
+	ErrDuplicate = errors.New("transaction is already in the mempool")
+
+	// ErrCoinbase is returned for coinbase transactions, which are only valid
+	// in a block
+	ErrCoinbase = errors.New("coinbase transactions are not accepted")
+
+	// ErrConflict is returned if the transaction spends an output that is
+	// already spent by a transaction in the mempool
+	ErrConflict = errors.New("transaction conflicts with a transaction in the mempool")
+)
+
+// UTXOSource looks up unspent outputs of the main chain
+type UTXOSource interface {
+	// FetchEntry returns nil if the output does not exist or is spent
+	FetchEntry(txid []byte, vout int) (*coin.UtxoEntry, error)
+
+	// BestHeight returns the height of the tip of the main chain
+	BestHeight() (int, error)
+}
+
+// TxDesc describes a transaction in the mempool
+type TxDesc struct {
+	Tx    *coin.Transaction
+	Added time.Time
+	Fee   int
+
+	// Size is the length of the serialized transaction in bytes
+	Size int
+
+	// seq orders the transactions by the time they were added, so parents
+	// come before the transactions that spend them
+	seq uint64
+
+	// matureHeight is the lowest height of a block that may include the
+	// transaction, as it spends coinbase outputs
+	matureHeight int
+}
+
+// NotificationType identifies the kind of a mempool notification
+type NotificationType int
+
+const (
+	// NTTxAccepted indicates that a transaction was added to the mempool
+	NTTxAccepted NotificationType = iota
+
+	// NTTxRemoved indicates that a transaction left the mempool because it
+	// was mined, conflicts with a mined transaction or became invalid
+	NTTxRemoved
+)
+
+// Notification describes a change of the mempool
+type Notification struct {
+	Type NotificationType
+	Tx   *coin.Transaction
+}
+
+// NotificationCallback is called for every notification of the mempool
+type NotificationCallback func(*Notification)
+
+// outpoint identifies a transaction output
+type outpoint struct {
+	txid string
+	vout int
+}
+
+// Mempool holds transactions that spend unspent outputs of the main chain or
+// outputs of other transactions in the mempool. No two transactions in the
+// mempool spend the same output. It is safe for concurrent use.
+type Mempool struct {
+	mu      sync.RWMutex
+	utxos   UTXOSource
+	params  *coin.ChainParams
+	pool    map[string]*TxDesc
+	spentBy map[outpoint]*coin.Transaction
+	nextSeq uint64
+
+	subscribers []NotificationCallback
+}
+
+// New returns an empty mempool that validates transactions against utxos
+func New(utxos UTXOSource, params *coin.ChainParams) *Mempool {
+	return &Mempool{
+		utxos:   utxos,
+		params:  params,
+		pool:    make(map[string]*TxDesc),
+		spentBy: make(map[outpoint]*coin.Transaction),
+	}
+}
+
+// Subscribe registers a callback for transactions that are added to and
+// removed from the mempool. Callbacks are called while the mempool is locked,
+// so a callback must not call methods of the mempool.
+func (mp *Mempool) Subscribe(callback NotificationCallback) {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+
+	mp.subscribers = append(mp.subscribers, callback)
+}
+
+// notify calls the subscribers. It has to be called with mu held.
+func (mp *Mempool) notify(notificationType NotificationType, tx *coin.Transaction) {
+	for _, callback := range mp.subscribers {
+		callback(&Notification{Type: notificationType, Tx: tx})
+	}
+}
+
+// ProcessTransaction validates the transaction and adds it to the mempool.
+// Transactions that violate a consensus rule are rejected with a
+// coin.RuleError.
+func (mp *Mempool) ProcessTransaction(tx *coin.Transaction) (*TxDesc, error) {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+
+	return mp.processTransaction(tx)
+}
+
+func (mp *Mempool) processTransaction(tx *coin.Transaction) (*TxDesc, error) {
+	id := hex.EncodeToString(tx.ID)
+	if mp.pool[id] != nil {
+		return nil, ErrDuplicate
+	}
+
+	if tx.IsCoinbase() {
+		return nil, ErrCoinbase
+	}
+
+	err := coin.CheckTransactionSanity(tx, mp.params)
+	if err != nil {
+		return nil, err
+	}
+
+	best, err := mp.utxos.BestHeight()
+	if err != nil {
+		return nil, err
+	}
+	spendHeight := best + 1
+	matureHeight := 0
+
+	var spent []coin.TXOutput
+	seen := make(map[outpoint]bool)
+	for _, vin := range tx.Vin {
+		op := outpoint{hex.EncodeToString(vin.Txid), vin.Vout}
+		if seen[op] {
+			return nil, coin.RuleError{
+				ErrorCode:   coin.ErrDoubleSpend,
+				Description: fmt.Sprintf("output %x:%d is spent twice by transaction %x", vin.Txid, vin.Vout, tx.ID),
+			}
+		}
+		seen[op] = true
+
+		if mp.spentBy[op] != nil {
+			return nil, ErrConflict
+		}
+
+		entry, err := mp.fetchEntry(op, vin.Txid)
+		if err != nil {
+			return nil, err
+		}
+		if entry == nil {
+			return nil, coin.RuleError{
+				ErrorCode:   coin.ErrMissingInput,
+				Description: fmt.Sprintf("input %x:%d of transaction %x is not unspent", vin.Txid, vin.Vout, tx.ID),
+			}
+		}
+
+		if !entry.IsMature(spendHeight, mp.params) {
+			return nil, coin.RuleError{
+				ErrorCode:   coin.ErrImmatureSpend,
+				Description: fmt.Sprintf("transaction %x spends coinbase output %x:%d from height %d at height %d", tx.ID, vin.Txid, vin.Vout, entry.Height, spendHeight),
+			}
+		}
+		if entry.Coinbase && entry.Height+mp.params.CoinbaseMaturity > matureHeight {
+			matureHeight = entry.Height + mp.params.CoinbaseMaturity
+		}
+
+		spent = append(spent, entry.Output)
+	}
+
+	fee, err := coin.CheckTransactionInputs(tx, spent, mp.params)
+	if err != nil {
+		return nil, err
+	}
+
+	desc := &TxDesc{
+		Tx:    tx,
+		Added: time.Now(),
+		Fee:   fee,
+		Size:  len(tx.Serialize()),
+		seq:   mp.nextSeq,
+
+		matureHeight: matureHeight,
+	}
+	mp.nextSeq++
+	mp.pool[id] = desc
+	for op := range seen {
+		mp.spentBy[op] = tx
+	}
+	mp.notify(NTTxAccepted, tx)
+
+	return desc, nil
+}
+
+// fetchEntry looks up an output in the mempool and then in the UTXO set.
+// Outputs in the mempool are never coinbase outputs.
+func (mp *Mempool) fetchEntry(op outpoint, txid []byte) (*coin.UtxoEntry, error) {
+	if parent := mp.pool[op.txid]; parent != nil {
+		if op.vout < 0 || op.vout >= len(parent.Tx.Vout) {
+			return nil, nil
+		}
+
+		return &coin.UtxoEntry{Output: parent.Tx.Vout[op.vout]}, nil
+	}
+
+	return mp.utxos.FetchEntry(txid, op.vout)
+}
+
+// HaveTransaction reports whether the transaction is in the mempool
+func (mp *Mempool) HaveTransaction(id []byte) bool {
+	mp.mu.RLock()
+	defer mp.mu.RUnlock()
+
+	return mp.pool[hex.EncodeToString(id)] != nil
+}
+
+// FetchTransaction returns the transaction with the ID from the mempool
+func (mp *Mempool) FetchTransaction(id []byte) (*coin.Transaction, bool) {
+	mp.mu.RLock()
+	defer mp.mu.RUnlock()
+
+	desc := mp.pool[hex.EncodeToString(id)]
+	if desc == nil {
+		return nil, false
+	}
+
+	return desc.Tx, true
+}
+
+// IsSpent reports whether a transaction in the mempool spends the output
+func (mp *Mempool) IsSpent(txid []byte, vout int) bool {
+	mp.mu.RLock()
+	defer mp.mu.RUnlock()
+
+	return mp.spentBy[outpoint{hex.EncodeToString(txid), vout}] != nil
+}
+
+// FindUnspentOutputs returns the outputs of transactions in the mempool that
+// are locked with the public key hash and not spent by another transaction in
+// the mempool. They are ordered by the time their transactions were added.
+func (mp *Mempool) FindUnspentOutputs(pubKeyHash []byte) []coin.UnspentOutput {
+	mp.mu.RLock()
+	defer mp.mu.RUnlock()
+
+	var unspent []coin.UnspentOutput
+	for _, desc := range mp.txDescs() {
+		id := hex.EncodeToString(desc.Tx.ID)
+		for vout, out := range desc.Tx.Vout {
+			if out.IsLockedWithKey(pubKeyHash) && mp.spentBy[outpoint{id, vout}] == nil {
+				unspent = append(unspent, coin.UnspentOutput{
+					Txid:      desc.Tx.ID,
+					Vout:      vout,
+					UtxoEntry: coin.UtxoEntry{Output: out},
+				})
+			}
+		}
+	}
+
+	return unspent
+}
+
+// Count returns the number of transactions in the mempool
+func (mp *Mempool) Count() int {
+	mp.mu.RLock()
+	defer mp.mu.RUnlock()
+
+	return len(mp.pool)
+}
+
+// TxDescs returns the transactions in the order they were added, so every
+// transaction comes after the transactions it spends from
+func (mp *Mempool) TxDescs() []*TxDesc {
+	mp.mu.RLock()
+	defer mp.mu.RUnlock()
+
+	return mp.txDescs()
+}
+
+// txDescs returns the transactions in the order they were added. It has to be
+// called with mu held.
+func (mp *Mempool) txDescs() []*TxDesc {
+	descs := make([]*TxDesc, 0, len(mp.pool))
+	for _, desc := range mp.pool {
+		descs = append(descs, desc)
+	}
+
+	sort.Slice(descs, func(i, j int) bool {
+		return descs[i].seq < descs[j].seq
+	})
+
+	return descs
+}
+
+// RemoveTransaction removes the transaction from the mempool. If
+// removeRedeemers is set, the transactions that spend its outputs are
+// removed as well.
+func (mp *Mempool) RemoveTransaction(tx *coin.Transaction, removeRedeemers bool) {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+
+	mp.removeTransaction(tx, removeRedeemers)
+}
+
+func (mp *Mempool) removeTransaction(tx *coin.Transaction, removeRedeemers bool) {
+	id := hex.EncodeToString(tx.ID)
+
+	if removeRedeemers {
+		for vout := range tx.Vout {
+			if redeemer := mp.spentBy[outpoint{id, vout}]; redeemer != nil {
+				mp.removeTransaction(redeemer, true)
+			}
+		}
+	}
+
+	desc := mp.pool[id]
+	if desc == nil {
+		return
+	}
+
+	for _, vin := range desc.Tx.Vin {
+		delete(mp.spentBy, outpoint{hex.EncodeToString(vin.Txid), vin.Vout})
+	}
+	delete(mp.pool, id)
+	mp.notify(NTTxRemoved, desc.Tx)
+}
+
+// removeDoubleSpends removes the transactions that spend any output the
+// transaction spends, together with their redeemers
+func (mp *Mempool) removeDoubleSpends(tx *coin.Transaction) {
+	for _, vin := range tx.Vin {
+		conflict := mp.spentBy[outpoint{hex.EncodeToString(vin.Txid), vin.Vout}]
+		if conflict != nil && string(conflict.ID) != string(tx.ID) {
+			mp.removeTransaction(conflict, true)
+		}
+	}
+}
+
+// BlockConnected evicts the transactions of a block that was connected to the
+// main chain and the transactions that conflict with them
+func (mp *Mempool) BlockConnected(block *coin.Block) {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+
+	mp.blockConnected(block)
+}
+
+func (mp *Mempool) blockConnected(block *coin.Block) {
+	for _, tx := range block.Transactions {
+		if tx.IsCoinbase() {
+			continue
+		}
+
+		// Transactions spending the outputs stay valid, the outputs are in
+		// the UTXO set now
+		mp.removeTransaction(tx, false)
+		mp.removeDoubleSpends(tx)
+	}
+}
+
+// TipChanged updates the mempool after a change of the main chain. The
+// blocks are listed like in a coin.NTTipChanged notification and the UTXO
+// set has to be at the new tip.
+//
+// The transactions of the detached blocks are added back oldest first, so
+// parents precede their children, unless an attached block mines them again.
+// A transaction that is invalid on the new main chain is evicted together
+// with the transactions spending it, and so is every transaction that spends
+// the coinbase of a detached block. Then the attached blocks are connected
+// and the remaining transactions are checked against the new tip.
+func (mp *Mempool) TipChanged(detached, attached []*coin.Block) {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+
+	mined := make(map[string]bool)
+	for _, block := range attached {
+		for _, tx := range block.Transactions {
+			mined[hex.EncodeToString(tx.ID)] = true
+		}
+	}
+
+	for i := len(detached) - 1; i >= 0; i-- {
+		for _, tx := range detached[i].Transactions {
+			if mined[hex.EncodeToString(tx.ID)] {
+				continue
+			}
+
+			err := ErrCoinbase
+			if !tx.IsCoinbase() {
+				_, err = mp.processTransaction(tx)
+			}
+			if err != nil && err != ErrDuplicate {
+				mp.removeTransaction(tx, true)
+			}
+		}
+	}
+
+	for _, block := range attached {
+		mp.blockConnected(block)
+	}
+
+	if len(detached) > 0 {
+		mp.removeInvalid()
+	}
+}
+
+// removeInvalid evicts the transactions whose inputs are not unspent or not
+// mature in the next block, together with their redeemers. It has to be
+// called with mu held.
+func (mp *Mempool) removeInvalid() {
+	best, err := mp.utxos.BestHeight()
+	if err != nil {
+		return
+	}
+
+	for _, desc := range mp.txDescs() {
+		id := hex.EncodeToString(desc.Tx.ID)
+		if mp.pool[id] == nil {
+			// Evicted as a redeemer of an earlier transaction
+			continue
+		}
+
+		valid := desc.matureHeight <= best+1
+		for _, vin := range desc.Tx.Vin {
+			if !valid {
+				break
+			}
+
+			entry, err := mp.fetchEntry(outpoint{hex.EncodeToString(vin.Txid), vin.Vout}, vin.Txid)
+			if err != nil {
+				return
+			}
+			valid = entry != nil
+		}
+
+		if !valid {
+			mp.removeTransaction(desc.Tx, true)
+		}
+	}
+}
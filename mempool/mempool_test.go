@@ -0,0 +1,357 @@
+package mempool
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	coin "github.com/thesoenke/go-coin"
+)
+
+// utxoView is a UTXO set of the outputs of a few transactions
+type utxoView struct {
+	txs     map[string]*coin.Transaction
+	heights map[string]int
+	best    int
+}
+
+func newUTXOView(best int) *utxoView {
+	return &utxoView{
+		txs:     make(map[string]*coin.Transaction),
+		heights: make(map[string]int),
+		best:    best,
+	}
+}
+
+// add adds the outputs of a transaction in a block at the height
+func (v *utxoView) add(tx *coin.Transaction, height int) {
+	id := hex.EncodeToString(tx.ID)
+	v.txs[id] = tx
+	v.heights[id] = height
+}
+
+// remove removes the outputs of a transaction
+func (v *utxoView) remove(tx *coin.Transaction) {
+	delete(v.txs, hex.EncodeToString(tx.ID))
+}
+
+func (v *utxoView) FetchEntry(txid []byte, vout int) (*coin.UtxoEntry, error) {
+	id := hex.EncodeToString(txid)
+	tx := v.txs[id]
+	if tx == nil || vout >= len(tx.Vout) {
+		return nil, nil
+	}
+
+	return &coin.UtxoEntry{Output: tx.Vout[vout], Height: v.heights[id], Coinbase: tx.IsCoinbase()}, nil
+}
+
+func (v *utxoView) BestHeight() (int, error) {
+	return v.best, nil
+}
+
+// spend creates a transaction that spends the first output of prev and pays
+// amount to the address of the wallet
+func spend(t *testing.T, wallet *coin.Wallet, prev *coin.Transaction, amount int) *coin.Transaction {
+	tx := &coin.Transaction{
+		Vin:  []coin.TXInput{{Txid: prev.ID, Vout: 0, PubKey: wallet.PublicKey}},
+		Vout: []coin.TXOutput{*coin.NewTXOutput(amount, string(wallet.GetAddress(&coin.MainNetParams)))},
+	}
+	tx.ID = tx.Hash()
+
+	prevTXs := map[string]coin.Transaction{hex.EncodeToString(prev.ID): *prev}
+	require.NoError(t, tx.Sign(wallet.PrivateKey, prevTXs))
+
+	return tx
+}
+
+func TestProcessTransaction(t *testing.T) {
+	wallet, err := coin.NewWallet()
+	require.NoError(t, err)
+
+	coinbase := coin.NewCoinbaseTX(string(wallet.GetAddress(&coin.MainNetParams)), "", 10)
+	utxos := newUTXOView(coin.MainNetParams.CoinbaseMaturity)
+	utxos.add(coinbase, 0)
+	mp := New(utxos, &coin.MainNetParams)
+
+	_, err = mp.ProcessTransaction(coinbase)
+	assert.Equal(t, ErrCoinbase, err)
+
+	parent := spend(t, wallet, coinbase, 8)
+	desc, err := mp.ProcessTransaction(parent)
+	require.NoError(t, err)
+	assert.Equal(t, 2, desc.Fee)
+
+	_, err = mp.ProcessTransaction(parent)
+	assert.Equal(t, ErrDuplicate, err)
+
+	// Outputs of pooled transactions can be spent
+	child := spend(t, wallet, parent, 8)
+	_, err = mp.ProcessTransaction(child)
+	require.NoError(t, err)
+
+	conflict := spend(t, wallet, coinbase, 10)
+	_, err = mp.ProcessTransaction(conflict)
+	assert.Equal(t, ErrConflict, err)
+
+	tooHigh := spend(t, wallet, child, 9)
+	_, err = mp.ProcessTransaction(tooHigh)
+	require.IsType(t, coin.RuleError{}, err)
+	assert.Equal(t, coin.ErrSpendTooHigh, err.(coin.RuleError).ErrorCode)
+
+	badSig := spend(t, wallet, child, 5)
+	badSig.Vin[0].Signature[0] ^= 0xff
+	_, err = mp.ProcessTransaction(badSig)
+	require.IsType(t, coin.RuleError{}, err)
+	assert.Equal(t, coin.ErrBadSignature, err.(coin.RuleError).ErrorCode)
+
+	missing := spend(t, wallet, badSig, 5)
+	_, err = mp.ProcessTransaction(missing)
+	require.IsType(t, coin.RuleError{}, err)
+	assert.Equal(t, coin.ErrMissingInput, err.(coin.RuleError).ErrorCode)
+
+	descs := mp.TxDescs()
+	require.Len(t, descs, 2)
+	assert.Equal(t, parent.ID, descs[0].Tx.ID)
+	assert.Equal(t, child.ID, descs[1].Tx.ID)
+}
+
+func TestFindUnspentOutputs(t *testing.T) {
+	wallet, err := coin.NewWallet()
+	require.NoError(t, err)
+	other, err := coin.NewWallet()
+	require.NoError(t, err)
+
+	coinbase := coin.NewCoinbaseTX(string(wallet.GetAddress(&coin.MainNetParams)), "", 10)
+	utxos := newUTXOView(coin.MainNetParams.CoinbaseMaturity)
+	utxos.add(coinbase, 0)
+	mp := New(utxos, &coin.MainNetParams)
+
+	parent := spend(t, wallet, coinbase, 8)
+	child := spend(t, wallet, parent, 8)
+	for _, tx := range []*coin.Transaction{parent, child} {
+		_, err = mp.ProcessTransaction(tx)
+		require.NoError(t, err)
+	}
+
+	assert.True(t, mp.IsSpent(coinbase.ID, 0))
+	assert.True(t, mp.IsSpent(parent.ID, 0))
+	assert.False(t, mp.IsSpent(child.ID, 0))
+
+	// The output of the parent is spent by the child
+	unspent := mp.FindUnspentOutputs(coin.HashPubKey(wallet.PublicKey))
+	require.Len(t, unspent, 1)
+	assert.Equal(t, child.ID, unspent[0].Txid)
+	assert.Equal(t, 0, unspent[0].Vout)
+	assert.Equal(t, 8, unspent[0].Output.Value)
+	assert.False(t, unspent[0].Coinbase)
+
+	assert.Empty(t, mp.FindUnspentOutputs(coin.HashPubKey(other.PublicKey)))
+}
+
+func TestBlockConnectedEvictsTransactions(t *testing.T) {
+	wallet, err := coin.NewWallet()
+	require.NoError(t, err)
+
+	coinbase := coin.NewCoinbaseTX(string(wallet.GetAddress(&coin.MainNetParams)), "", 10)
+	utxos := newUTXOView(coin.MainNetParams.CoinbaseMaturity)
+	utxos.add(coinbase, 0)
+	mp := New(utxos, &coin.MainNetParams)
+
+	parent := spend(t, wallet, coinbase, 10)
+	child := spend(t, wallet, parent, 10)
+	for _, tx := range []*coin.Transaction{parent, child} {
+		_, err = mp.ProcessTransaction(tx)
+		require.NoError(t, err)
+	}
+
+	// A block with the parent keeps the child, whose input is confirmed now
+	utxos.add(parent, utxos.best)
+	mp.BlockConnected(&coin.Block{Transactions: []*coin.Transaction{coinbase, parent}})
+	assert.False(t, mp.HaveTransaction(parent.ID))
+	assert.True(t, mp.HaveTransaction(child.ID))
+
+	// A block with a double spend of the child evicts it
+	doubleSpend := spend(t, wallet, parent, 9)
+	mp.BlockConnected(&coin.Block{Transactions: []*coin.Transaction{coinbase, doubleSpend}})
+	assert.Equal(t, 0, mp.Count())
+
+	// The output of the parent is no longer spent in the mempool
+	_, err = mp.ProcessTransaction(spend(t, wallet, parent, 7))
+	assert.NoError(t, err)
+}
+
+func TestNotifications(t *testing.T) {
+	wallet, err := coin.NewWallet()
+	require.NoError(t, err)
+
+	coinbase := coin.NewCoinbaseTX(string(wallet.GetAddress(&coin.MainNetParams)), "", 10)
+	utxos := newUTXOView(coin.MainNetParams.CoinbaseMaturity)
+	utxos.add(coinbase, 0)
+	mp := New(utxos, &coin.MainNetParams)
+
+	var accepted, removed [][]byte
+	mp.Subscribe(func(notification *Notification) {
+		switch notification.Type {
+		case NTTxAccepted:
+			accepted = append(accepted, notification.Tx.ID)
+		case NTTxRemoved:
+			removed = append(removed, notification.Tx.ID)
+		}
+	})
+
+	parent := spend(t, wallet, coinbase, 10)
+	child := spend(t, wallet, parent, 10)
+	for _, tx := range []*coin.Transaction{parent, child} {
+		_, err = mp.ProcessTransaction(tx)
+		require.NoError(t, err)
+	}
+
+	// Rejected transactions are not announced
+	_, err = mp.ProcessTransaction(parent)
+	assert.Equal(t, ErrDuplicate, err)
+	assert.Equal(t, [][]byte{parent.ID, child.ID}, accepted)
+
+	// Redeemers are removed before the transactions they spend
+	mp.RemoveTransaction(parent, true)
+	assert.Equal(t, [][]byte{child.ID, parent.ID}, removed)
+}
+
+func TestTipChangedEvictsChildrenOfDoubleSpentTransactions(t *testing.T) {
+	wallet, err := coin.NewWallet()
+	require.NoError(t, err)
+
+	coinbase := coin.NewCoinbaseTX(string(wallet.GetAddress(&coin.MainNetParams)), "", 10)
+	utxos := newUTXOView(coin.MainNetParams.CoinbaseMaturity)
+	utxos.add(coinbase, 0)
+	mp := New(utxos, &coin.MainNetParams)
+
+	// The parent is mined and its child waits in the mempool
+	parent := spend(t, wallet, coinbase, 10)
+	utxos.remove(coinbase)
+	utxos.add(parent, utxos.best)
+	detached := &coin.Block{Height: utxos.best, Transactions: []*coin.Transaction{coin.NewCoinbaseTX(string(wallet.GetAddress(&coin.MainNetParams)), "", 10), parent}}
+	child := spend(t, wallet, parent, 9)
+	_, err = mp.ProcessTransaction(child)
+	require.NoError(t, err)
+	other := spend(t, wallet, child, 8)
+	_, err = mp.ProcessTransaction(other)
+	require.NoError(t, err)
+
+	// The new branch double spends the parent
+	doubleSpend := spend(t, wallet, coinbase, 7)
+	attached := &coin.Block{Height: utxos.best, Transactions: []*coin.Transaction{coin.NewCoinbaseTX(string(wallet.GetAddress(&coin.MainNetParams)), "", 10), doubleSpend}}
+	utxos.remove(parent)
+	utxos.add(doubleSpend, utxos.best)
+
+	mp.TipChanged([]*coin.Block{detached}, []*coin.Block{attached})
+	assert.False(t, mp.HaveTransaction(parent.ID))
+	assert.Equal(t, 0, mp.Count())
+	assert.False(t, mp.IsSpent(parent.ID, 0))
+}
+
+func TestTipChangedReaddsTransactionsOldestFirst(t *testing.T) {
+	wallet, err := coin.NewWallet()
+	require.NoError(t, err)
+
+	coinbase := coin.NewCoinbaseTX(string(wallet.GetAddress(&coin.MainNetParams)), "", 10)
+	utxos := newUTXOView(coin.MainNetParams.CoinbaseMaturity)
+	utxos.add(coinbase, 0)
+	mp := New(utxos, &coin.MainNetParams)
+
+	// Both blocks are detached from the tip downwards, the child spends the
+	// parent of the lower block
+	parent := spend(t, wallet, coinbase, 9)
+	child := spend(t, wallet, parent, 8)
+	detached := []*coin.Block{
+		{Transactions: []*coin.Transaction{coin.NewCoinbaseTX(string(wallet.GetAddress(&coin.MainNetParams)), "", 10), child}},
+		{Transactions: []*coin.Transaction{coin.NewCoinbaseTX(string(wallet.GetAddress(&coin.MainNetParams)), "", 10), parent}},
+	}
+
+	mp.TipChanged(detached, nil)
+	descs := mp.TxDescs()
+	require.Len(t, descs, 2)
+	assert.Equal(t, parent.ID, descs[0].Tx.ID)
+	assert.Equal(t, child.ID, descs[1].Tx.ID)
+}
+
+func TestTipChangedKeepsTransactionsMinedAgain(t *testing.T) {
+	wallet, err := coin.NewWallet()
+	require.NoError(t, err)
+
+	coinbase := coin.NewCoinbaseTX(string(wallet.GetAddress(&coin.MainNetParams)), "", 10)
+	utxos := newUTXOView(coin.MainNetParams.CoinbaseMaturity)
+	mp := New(utxos, &coin.MainNetParams)
+
+	// The parent is mined by both branches, its child waits in the mempool
+	parent := spend(t, wallet, coinbase, 9)
+	utxos.add(parent, utxos.best)
+	child := spend(t, wallet, parent, 8)
+	_, err = mp.ProcessTransaction(child)
+	require.NoError(t, err)
+
+	detached := &coin.Block{Transactions: []*coin.Transaction{coin.NewCoinbaseTX(string(wallet.GetAddress(&coin.MainNetParams)), "", 10), parent}}
+	attached := &coin.Block{Transactions: []*coin.Transaction{coin.NewCoinbaseTX(string(wallet.GetAddress(&coin.MainNetParams)), "", 10), parent}}
+
+	mp.TipChanged([]*coin.Block{detached}, []*coin.Block{attached})
+	assert.False(t, mp.HaveTransaction(parent.ID))
+	assert.True(t, mp.HaveTransaction(child.ID))
+}
+
+func TestImmatureCoinbaseSpend(t *testing.T) {
+	wallet, err := coin.NewWallet()
+	require.NoError(t, err)
+
+	params := coin.MainNetParams
+	coinbase := coin.NewCoinbaseTX(string(wallet.GetAddress(&coin.MainNetParams)), "", 10)
+	utxos := newUTXOView(10 + params.CoinbaseMaturity - 2)
+	utxos.add(coinbase, 10)
+	mp := New(utxos, &params)
+
+	// The next block is one block short of the maturity
+	parent := spend(t, wallet, coinbase, 10)
+	_, err = mp.ProcessTransaction(parent)
+	require.IsType(t, coin.RuleError{}, err)
+	assert.Equal(t, coin.ErrImmatureSpend, err.(coin.RuleError).ErrorCode)
+
+	utxos.best++
+	_, err = mp.ProcessTransaction(parent)
+	require.NoError(t, err)
+	child := spend(t, wallet, parent, 10)
+	_, err = mp.ProcessTransaction(child)
+	require.NoError(t, err)
+
+	// Disconnecting the tip makes the coinbase immature again
+	tip := &coin.Block{Height: utxos.best, Transactions: []*coin.Transaction{coin.NewCoinbaseTX(string(wallet.GetAddress(&coin.MainNetParams)), "", 10)}}
+	utxos.best--
+	mp.TipChanged([]*coin.Block{tip}, nil)
+	assert.Equal(t, 0, mp.Count())
+}
+
+func TestSelectTransactions(t *testing.T) {
+	newDesc := func(id string, fee int, parents ...*TxDesc) *TxDesc {
+		tx := &coin.Transaction{ID: []byte(id)}
+		for _, parent := range parents {
+			tx.Vin = append(tx.Vin, coin.TXInput{Txid: parent.Tx.ID, Vout: 0})
+		}
+
+		return &TxDesc{Tx: tx, Fee: fee, Size: 100}
+	}
+
+	// The child pays a high fee but can only follow its parent
+	parent := newDesc("parent", 1)
+	child := newDesc("child", 100, parent)
+	other := newDesc("other", 5)
+	descs := []*TxDesc{child, other, parent}
+
+	var ids []string
+	for _, desc := range SelectTransactions(descs, 1000) {
+		ids = append(ids, string(desc.Tx.ID))
+	}
+	assert.Equal(t, []string{"other", "parent", "child"}, ids)
+
+	// A parent that does not fit excludes its children
+	selected := SelectTransactions([]*TxDesc{child, parent, newDesc("big", 50)}, 150)
+	require.Len(t, selected, 1)
+	assert.Equal(t, "big", string(selected[0].Tx.ID))
+}
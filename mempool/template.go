@@ -0,0 +1,97 @@
+package mempool
+
+import (
+	"container/heap"
+	"encoding/hex"
+)
+
+// FeeRate returns the fee of the transaction per byte
+func (desc *TxDesc) FeeRate() float64 {
+	return float64(desc.Fee) / float64(desc.Size)
+}
+
+// higherFeeRate reports whether a pays a higher fee per byte than b. Equal
+// rates are ordered by the time the transactions were added.
+func higherFeeRate(a, b *TxDesc) bool {
+	// Compare fee_a/size_a and fee_b/size_b without rounding
+	lhs := int64(a.Fee) * int64(b.Size)
+	rhs := int64(b.Fee) * int64(a.Size)
+	if lhs != rhs {
+		return lhs > rhs
+	}
+
+	return a.seq < b.seq
+}
+
+// txPriorityQueue is a heap of transactions with the highest fee rate first
+type txPriorityQueue []*TxDesc
+
+func (pq txPriorityQueue) Len() int            { return len(pq) }
+func (pq txPriorityQueue) Less(i, j int) bool  { return higherFeeRate(pq[i], pq[j]) }
+func (pq txPriorityQueue) Swap(i, j int)       { pq[i], pq[j] = pq[j], pq[i] }
+func (pq *txPriorityQueue) Push(x interface{}) { *pq = append(*pq, x.(*TxDesc)) }
+
+func (pq *txPriorityQueue) Pop() interface{} {
+	old := *pq
+	desc := old[len(old)-1]
+	*pq = old[:len(old)-1]
+
+	return desc
+}
+
+// SelectTransactions picks transactions for a block template with the
+// highest fee rate first until maxSize bytes are used. A transaction is only
+// picked after all transactions in descs it spends from, so the result can
+// be used as the transactions of a block in order.
+func SelectTransactions(descs []*TxDesc, maxSize int) []*TxDesc {
+	byID := make(map[string]*TxDesc)
+	for _, desc := range descs {
+		byID[hex.EncodeToString(desc.Tx.ID)] = desc
+	}
+
+	// waiting counts the parents of a transaction that are not selected yet
+	waiting := make(map[*TxDesc]int)
+	children := make(map[*TxDesc][]*TxDesc)
+	for _, desc := range descs {
+		parents := make(map[*TxDesc]bool)
+		for _, vin := range desc.Tx.Vin {
+			parent := byID[hex.EncodeToString(vin.Txid)]
+			if parent != nil && !parents[parent] {
+				parents[parent] = true
+				children[parent] = append(children[parent], desc)
+			}
+		}
+		waiting[desc] = len(parents)
+	}
+
+	pq := &txPriorityQueue{}
+	for _, desc := range descs {
+		if waiting[desc] == 0 {
+			heap.Push(pq, desc)
+		}
+	}
+
+	var selected []*TxDesc
+	size := 0
+	for pq.Len() > 0 {
+		desc := heap.Pop(pq).(*TxDesc)
+
+		// Transactions that do not fit are skipped together with their
+		// children, which never become ready
+		if size+desc.Size > maxSize {
+			continue
+		}
+
+		selected = append(selected, desc)
+		size += desc.Size
+
+		for _, child := range children[desc] {
+			waiting[child]--
+			if waiting[child] == 0 {
+				heap.Push(pq, child)
+			}
+		}
+	}
+
+	return selected
+}
@@ -0,0 +1,162 @@
+package coin
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"math/big"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// hashesPerCheck is the number of nonces a worker tries before it checks
+// whether mining was stopped
+const hashesPerCheck = 1 << 12
+
+// Miner solves the proof of work of blocks. The nonce space is split across
+// its workers.
+type Miner struct {
+	Workers int
+
+	hashes  uint64
+	mu      sync.Mutex
+	started time.Time
+	stopped time.Time
+}
+
+// NewMiner returns a Miner with one worker per CPU
+func NewMiner() *Miner {
+	return &Miner{Workers: runtime.NumCPU()}
+}
+
+// Mine searches a nonce for the block so that its hash meets the target. If
+// the whole nonce space is exhausted, the extra nonce in the coinbase of the
+// block is incremented and the search starts over. Mine returns the error of
+// the context if it is done before a solution is found.
+func (m *Miner) Mine(ctx context.Context, block *Block) error {
+	m.mu.Lock()
+	m.started = time.Now()
+	m.stopped = time.Time{}
+	m.mu.Unlock()
+	atomic.StoreUint64(&m.hashes, 0)
+
+	defer func() {
+		m.mu.Lock()
+		m.stopped = time.Now()
+		m.mu.Unlock()
+	}()
+
+	var coinbaseData []byte
+	if len(block.Transactions) > 0 && block.Transactions[0].IsCoinbase() {
+		coinbaseData = block.Transactions[0].Vin[0].PubKey
+	}
+
+	for extraNonce := uint64(1); ; extraNonce++ {
+		nonce, found, err := m.solve(ctx, block)
+		if err != nil {
+			return err
+		}
+
+		if found {
+			block.Nonce = nonce
+			block.Hash = block.BlockHash()
+			return nil
+		}
+
+		if coinbaseData == nil {
+			// Without a coinbase the block cannot be changed to continue
+			block.Timestamp = time.Now().Unix()
+			continue
+		}
+
+		coinbase := block.Transactions[0]
+		coinbase.Vin[0].PubKey = append(append([]byte{}, coinbaseData...), IntToHex(int64(extraNonce))...)
+		coinbase.ID = coinbase.Hash()
+		block.MerkleRoot = block.HashTransactions()
+	}
+}
+
+// Hashrate returns the hashes per second of the current or last Mine call
+func (m *Miner) Hashrate() float64 {
+	m.mu.Lock()
+	started, stopped := m.started, m.stopped
+	m.mu.Unlock()
+
+	if started.IsZero() {
+		return 0
+	}
+
+	if stopped.IsZero() {
+		stopped = time.Now()
+	}
+
+	elapsed := stopped.Sub(started).Seconds()
+	if elapsed == 0 {
+		return 0
+	}
+
+	return float64(atomic.LoadUint64(&m.hashes)) / elapsed
+}
+
+// solve tries every nonce for the current block data. found is false if no
+// nonce meets the target.
+func (m *Miner) solve(ctx context.Context, block *Block) (uint32, bool, error) {
+	workers := m.Workers
+	if workers < 1 {
+		workers = 1
+	}
+
+	pow := NewProofOfWork(&block.BlockHeader)
+
+	// The nonce is serialized as the last four bytes of the header
+	data := block.BlockHeader.Serialize()
+	header := data[:len(data)-4]
+
+	workCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	solution := make(chan uint32, workers)
+	var wg sync.WaitGroup
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(start uint64) {
+			defer wg.Done()
+
+			var hashInt big.Int
+			data := make([]byte, len(header)+4)
+			copy(data, header)
+
+			for nonce := start; nonce <= maxNonce; nonce += uint64(workers) {
+				if (nonce-start)%(hashesPerCheck*uint64(workers)) == 0 {
+					if workCtx.Err() != nil {
+						return
+					}
+					atomic.AddUint64(&m.hashes, hashesPerCheck)
+				}
+
+				binary.BigEndian.PutUint32(data[len(header):], uint32(nonce))
+				hash := sha256.Sum256(data)
+				hashInt.SetBytes(hash[:])
+
+				if hashInt.Cmp(pow.target) == -1 {
+					solution <- uint32(nonce)
+					cancel()
+					return
+				}
+			}
+		}(uint64(i))
+	}
+
+	wg.Wait()
+
+	select {
+	case nonce := <-solution:
+		return nonce, true, nil
+	default:
+	}
+
+	return 0, false, ctx.Err()
+}
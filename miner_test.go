@@ -0,0 +1,75 @@
+package coin
+
+import (
+	"bytes"
+	"context"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMinerCancel(t *testing.T) {
+	wallet, err := NewWallet()
+	require.NoError(t, err)
+
+	// No hash meets a target of one in practice
+	coinbase := NewCoinbaseTX(string(wallet.GetAddress(&RegTestParams)), "", 10)
+	block := NewBlockTemplate([]*Transaction{coinbase}, []byte{1}, 1, BigToCompact(big.NewInt(1)))
+	miner := &Miner{Workers: 2}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.Equal(t, context.Canceled, miner.Mine(ctx, block))
+
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	started := time.Now()
+	assert.Equal(t, context.DeadlineExceeded, miner.Mine(ctx, block))
+	assert.True(t, time.Since(started) < 5*time.Second)
+	assert.Empty(t, block.Hash)
+	assert.True(t, miner.Hashrate() > 0)
+}
+
+func TestMinerExtraNonce(t *testing.T) {
+	defer func(n uint64) { maxNonce = n }(maxNonce)
+	maxNonce = 1
+
+	wallet, err := NewWallet()
+	require.NoError(t, err)
+
+	// Every other hash meets the target of the regression test network, so
+	// a template is picked for which neither nonce is a solution
+	var block *Block
+	var data []byte
+	for block == nil {
+		coinbase := NewCoinbaseTX(string(wallet.GetAddress(&RegTestParams)), "", 10)
+		data = append([]byte{}, coinbase.Vin[0].PubKey...)
+		candidate := NewBlockTemplate([]*Transaction{coinbase}, []byte{1}, 1, RegTestParams.PowLimitBits)
+
+		solved := false
+		for nonce := uint32(0); nonce <= uint32(maxNonce); nonce++ {
+			candidate.Nonce = nonce
+			solved = solved || NewProofOfWork(&candidate.BlockHeader).Validate()
+		}
+		if !solved {
+			candidate.Nonce = 0
+			block = candidate
+		}
+	}
+
+	require.NoError(t, (&Miner{Workers: 2}).Mine(context.Background(), block))
+
+	// The extra nonce is appended to the coinbase data, which changes the
+	// coinbase ID and the merkle root
+	coinbase := block.Transactions[0]
+	assert.True(t, bytes.HasPrefix(coinbase.Vin[0].PubKey, data))
+	assert.Len(t, coinbase.Vin[0].PubKey, len(data)+8)
+	assert.Equal(t, coinbase.Hash(), coinbase.ID)
+	assert.Equal(t, block.HashTransactions(), block.MerkleRoot)
+	assert.True(t, uint64(block.Nonce) <= maxNonce)
+	assert.Equal(t, block.BlockHash(), block.Hash)
+	assert.NoError(t, CheckHeaderSanity(&block.BlockHeader, &RegTestParams))
+}
@@ -0,0 +1,83 @@
+package coin
+
+// NotificationType identifies the kind of a chain notification
+type NotificationType int
+
+const (
+	// NTBlockConnected indicates that a block was connected to the main chain
+	NTBlockConnected NotificationType = iota
+
+	// NTBlockDisconnected indicates that a block was disconnected from the
+	// main chain by a reorganization or an invalidation
+	NTBlockDisconnected
+
+	// NTTipChanged indicates that a change of the main chain is complete. It
+	// follows the notifications of the disconnected and connected blocks.
+	NTTipChanged
+)
+
+// Notification describes a change of the main chain. Block is the new tip
+// for NTTipChanged, which also lists the blocks of the change in Detached and
+// Attached in the order of their notifications.
+type Notification struct {
+	Type     NotificationType
+	Block    *Block
+	Detached []*Block
+	Attached []*Block
+}
+
+// NotificationCallback is called for every notification of the blockchain
+type NotificationCallback func(*Notification)
+
+// tipChange lists the blocks that were detached from the main chain, from
+// the old tip downwards, and the blocks that were attached, from the fork
+// point upwards
+type tipChange struct {
+	tip      *Block
+	detached []*Block
+	attached []*Block
+}
+
+// Subscribe registers a callback for changes of the main chain. Callbacks are
+// called after the change is committed and in the order of the changes. A
+// callback must not add or invalidate blocks.
+func (bc *Blockchain) Subscribe(callback NotificationCallback) {
+	bc.subscribersMu.Lock()
+	defer bc.subscribersMu.Unlock()
+
+	bc.subscribers = append(bc.subscribers, callback)
+}
+
+// sendNotifications notifies the subscribers about the disconnected and then
+// the connected blocks of the change, followed by the change as a whole. It
+// has to be called with notifyMu held.
+func (bc *Blockchain) sendNotifications(change *tipChange) {
+	if change == nil {
+		return
+	}
+
+	bc.subscribersMu.RLock()
+	subscribers := bc.subscribers
+	bc.subscribersMu.RUnlock()
+
+	for _, block := range change.detached {
+		for _, callback := range subscribers {
+			callback(&Notification{Type: NTBlockDisconnected, Block: block})
+		}
+	}
+
+	for _, block := range change.attached {
+		for _, callback := range subscribers {
+			callback(&Notification{Type: NTBlockConnected, Block: block})
+		}
+	}
+
+	for _, callback := range subscribers {
+		callback(&Notification{
+			Type:     NTTipChanged,
+			Block:    change.tip,
+			Detached: change.detached,
+			Attached: change.attached,
+		})
+	}
+}
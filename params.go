@@ -0,0 +1,183 @@
+package coin
+
+import (
+	"fmt"
+	"math/big"
+)
+
+// ChainParams defines the consensus parameters of a network and how its
+// nodes and addresses are told apart from other networks
+type ChainParams struct {
+	// Name selects the network on the command line
+	Name string
+
+	// Net is the magic that starts every message of the network protocol
+	Net uint32
+
+	// AddressVersion is the first byte of the payload of an address
+	AddressVersion byte
+
+	// DefaultPort is the port nodes of the network listen on by default
+	DefaultPort int
+
+	// DataDir is the directory of the files of the network relative to the
+	// data directory
+	DataDir string
+
+	// GenesisCoinbaseData is the data of the input of the genesis coinbase
+	GenesisCoinbaseData string
+
+	// PowLimit is the highest target a block may have
+	PowLimit *big.Int
+
+	// PowLimitBits is PowLimit in compact form. It is used for the genesis block
+	PowLimitBits uint32
+
+	// NoRetargeting keeps the difficulty of the genesis block forever
+	NoRetargeting bool
+
+	// TargetTimespan is the time in seconds one retarget interval should take
+	TargetTimespan int64
+
+	// TargetSpacing is the desired time in seconds between two blocks
+	TargetSpacing int64
+
+	// RetargetAdjustmentFactor limits how much the difficulty may change in
+	// one retarget. The actual timespan is clamped to the target timespan
+	// divided and multiplied by this factor.
+	RetargetAdjustmentFactor int64
+
+	// BaseSubsidy is the number of new coins a coinbase may claim before the
+	// first halving
+	BaseSubsidy int
+
+	// SubsidyHalvingInterval is the number of blocks after which the subsidy
+	// is halved. It has to be positive.
+	SubsidyHalvingInterval int
+
+	// CoinbaseMaturity is the number of blocks that have to follow a
+	// coinbase before its outputs may be spent
+	CoinbaseMaturity int
+}
+
+// RetargetInterval returns the number of blocks after which the difficulty
+// is adjusted
+func (p *ChainParams) RetargetInterval() int {
+	return int(p.TargetTimespan / p.TargetSpacing)
+}
+
+var (
+	mainPowLimit    = new(big.Int).Lsh(big.NewInt(1), 256-22)
+	testPowLimit    = new(big.Int).Lsh(big.NewInt(1), 256-18)
+	regTestPowLimit = new(big.Int).Lsh(big.NewInt(1), 255)
+)
+
+// MainNetParams are the parameters of the main network
+var MainNetParams = ChainParams{
+	Name:                     "main",
+	Net:                      0x676f636e,
+	AddressVersion:           0x00,
+	DefaultPort:              3000,
+	DataDir:                  "",
+	GenesisCoinbaseData:      "It's me, Mario!",
+	PowLimit:                 mainPowLimit,
+	PowLimitBits:             BigToCompact(mainPowLimit),
+	TargetTimespan:           60 * 60,
+	TargetSpacing:            60,
+	RetargetAdjustmentFactor: 4,
+	BaseSubsidy:              10,
+	SubsidyHalvingInterval:   210000,
+	CoinbaseMaturity:         100,
+}
+
+// TestNetParams are the parameters of the public test network. Its coins have
+// no value and its difficulty is lower than on the main network.
+var TestNetParams = ChainParams{
+	Name:                     "test",
+	Net:                      0x676f7474,
+	AddressVersion:           0x6f,
+	DefaultPort:              13000,
+	DataDir:                  "testnet",
+	GenesisCoinbaseData:      "It's me, Luigi!",
+	PowLimit:                 testPowLimit,
+	PowLimitBits:             BigToCompact(testPowLimit),
+	TargetTimespan:           60 * 60,
+	TargetSpacing:            60,
+	RetargetAdjustmentFactor: 4,
+	BaseSubsidy:              10,
+	SubsidyHalvingInterval:   210000,
+	CoinbaseMaturity:         100,
+}
+
+// RegTestParams are the parameters of a private regression test network.
+// Every other hash is a valid proof of work, so blocks are mined instantly.
+var RegTestParams = ChainParams{
+	Name:                     "regtest",
+	Net:                      0x676f7267,
+	AddressVersion:           0x3c,
+	DefaultPort:              18000,
+	DataDir:                  "regtest",
+	GenesisCoinbaseData:      "It's me, Toad!",
+	NoRetargeting:            true,
+	PowLimit:                 regTestPowLimit,
+	PowLimitBits:             BigToCompact(regTestPowLimit),
+	TargetTimespan:           60 * 60,
+	TargetSpacing:            60,
+	RetargetAdjustmentFactor: 4,
+	BaseSubsidy:              10,
+	SubsidyHalvingInterval:   150,
+	CoinbaseMaturity:         100,
+}
+
+// ParamsByName returns the parameters of the network with the name
+func ParamsByName(name string) (*ChainParams, error) {
+	for _, params := range []*ChainParams{&MainNetParams, &TestNetParams, &RegTestParams} {
+		if params.Name == name {
+			return params, nil
+		}
+	}
+
+	return nil, fmt.Errorf("unknown network '%s'", name)
+}
+
+// CalcBlockSubsidy returns the number of new coins the coinbase of a block at
+// the height may claim in addition to the fees of the block
+func CalcBlockSubsidy(height int, params *ChainParams) int {
+	halvings := height / params.SubsidyHalvingInterval
+	if halvings >= 63 {
+		return 0
+	}
+
+	return params.BaseSubsidy >> uint(halvings)
+}
+
+// ExpectedSupply returns the sum of the subsidies of the blocks from the
+// genesis block up to and including the height
+func ExpectedSupply(height int, params *ChainParams) int {
+	supply := 0
+	for start := 0; start <= height; start += params.SubsidyHalvingInterval {
+		subsidy := CalcBlockSubsidy(start, params)
+		if subsidy == 0 {
+			break
+		}
+
+		blocks := params.SubsidyHalvingInterval
+		if height-start+1 < blocks {
+			blocks = height - start + 1
+		}
+		supply += subsidy * blocks
+	}
+
+	return supply
+}
+
+// MaxSupply returns the number of coins that exist once the subsidy has
+// dropped to zero. No output and no sum of outputs or fees may exceed it.
+func (p *ChainParams) MaxSupply() int {
+	supply := 0
+	for height := 0; CalcBlockSubsidy(height, p) > 0; height += p.SubsidyHalvingInterval {
+		supply += CalcBlockSubsidy(height, p) * p.SubsidyHalvingInterval
+	}
+
+	return supply
+}
@@ -0,0 +1,24 @@
+package coin
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSubsidyHalving(t *testing.T) {
+	params := &ChainParams{BaseSubsidy: 10, SubsidyHalvingInterval: 100}
+
+	assert.Equal(t, 10, CalcBlockSubsidy(0, params))
+	assert.Equal(t, 10, CalcBlockSubsidy(99, params))
+	assert.Equal(t, 5, CalcBlockSubsidy(100, params))
+	assert.Equal(t, 2, CalcBlockSubsidy(200, params))
+	assert.Equal(t, 1, CalcBlockSubsidy(300, params))
+	assert.Equal(t, 0, CalcBlockSubsidy(400, params))
+
+	assert.Equal(t, 10, ExpectedSupply(0, params))
+	assert.Equal(t, 1000, ExpectedSupply(99, params))
+	assert.Equal(t, 1005, ExpectedSupply(100, params))
+	assert.Equal(t, 1800, ExpectedSupply(1000, params))
+	assert.Equal(t, 1800, params.MaxSupply())
+}
@@ -2,85 +2,54 @@ package coin
 
 import (
 	"bytes"
-	"crypto/sha256"
 	"encoding/binary"
-	"fmt"
 	"log"
 	"math"
 	"math/big"
 )
 
-const (
-	maxNonce   = math.MaxInt64
-	targetBits = 22
-)
+// maxNonce is the last nonce a miner tries before it changes the extra nonce.
+// Tests lower it to run out of nonces quickly.
+var maxNonce uint64 = math.MaxUint32
 
 // ProofOfWork represents a PoW
 type ProofOfWork struct {
-	block  *Block
+	header *BlockHeader
 	target *big.Int
 }
 
-// NewProofOfWork returns a ProofOfWork
-func NewProofOfWork(b *Block) *ProofOfWork {
-	target := big.NewInt(1)
-	target.Lsh(target, uint(256-targetBits))
-	pow := &ProofOfWork{b, target}
+// NewProofOfWork returns a ProofOfWork for the target encoded in the bits of
+// the header
+func NewProofOfWork(h *BlockHeader) *ProofOfWork {
+	target := CompactToBig(h.Bits)
+	pow := &ProofOfWork{h, target}
 	return pow
 }
 
-// Run proof-of-work for block
-func (pow *ProofOfWork) Run() (int, []byte) {
-	var hashInt big.Int
-	var hash [32]byte
-	nonce := 0
-
-	fmt.Println("Mining new block")
-	for nonce < maxNonce {
-		data := pow.prepareData(nonce)
-		hash = sha256.Sum256(data)
-		if math.Remainder(float64(nonce), 1000) == 0 {
-			fmt.Printf("\r%x", hash)
-		}
-
-		hashInt.SetBytes(hash[:])
-		if hashInt.Cmp(pow.target) == -1 {
-			break
-		} else {
-			nonce++
-		}
-	}
-	fmt.Print("\n\n")
-
-	return nonce, hash[:]
-}
-
 // Validate block PoW
 func (pow *ProofOfWork) Validate() bool {
 	var hashInt big.Int
 
-	data := pow.prepareData(pow.block.Nonce)
-	hash := sha256.Sum256(data)
-	hashInt.SetBytes(hash[:])
+	hash := pow.Hash()
+	hashInt.SetBytes(hash)
 
 	isValid := hashInt.Cmp(pow.target) == -1
 
 	return isValid
 }
 
-func (pow *ProofOfWork) prepareData(nonce int) []byte {
-	data := bytes.Join(
-		[][]byte{
-			pow.block.PrevBlockHash,
-			pow.block.HashTransactions(),
-			IntToHex(pow.block.Timestamp),
-			IntToHex(int64(targetBits)),
-			IntToHex(int64(nonce)),
-		},
-		[]byte{},
-	)
+// Hash calculates the hash of the header with its current nonce
+func (pow *ProofOfWork) Hash() []byte {
+	return pow.header.BlockHash()
+}
+
+// Work returns the expected number of hashes needed to find a block that
+// meets the target
+func (pow *ProofOfWork) Work() *big.Int {
+	work := new(big.Int).Lsh(big.NewInt(1), 256)
+	denominator := new(big.Int).Add(pow.target, big.NewInt(1))
 
-	return data
+	return work.Div(work, denominator)
 }
 
 // IntToHex converts an int64 to a byte array
@@ -0,0 +1,92 @@
+package coin
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type serializationVector struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+	Hex  string `json:"hex"`
+	Hash string `json:"hash"`
+}
+
+func TestSerializationVectors(t *testing.T) {
+	data, err := ioutil.ReadFile("testdata/serialization_vectors.json")
+	require.NoError(t, err)
+
+	var vectors []serializationVector
+	require.NoError(t, json.Unmarshal(data, &vectors))
+
+	for _, v := range vectors {
+		raw, err := hex.DecodeString(v.Hex)
+		require.NoError(t, err, v.Name)
+
+		switch v.Type {
+		case "transaction":
+			tx, err := DeserializeTransaction(raw)
+			require.NoError(t, err, v.Name)
+			assert.Equal(t, v.Hash, hex.EncodeToString(tx.ID), v.Name)
+			assert.Equal(t, v.Hex, hex.EncodeToString(tx.Serialize()), v.Name)
+		case "header":
+			header, err := DeserializeBlockHeader(raw)
+			require.NoError(t, err, v.Name)
+			assert.Equal(t, v.Hash, hex.EncodeToString(header.BlockHash()), v.Name)
+			assert.Equal(t, v.Hex, hex.EncodeToString(header.Serialize()), v.Name)
+		case "block":
+			block, err := DeserializeBlock(raw)
+			require.NoError(t, err, v.Name)
+			assert.Equal(t, v.Hash, hex.EncodeToString(block.Hash), v.Name)
+			assert.Equal(t, v.Hex, hex.EncodeToString(block.Serialize()), v.Name)
+			assert.Equal(t, block.MerkleRoot, block.HashTransactions(), v.Name)
+		default:
+			t.Fatalf("unknown vector type %s", v.Type)
+		}
+	}
+}
+
+func TestSerializationRoundTrip(t *testing.T) {
+	wallet, err := NewWallet()
+	require.NoError(t, err)
+	address := string(wallet.GetAddress(&MainNetParams))
+
+	coinbase := NewCoinbaseTX(address, "", 10)
+	spend := &Transaction{
+		Vin: []TXInput{
+			{Txid: coinbase.ID, Vout: 0, Signature: []byte{1, 2, 3}, PubKey: wallet.PublicKey},
+		},
+		Vout: []TXOutput{*NewTXOutput(4, address), *NewTXOutput(6, address)},
+	}
+	spend.ID = spend.Hash()
+
+	for _, tx := range []*Transaction{coinbase, spend} {
+		decoded, err := DeserializeTransaction(tx.Serialize())
+		require.NoError(t, err)
+		assert.Equal(t, *tx, decoded)
+	}
+
+	block := NewBlockTemplate([]*Transaction{coinbase, spend}, coinbase.ID, 3, MainNetParams.PowLimitBits)
+	block.Hash = block.BlockHash()
+	decoded, err := DeserializeBlock(block.Serialize())
+	require.NoError(t, err)
+	assert.Equal(t, block, decoded)
+
+	header, err := DeserializeBlockHeader(block.BlockHeader.Serialize())
+	require.NoError(t, err)
+	assert.Equal(t, block.BlockHeader, *header)
+
+	outs := TXOutputs{Height: 7, Coinbase: true, Outputs: map[int]TXOutput{0: spend.Vout[0], 5: spend.Vout[1]}}
+	assert.Equal(t, outs, DeserializeOutputs(outs.Serialize()))
+
+	undo := BlockUndo{Spent: []SpentOutput{{Txid: coinbase.ID, Vout: 0, Output: coinbase.Vout[0], Height: 3, Coinbase: true}}}
+	assert.Equal(t, undo, DeserializeBlockUndo(undo.Serialize()))
+
+	_, err = DeserializeTransaction(spend.Serialize()[:20])
+	assert.Error(t, err)
+}
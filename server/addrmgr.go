@@ -0,0 +1,272 @@
+package server
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	// addrFileVersion is the version of the peers file
+	addrFileVersion = 1
+
+	// Maximum number of addresses in the new and the tried bucket
+	maxNewAddresses   = 1024
+	maxTriedAddresses = 256
+
+	// maxAddrPerMsg is the maximum number of addresses in an addr message
+	maxAddrPerMsg = 1000
+
+	// retryInterval is the time before a failed address is tried again
+	retryInterval = time.Minute
+)
+
+// knownAddress is an address of a node together with the connection attempts
+// made to it
+type knownAddress struct {
+	Addr        string    `json:"addr"`
+	Attempts    int       `json:"attempts"`
+	LastAttempt time.Time `json:"last_attempt"`
+	LastSuccess time.Time `json:"last_success"`
+}
+
+// addrManager keeps the addresses of other nodes. Addresses that were heard
+// of from peers go into the new bucket. Once a connection to an address
+// succeeds, it is moved into the tried bucket.
+type addrManager struct {
+	mu    sync.Mutex
+	path  string
+	new   map[string]*knownAddress
+	tried map[string]*knownAddress
+}
+
+// addrFile is the content of the peers file
+type addrFile struct {
+	Version int             `json:"version"`
+	New     []*knownAddress `json:"new"`
+	Tried   []*knownAddress `json:"tried"`
+}
+
+// newAddrManager returns an address manager that is saved to path. If path is
+// empty, addresses are only kept in memory.
+func newAddrManager(path string) *addrManager {
+	return &addrManager{
+		path:  path,
+		new:   make(map[string]*knownAddress),
+		tried: make(map[string]*knownAddress),
+	}
+}
+
+// load reads the addresses saved by a previous run. A missing file is not an
+// error.
+func (a *addrManager) load() error {
+	if a.path == "" {
+		return nil
+	}
+
+	data, err := ioutil.ReadFile(a.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var file addrFile
+	err = json.Unmarshal(data, &file)
+	if err != nil {
+		return err
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for _, ka := range file.New {
+		a.new[ka.Addr] = ka
+	}
+	for _, ka := range file.Tried {
+		delete(a.new, ka.Addr)
+		a.tried[ka.Addr] = ka
+	}
+
+	return nil
+}
+
+// save writes the addresses to the peers file
+func (a *addrManager) save() error {
+	if a.path == "" {
+		return nil
+	}
+
+	a.mu.Lock()
+	file := addrFile{Version: addrFileVersion}
+	for _, ka := range a.new {
+		file.New = append(file.New, ka)
+	}
+	for _, ka := range a.tried {
+		file.Tried = append(file.Tried, ka)
+	}
+	data, err := json.MarshalIndent(file, "", "  ")
+	a.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	// Replace the file at once so a crash does not leave a partial file
+	tmp := a.path + ".tmp"
+	err = ioutil.WriteFile(tmp, data, 0600)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, a.path)
+}
+
+// addAddresses adds the addresses to the new bucket unless they are known
+// already
+func (a *addrManager) addAddresses(addrs []string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for _, addr := range addrs {
+		if addr == "" || a.new[addr] != nil || a.tried[addr] != nil {
+			continue
+		}
+
+		if len(a.new) >= maxNewAddresses {
+			a.evictNew()
+		}
+		a.new[addr] = &knownAddress{Addr: addr}
+	}
+}
+
+// attempt records a connection attempt to the address
+func (a *addrManager) attempt(addr string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	ka := a.find(addr)
+	if ka == nil {
+		return
+	}
+
+	ka.Attempts++
+	ka.LastAttempt = time.Now()
+}
+
+// good moves the address into the tried bucket after a successful connection
+func (a *addrManager) good(addr string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	ka := a.find(addr)
+	if ka == nil {
+		ka = &knownAddress{Addr: addr}
+	}
+
+	ka.Attempts = 0
+	ka.LastSuccess = time.Now()
+	if a.tried[addr] != nil {
+		return
+	}
+
+	delete(a.new, addr)
+	if len(a.tried) >= maxTriedAddresses {
+		// Make room by moving a random tried address back to the new bucket
+		for evicted, old := range a.tried {
+			delete(a.tried, evicted)
+			if len(a.new) >= maxNewAddresses {
+				a.evictNew()
+			}
+			a.new[evicted] = old
+			break
+		}
+	}
+	a.tried[addr] = ka
+}
+
+// getAddress returns an address to connect to that is not excluded and was
+// not attempted recently. Tried and new addresses are chosen with the same
+// probability. It returns an empty string if there is no such address.
+func (a *addrManager) getAddress(exclude func(string) bool) string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	buckets := []map[string]*knownAddress{a.tried, a.new}
+	if rand.Intn(2) == 1 {
+		buckets[0], buckets[1] = buckets[1], buckets[0]
+	}
+
+	for _, bucket := range buckets {
+		var candidates []string
+		for addr, ka := range bucket {
+			if exclude(addr) || time.Since(ka.LastAttempt) < retryInterval*time.Duration(ka.Attempts) {
+				continue
+			}
+			candidates = append(candidates, addr)
+		}
+
+		if len(candidates) > 0 {
+			return candidates[rand.Intn(len(candidates))]
+		}
+	}
+
+	return ""
+}
+
+// addressCache returns up to maxAddrPerMsg random addresses to share with a
+// peer
+func (a *addrManager) addressCache() []string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	var addrs []string
+	for addr := range a.tried {
+		addrs = append(addrs, addr)
+	}
+	for addr := range a.new {
+		addrs = append(addrs, addr)
+	}
+
+	rand.Shuffle(len(addrs), func(i, j int) {
+		addrs[i], addrs[j] = addrs[j], addrs[i]
+	})
+	if len(addrs) > maxAddrPerMsg {
+		addrs = addrs[:maxAddrPerMsg]
+	}
+
+	return addrs
+}
+
+// size returns the number of known addresses
+func (a *addrManager) size() int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return len(a.new) + len(a.tried)
+}
+
+func (a *addrManager) find(addr string) *knownAddress {
+	if ka := a.tried[addr]; ka != nil {
+		return ka
+	}
+
+	return a.new[addr]
+}
+
+// evictNew removes the new address with the most failed attempts
+func (a *addrManager) evictNew() {
+	var worst *knownAddress
+	for _, ka := range a.new {
+		if worst == nil || ka.Attempts > worst.Attempts {
+			worst = ka
+		}
+	}
+
+	if worst != nil {
+		delete(a.new, worst.Addr)
+	}
+}
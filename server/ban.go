@@ -0,0 +1,235 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	// banFileVersion is the version of the ban list file
+	banFileVersion = 1
+
+	// defaultBanThreshold is the misbehavior score at which a peer is banned
+	defaultBanThreshold = 100
+
+	// defaultBanDuration is the time a misbehaving peer stays banned
+	defaultBanDuration = 24 * time.Hour
+)
+
+// Misbehavior scores that are added to a peer for protocol violations
+const (
+	scoreMalformed         = 50
+	scoreUnexpectedMessage = 10
+	scoreInvalidBlock      = 100
+	scoreInvalidHeader     = 100
+	scoreInvalidTx         = 10
+	scoreUnrequested       = 20
+)
+
+// misbehavior is returned by a message handler if the peer violated the
+// protocol. The score is added to the misbehavior score of the peer.
+type misbehavior struct {
+	score  int
+	reason string
+}
+
+func (m *misbehavior) Error() string {
+	return m.reason
+}
+
+func misbehaving(score int, format string, a ...interface{}) error {
+	return &misbehavior{score: score, reason: fmt.Sprintf(format, a...)}
+}
+
+// BanList keeps the hosts that are not allowed to connect and the time until
+// they are banned. It is safe for concurrent use.
+type BanList struct {
+	mu   sync.Mutex
+	path string
+	bans map[string]time.Time
+
+	// synced are the bans of the file when it was last loaded or saved. They
+	// tell bans removed from the file apart from bans only added to the list.
+	synced map[string]time.Time
+}
+
+// banFile is the content of the ban list file
+type banFile struct {
+	Version int                  `json:"version"`
+	Bans    map[string]time.Time `json:"bans"`
+}
+
+// NewBanList returns an empty ban list that is saved to path. If path is
+// empty, bans are only kept in memory.
+func NewBanList(path string) *BanList {
+	return &BanList{
+		path:   path,
+		bans:   make(map[string]time.Time),
+		synced: make(map[string]time.Time),
+	}
+}
+
+// Load merges the bans in the file into the list. Bans that were removed
+// from the file since it was last loaded or saved are removed from the list,
+// bans that were added to the list since then are kept. A missing file is not
+// an error.
+func (b *BanList) Load() error {
+	if b.path == "" {
+		return nil
+	}
+
+	data, err := ioutil.ReadFile(b.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var file banFile
+	err = json.Unmarshal(data, &file)
+	if err != nil {
+		return err
+	}
+	if file.Version != banFileVersion {
+		return fmt.Errorf("unsupported ban list version %d", file.Version)
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for host, until := range b.synced {
+		if _, ok := file.Bans[host]; !ok && b.bans[host].Equal(until) {
+			delete(b.bans, host)
+		}
+	}
+
+	for host, until := range file.Bans {
+		if until.After(b.bans[host]) || b.bans[host].Equal(b.synced[host]) {
+			b.bans[host] = until
+		}
+	}
+
+	b.synced = file.Bans
+	if b.synced == nil {
+		b.synced = make(map[string]time.Time)
+	}
+
+	return nil
+}
+
+// Save writes the bans that did not expire yet to the file
+func (b *BanList) Save() error {
+	if b.path == "" {
+		return nil
+	}
+
+	file := banFile{Version: banFileVersion, Bans: b.Bans()}
+	data, err := json.MarshalIndent(file, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp := b.path + ".tmp"
+	err = ioutil.WriteFile(tmp, data, 0600)
+	if err != nil {
+		return err
+	}
+
+	err = os.Rename(tmp, b.path)
+	if err != nil {
+		return err
+	}
+
+	b.mu.Lock()
+	b.synced = file.Bans
+	b.mu.Unlock()
+
+	return nil
+}
+
+// Ban bans the host until the given time
+func (b *BanList) Ban(host string, until time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.bans[host] = until
+}
+
+// Unban removes the ban of the host and reports whether it was banned
+func (b *BanList) Unban(host string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	_, ok := b.bans[host]
+	delete(b.bans, host)
+
+	return ok
+}
+
+// IsBanned reports whether the host is currently banned
+func (b *BanList) IsBanned(host string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	until, ok := b.bans[host]
+	return ok && time.Now().Before(until)
+}
+
+// Bans returns a copy of the bans that did not expire yet
+func (b *BanList) Bans() map[string]time.Time {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	bans := make(map[string]time.Time)
+	for host, until := range b.bans {
+		if time.Now().Before(until) {
+			bans[host] = until
+		}
+	}
+
+	return bans
+}
+
+// hostOf returns the host of an address with a port. Addresses without a port
+// are returned unchanged.
+func hostOf(address string) string {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return address
+	}
+
+	return host
+}
+
+// addMisbehavior increases the misbehavior score of the peer. Once the score
+// reaches the ban threshold, the host of the peer is banned and the peer is
+// disconnected.
+func (n *Node) addMisbehavior(p *peer, m *misbehavior) {
+	p.score += m.score
+	fmt.Printf("Peer %s misbehaved (score %d): %s\n", p, p.score, m.reason)
+	if p.score < n.banThreshold {
+		return
+	}
+
+	host := hostOf(p.String())
+	n.bans.Ban(host, time.Now().Add(n.banDuration))
+	fmt.Printf("Banned %s for %s\n", host, n.banDuration)
+
+	err := n.bans.Save()
+	if err != nil {
+		fmt.Printf("Failed saving ban list: %s\n", err)
+	}
+
+	p.conn.Close()
+}
+
+// isBanned reports whether the host of the address is banned
+func (n *Node) isBanned(address string) bool {
+	return n.bans.IsBanned(hostOf(address))
+}
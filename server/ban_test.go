@@ -0,0 +1,48 @@
+package server
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBanListLoadMerges(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bans")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "bans.json")
+
+	until := time.Now().Add(time.Hour).Round(0)
+	node := NewBanList(path)
+	node.Ban("10.0.0.1", until)
+	node.Ban("10.0.0.2", until)
+	require.NoError(t, node.Save())
+
+	// The node bans another host without saving the list
+	node.Ban("10.0.0.3", until)
+
+	// The ban command edits the file while the node is running
+	cli := NewBanList(path)
+	require.NoError(t, cli.Load())
+	assert.True(t, cli.Unban("10.0.0.1"))
+	cli.Ban("10.0.0.2", until.Add(time.Hour))
+	cli.Ban("10.0.0.4", until)
+	require.NoError(t, cli.Save())
+
+	require.NoError(t, node.Load())
+	bans := node.Bans()
+	assert.Len(t, bans, 3)
+	assert.False(t, node.IsBanned("10.0.0.1"))
+	assert.True(t, bans["10.0.0.2"].Equal(until.Add(time.Hour)))
+	assert.True(t, node.IsBanned("10.0.0.3"))
+	assert.True(t, node.IsBanned("10.0.0.4"))
+
+	// Loading the same file again changes nothing
+	require.NoError(t, node.Load())
+	assert.Equal(t, bans, node.Bans())
+}
@@ -0,0 +1,114 @@
+package server
+
+import (
+	"sync"
+
+	coin "github.com/thesoenke/go-coin"
+	"github.com/thesoenke/go-coin/mempool"
+)
+
+// EventType identifies the kind of an event of a node
+type EventType string
+
+// Events that are published by a node
+const (
+	// EventBlockConnected is published when a block is connected to the
+	// main chain
+	EventBlockConnected EventType = "blockconnected"
+
+	// EventBlockDisconnected is published when a block is disconnected from
+	// the main chain by a reorganization or an invalidation
+	EventBlockDisconnected EventType = "blockdisconnected"
+
+	// EventTipChanged is published when a change of the main chain is
+	// complete, after the events of its blocks and of the mempool. Block is
+	// the new tip.
+	EventTipChanged EventType = "tipchanged"
+
+	// EventTxAccepted is published when a transaction is added to the
+	// mempool
+	EventTxAccepted EventType = "txaccepted"
+
+	// EventTxRemoved is published when a transaction leaves the mempool
+	// because it was mined, conflicts with a mined transaction or became
+	// invalid
+	EventTxRemoved EventType = "txremoved"
+
+	// EventPeerConnected is published when a peer completed the handshake
+	EventPeerConnected EventType = "peerconnected"
+
+	// EventPeerDisconnected is published when a connected peer is gone
+	EventPeerDisconnected EventType = "peerdisconnected"
+)
+
+// Event describes a change of the state of a node. Only the field that
+// belongs to the type of the event is set.
+type Event struct {
+	Type  EventType
+	Block *coin.Block
+	Tx    *coin.Transaction
+	Peer  *PeerInfo
+}
+
+// EventCallback is called for every event of a node
+type EventCallback func(*Event)
+
+// eventBus passes the events of a node to its subscribers. Events of the main
+// chain are published in the order of the changes. The mempool is updated
+// after the events of the blocks of a change, so a removed transaction that
+// was mined and a transaction that returns to the mempool follow the event of
+// their block. EventTipChanged completes the change.
+type eventBus struct {
+	mu          sync.RWMutex
+	nextID      int
+	subscribers map[int]EventCallback
+}
+
+func newEventBus() *eventBus {
+	return &eventBus{subscribers: make(map[int]EventCallback)}
+}
+
+// subscribe registers a callback and returns a function that removes it
+func (b *eventBus) subscribe(callback EventCallback) func() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	id := b.nextID
+	b.nextID++
+	b.subscribers[id] = callback
+
+	return func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+
+		delete(b.subscribers, id)
+	}
+}
+
+// publish calls the subscribers with the event
+func (b *eventBus) publish(event *Event) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	for _, callback := range b.subscribers {
+		callback(event)
+	}
+}
+
+// Subscribe registers a callback for the events of the node and returns a
+// function that removes it. Callbacks are called synchronously while the
+// chain or the mempool is locked, so a callback must return quickly and must
+// not change the chain or the mempool.
+func (n *Node) Subscribe(callback EventCallback) func() {
+	return n.events.subscribe(callback)
+}
+
+// handleMempoolNotification publishes the changes of the mempool
+func (n *Node) handleMempoolNotification(notification *mempool.Notification) {
+	switch notification.Type {
+	case mempool.NTTxAccepted:
+		n.events.publish(&Event{Type: EventTxAccepted, Tx: notification.Tx})
+	case mempool.NTTxRemoved:
+		n.events.publish(&Event{Type: EventTxRemoved, Tx: notification.Tx})
+	}
+}
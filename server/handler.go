@@ -1,316 +1,434 @@
 package server
 
 import (
-	"bytes"
-	"encoding/gob"
+	"context"
 	"encoding/hex"
 	"fmt"
-	"io/ioutil"
-	"log"
-	"net"
 
 	coin "github.com/thesoenke/go-coin"
+	"github.com/thesoenke/go-coin/mempool"
 )
 
-func handleConnection(conn net.Conn, bc *coin.Blockchain) {
-	request, err := ioutil.ReadAll(conn)
-	if err != nil {
-		log.Panic(err)
-	}
-
-	command := bytesToCommand(request[:commandLength])
-	fmt.Printf("Received %s command\n", command)
-
+// handleMessage dispatches a message received after the handshake
+func (n *Node) handleMessage(p *peer, command string, payload []byte) error {
 	switch command {
 	case "addr":
-		handleAddr(request)
+		return n.handleAddr(p, payload)
 	case "block":
-		handleBlock(request, bc)
+		return n.handleBlock(p, payload)
 	case "inv":
-		handleInv(request, bc)
-	case "getblocks":
-		handleGetBlocks(request, bc)
+		return n.handleInv(p, payload)
+	case "getaddr":
+		return n.handleGetAddr(p, payload)
 	case "getdata":
-		handleGetData(request, bc)
+		return n.handleGetData(p, payload)
+	case "getheaders":
+		return n.handleGetHeaders(p, payload)
+	case "headers":
+		return n.handleHeaders(p, payload)
+	case "ping":
+		return handlePing(p, payload)
+	case "pong":
+		return nil
 	case "tx":
-		handleTx(request, bc)
-	case "version":
-		handleVersion(request, bc)
+		return n.handleTx(p, payload)
+	case "version", "verack":
+		return misbehaving(scoreUnexpectedMessage, "unexpected %s message after the handshake", command)
 	default:
 		fmt.Printf("Unknown command '%s'\n", command)
 	}
 
-	conn.Close()
+	return nil
 }
 
-func handleAddr(request []byte) {
-	var buff bytes.Buffer
+func (n *Node) handleAddr(p *peer, data []byte) error {
 	var payload addr
 
-	buff.Write(request[commandLength:])
-	dec := gob.NewDecoder(&buff)
-	err := dec.Decode(&payload)
+	err := payload.deserialize(data)
+	if err != nil {
+		return misbehaving(scoreMalformed, "malformed message: %s", err)
+	}
+
+	if len(payload.AddrList) > maxAddrPerMsg {
+		return misbehaving(scoreMalformed, "addr message with %d addresses is too large", len(payload.AddrList))
+	}
+
+	var addresses []string
+	for _, address := range payload.AddrList {
+		if address != n.address {
+			addresses = append(addresses, address)
+		}
+	}
+
+	n.addrMgr.addAddresses(addresses)
+	n.wakeConnectionLoop()
+
+	return nil
+}
+
+// handleGetAddr replies with a random selection of the known addresses
+func (n *Node) handleGetAddr(p *peer, data []byte) error {
+	var payload getaddr
+
+	err := payload.deserialize(data)
 	if err != nil {
-		log.Panic(err)
+		return misbehaving(scoreMalformed, "malformed message: %s", err)
+	}
+
+	var addresses []string
+	for _, address := range n.addrMgr.addressCache() {
+		if address != p.addr {
+			addresses = append(addresses, address)
+		}
 	}
 
-	knownNodes = append(knownNodes, payload.AddrList...)
-	fmt.Printf("There %d known nodes\n", len(knownNodes))
-	requestBlocks()
+	return p.sendAddr(addresses)
 }
 
-func handleBlock(request []byte, bc *coin.Blockchain) {
-	var buff bytes.Buffer
+func (n *Node) handleBlock(p *peer, data []byte) error {
 	var payload block
 
-	buff.Write(request[commandLength:])
-	dec := gob.NewDecoder(&buff)
-	err := dec.Decode(&payload)
+	err := payload.deserialize(data)
 	if err != nil {
-		log.Panic(err)
+		return misbehaving(scoreMalformed, "malformed message: %s", err)
 	}
 
 	blockData := payload.Block
-	block := coin.DeserializeBlock(blockData)
+	block, err := coin.DeserializeBlock(blockData)
+	if err != nil {
+		return misbehaving(scoreMalformed, "malformed block: %s", err)
+	}
+
+	id := hex.EncodeToString(block.Hash)
+	if !p.requested[id] {
+		return misbehaving(scoreUnrequested, "unrequested block %x", block.Hash)
+	}
+	delete(p.requested, id)
 
 	fmt.Printf("Received block %x with height %d\n", block.Hash, block.Height)
-	err = bc.AddBlock(block)
+	_, err = n.bc.GetBlock(block.Hash)
+	known := err == nil
+
+	err = n.bc.AddBlock(block)
+	if err == coin.ErrOrphanBlock {
+		// This node is behind or on a fork, find the fork point with the
+		// peer and download the headers from there
+		fmt.Printf("Parent of block %x is unknown, requesting headers\n", block.Hash)
+		return n.requestHeadersFrom(p)
+	}
+	if _, ok := err.(coin.RuleError); ok {
+		return misbehaving(scoreInvalidBlock, "invalid block %x: %s", block.Hash, err)
+	}
 	if err != nil {
-		log.Fatal(err)
+		return fmt.Errorf("failed adding block %x: %s", block.Hash, err)
 	}
 
-	if len(blocksInTransit) > 0 {
-		blockHash := blocksInTransit[0]
-		sendGetData(payload.AddrFrom, "block", blockHash)
+	// The block template of the miner is stale now
+	n.abortMining()
 
-		blocksInTransit = blocksInTransit[1:]
-	} else {
-		UTXOSet := coin.UTXOSet{Blockchain: bc}
-		UTXOSet.Reindex()
+	if len(p.blocksInTransit) > 0 {
+		blockHash := p.blocksInTransit[0]
+		p.blocksInTransit = p.blocksInTransit[1:]
+
+		return p.sendGetData("block", blockHash)
 	}
+
+	// Relay new blocks that are not part of a download, so they reach nodes
+	// that are not connected to the miner
+	if !known {
+		n.broadcastInv("block", [][]byte{block.Hash}, p)
+	}
+
+	return nil
 }
 
-func handleInv(request []byte, bc *coin.Blockchain) {
-	var buff bytes.Buffer
+func (n *Node) handleInv(p *peer, data []byte) error {
 	var payload inv
 
-	buff.Write(request[commandLength:])
-	dec := gob.NewDecoder(&buff)
-	err := dec.Decode(&payload)
+	err := payload.deserialize(data)
 	if err != nil {
-		log.Panic(err)
+		return misbehaving(scoreMalformed, "malformed message: %s", err)
+	}
+
+	if len(payload.Items) == 0 {
+		return nil
 	}
 
 	fmt.Printf("Received inventory with %d %s\n", len(payload.Items), payload.Type)
 
 	if payload.Type == "block" {
-		blocksInTransit = payload.Items
-
-		blockHash := payload.Items[0]
-		sendGetData(payload.AddrFrom, "block", blockHash)
-
-		newInTransit := [][]byte{}
-		for _, b := range blocksInTransit {
-			if bytes.Compare(b, blockHash) != 0 {
-				newInTransit = append(newInTransit, b)
+		// Inventory is ordered from the tip to the genesis block. Request the
+		// oldest block first so that parents are always added before children
+		p.blocksInTransit = [][]byte{}
+		for i := len(payload.Items) - 1; i >= 0; i-- {
+			_, err := n.bc.GetBlock(payload.Items[i])
+			if err == nil {
+				continue
 			}
+			p.blocksInTransit = append(p.blocksInTransit, payload.Items[i])
 		}
-		blocksInTransit = newInTransit
+
+		if len(p.blocksInTransit) == 0 {
+			return nil
+		}
+
+		blockHash := p.blocksInTransit[0]
+		p.blocksInTransit = p.blocksInTransit[1:]
+
+		return p.sendGetData("block", blockHash)
 	}
 
 	if payload.Type == "tx" {
 		txID := payload.Items[0]
 
-		if mempool[hex.EncodeToString(txID)].ID == nil {
-			sendGetData(payload.AddrFrom, "tx", txID)
+		if !n.mempool.HaveTransaction(txID) {
+			return p.sendGetData("tx", txID)
 		}
 	}
+
+	return nil
 }
 
-func handleGetBlocks(request []byte, bc *coin.Blockchain) {
-	var buff bytes.Buffer
-	var payload getblocks
+func (n *Node) handleGetHeaders(p *peer, data []byte) error {
+	var payload getheaders
+
+	err := payload.deserialize(data)
+	if err != nil {
+		return misbehaving(scoreMalformed, "malformed message: %s", err)
+	}
 
-	buff.Write(request[commandLength:])
-	dec := gob.NewDecoder(&buff)
-	err := dec.Decode(&payload)
+	blockHeaders, err := n.bc.GetHeaders(payload.Locator, maxHeadersPerMsg)
 	if err != nil {
-		log.Panic(err)
+		return err
 	}
 
-	blocks := bc.GetBlockHashes()
-	sendInv(payload.AddrFrom, "block", blocks)
+	return p.sendHeaders(blockHeaders)
 }
 
-func handleGetData(request []byte, bc *coin.Blockchain) error {
-	var buff bytes.Buffer
-	var payload getdata
+// handleHeaders adds the received headers to the header index. Once the peer
+// has no more headers, the bodies of the blocks on the best header chain are
+// requested from the oldest to the newest.
+func (n *Node) handleHeaders(p *peer, data []byte) error {
+	var payload headers
 
-	buff.Write(request[commandLength:])
-	dec := gob.NewDecoder(&buff)
-	err := dec.Decode(&payload)
+	err := payload.deserialize(data)
 	if err != nil {
-		fmt.Println("invalid payload")
+		return misbehaving(scoreMalformed, "malformed message: %s", err)
+	}
+
+	var blockHeaders []*coin.BlockHeader
+	for _, headerData := range payload.Headers {
+		header, err := coin.DeserializeBlockHeader(headerData)
+		if err != nil {
+			return misbehaving(scoreMalformed, "malformed header: %s", err)
+		}
+		blockHeaders = append(blockHeaders, header)
+	}
+
+	if !p.headersRequested {
+		return misbehaving(scoreUnrequested, "unrequested headers")
+	}
+	p.headersRequested = false
+
+	fmt.Printf("Received %d headers\n", len(blockHeaders))
+	err = n.bc.AddHeaders(blockHeaders)
+	if ruleErr, ok := err.(coin.RuleError); ok {
+		if ruleErr.ErrorCode == coin.ErrMissingParent {
+			return misbehaving(scoreUnrequested, "headers do not connect: %s", err)
+		}
+		return misbehaving(scoreInvalidHeader, "invalid headers: %s", err)
+	}
+	if err != nil {
+		return fmt.Errorf("failed adding headers: %s", err)
+	}
+
+	if len(blockHeaders) == maxHeadersPerMsg {
+		// The peer has more headers. The locator of the new best header
+		// continues after the last one received.
+		return n.requestHeadersFrom(p)
+	}
+
+	missing, err := n.bc.MissingBlocks()
+	if err != nil {
+		return err
+	}
+
+	if len(missing) == 0 {
 		return nil
 	}
 
+	p.blocksInTransit = missing[1:]
+	return p.sendGetData("block", missing[0])
+}
+
+func (n *Node) handleGetData(p *peer, data []byte) error {
+	var payload getdata
+
+	err := payload.deserialize(data)
+	if err != nil {
+		return misbehaving(scoreMalformed, "malformed message: %s", err)
+	}
+
 	if payload.Type == "block" {
-		block, err := bc.GetBlock([]byte(payload.ID))
+		block, err := n.bc.GetBlock([]byte(payload.ID))
 		if err != nil {
 			return err
 		}
 
-		err = sendBlock(payload.AddrFrom, &block)
+		err = p.sendBlock(&block)
 		if err != nil {
 			return err
 		}
 	}
 
 	if payload.Type == "tx" {
-		txID := hex.EncodeToString(payload.ID)
-		tx := mempool[txID]
+		tx, ok := n.mempool.FetchTransaction(payload.ID)
+		if !ok {
+			return fmt.Errorf("transaction %x is not in the mempool", payload.ID)
+		}
 
-		err = sendTx(payload.AddrFrom, &tx)
+		err = p.sendTx(tx)
 		if err != nil {
-			fmt.Printf("Failed sending tx to %s\n", payload.AddrFrom)
-			return nil
+			return err
 		}
-		// delete(mempool, txID)
 	}
 
 	return nil
 }
 
-func handleTx(request []byte, bc *coin.Blockchain) error {
-	var buff bytes.Buffer
+func handlePing(p *peer, data []byte) error {
+	var payload ping
+
+	err := payload.deserialize(data)
+	if err != nil {
+		return misbehaving(scoreMalformed, "malformed message: %s", err)
+	}
+
+	return p.sendMessage("pong", (&pong{payload.Nonce}).serialize())
+}
+
+func (n *Node) handleTx(p *peer, data []byte) error {
 	var payload tx
 
-	buff.Write(request[commandLength:])
-	dec := gob.NewDecoder(&buff)
-	err := dec.Decode(&payload)
+	err := payload.deserialize(data)
 	if err != nil {
-		return err
+		return misbehaving(scoreMalformed, "malformed message: %s", err)
 	}
 
 	txData := payload.Transaction
 	tx, err := coin.DeserializeTransaction(txData)
 	if err != nil {
-		return err
+		return misbehaving(scoreMalformed, "malformed transaction: %s", err)
 	}
 
-	mempool[hex.EncodeToString(tx.ID)] = tx
-	// Is the central node
-	if nodeAddress == knownNodes[0] {
-		for _, node := range knownNodes {
-			if node != nodeAddress && node != payload.AddFrom {
-				err = sendInv(node, "tx", [][]byte{tx.ID})
-				if err != nil {
-					fmt.Printf("Could not reach node %s\n", node)
-				}
-			}
-		}
+	// Submit-only clients push the transactions they submit. Nodes announce
+	// transactions with inv and wait until they are requested.
+	id := hex.EncodeToString(tx.ID)
+	if p.requested[id] {
+		delete(p.requested, id)
+	} else if p.submitAllowance > 0 {
+		p.submitAllowance--
 	} else {
-		for len(mempool) >= transactionsInBlock {
-			err = mineBlock(bc)
-			if err != nil {
-				return err
-			}
-		}
+		return misbehaving(scoreUnrequested, "unrequested transaction %x", tx.ID)
 	}
 
-	return nil
-}
-
-func mineBlock(bc *coin.Blockchain) error {
-	var txs []*coin.Transaction
-
-	for id := range mempool {
-		tx := mempool[id]
-		if bc.VerifyTransaction(&tx) {
-			txs = append(txs, &tx)
-		}
-
-		if len(txs) == 0 {
-			fmt.Println("all transactions are invalid")
-			return nil
-		}
+	_, err = n.mempool.ProcessTransaction(&tx)
+	if err == mempool.ErrDuplicate {
+		return nil
+	}
+	if ruleErr, ok := err.(coin.RuleError); ok && ruleErr.ErrorCode != coin.ErrMissingInput {
+		return misbehaving(scoreInvalidTx, "invalid transaction %x: %s", tx.ID, err)
 	}
-
-	cbTx := coin.NewCoinbaseTX(miningAddress, "")
-	txs = append(txs, cbTx)
-	newBlock, err := bc.MineBlock(txs)
 	if err != nil {
-		return err
+		// Spending unknown outputs or outputs spent in the mempool can be a
+		// race between the peers and is not punished
+		return fmt.Errorf("rejected transaction %x: %s", tx.ID, err)
 	}
 
-	UTXOSet := coin.UTXOSet{Blockchain: bc}
-	UTXOSet.Reindex()
-
-	fmt.Printf("Mined new block with %d transactions\n", len(txs))
+	n.relayTransaction(&tx, p)
+	return nil
+}
 
-	for _, tx := range txs {
-		txID := hex.EncodeToString(tx.ID)
-		delete(mempool, txID)
+// relayTransaction announces a transaction that was added to the mempool to
+// all peers except the one it came from and starts mining if enough
+// transactions are waiting
+func (n *Node) relayTransaction(tx *coin.Transaction, from *peer) {
+	n.broadcastInv("tx", [][]byte{tx.ID}, from)
+	if n.miningAddress != "" && n.MempoolSize() >= transactionsInBlock {
+		n.startMining()
 	}
+}
 
-	for _, node := range knownNodes {
-		if node != nodeAddress {
-			err = sendInv(node, "block", [][]byte{newBlock.Hash})
-			fmt.Printf("Could not reach node %s\n", node)
-			return err
-		}
+// mineBlock mines a block with transactions of the mempool on top of the
+// current tip. It returns false if no transaction could be selected, so there
+// is nothing to mine until the mempool or the tip changes. A transaction that
+// makes the template invalid is evicted instead of mining a block.
+func (n *Node) mineBlock() (bool, error) {
+	// The mempool only holds transactions that are valid on the current tip
+	descs := mempool.SelectTransactions(n.mempool.TxDescs(), coin.MaxBlockSize-blockReservedSize)
+	if len(descs) == 0 {
+		return false, nil
 	}
 
-	return nil
-}
-
-func handleVersion(request []byte, bc *coin.Blockchain) error {
-	var buff bytes.Buffer
-	var payload version
+	fees := 0
+	var txs []*coin.Transaction
+	for _, desc := range descs {
+		txs = append(txs, desc.Tx)
+		fees += desc.Fee
+	}
 
-	buff.Write(request[commandLength:])
-	dec := gob.NewDecoder(&buff)
-	err := dec.Decode(&payload)
+	newBlock, err := n.bc.NewBlockTemplateWithCoinbase(n.miningAddress, fees, txs)
+	if terr, ok := err.(*coin.TemplateTxError); ok {
+		// The transaction and the ones spending it are evicted, so the next
+		// template is built without them
+		fmt.Printf("Evicting invalid transaction from the mempool: %s\n", terr)
+		n.mempool.RemoveTransaction(terr.Tx, true)
+		return true, nil
+	}
 	if err != nil {
-		return err
+		return true, err
 	}
 
-	myBestHeight, err := bc.GetBestHeight()
+	ctx, cancel := context.WithCancel(context.Background())
+	n.miningMu.Lock()
+	n.stopMining = cancel
+	n.miningMu.Unlock()
+
+	fmt.Println("Mining new block")
+	err = n.miner.Mine(ctx, newBlock)
+	n.abortMining()
+	if err == context.Canceled {
+		fmt.Println("Mining aborted, the chain tip changed")
+		return true, nil
+	}
 	if err != nil {
-		return err
+		return true, err
 	}
 
-	foreignerBestHeight := payload.BestHeight
-	if myBestHeight < foreignerBestHeight {
-		sendGetBlocks(payload.AddrFrom)
-	} else if myBestHeight > foreignerBestHeight {
-		err = sendVersion(payload.AddrFrom, bc)
-		if err != nil {
-			return err
-		}
+	err = n.bc.AddBlock(newBlock)
+	if err != nil {
+		return true, err
 	}
 
-	// sendAddr(payload.AddrFrom)
-	if !nodeIsKnown(payload.AddrFrom) {
-		fmt.Printf("New node %s connected\n", payload.AddrFrom)
-		knownNodes = append(knownNodes, payload.AddrFrom)
-	}
+	fmt.Printf("Mined new block with %d transactions at %.0f H/s\n", len(newBlock.Transactions), n.miner.Hashrate())
 
-	return nil
+	n.broadcastInv("block", [][]byte{newBlock.Hash}, nil)
+
+	return true, nil
 }
 
-func nodeIsKnown(addr string) bool {
-	for _, node := range knownNodes {
-		if node == addr {
-			return true
-		}
+// requestHeadersFrom asks the peer for the headers that follow the last block
+// both nodes have in common
+func (n *Node) requestHeadersFrom(p *peer) error {
+	bestHeader, err := n.bc.BestHeaderHash()
+	if err != nil {
+		return err
 	}
 
-	return false
-}
-
-func requestBlocks() {
-	for _, node := range knownNodes {
-		sendGetBlocks(node)
+	locator, err := n.bc.BlockLocator(bestHeader)
+	if err != nil {
+		return err
 	}
+
+	return p.sendGetHeaders(locator)
 }
@@ -0,0 +1,228 @@
+package server
+
+import "github.com/thesoenke/go-coin/wire"
+
+// messageVersion is the version byte every payload starts with
+const messageVersion = 1
+
+func (m *addr) serialize() []byte {
+	w := &wire.Writer{}
+	w.WriteUint8(messageVersion)
+	w.WriteUint32(uint32(len(m.AddrList)))
+	for _, address := range m.AddrList {
+		w.WriteString(address)
+	}
+
+	return w.Bytes()
+}
+
+func (m *addr) deserialize(data []byte) error {
+	r := wire.NewReader(data)
+	r.ReadVersion(messageVersion)
+	count := r.ReadCount()
+	for i := 0; i < count; i++ {
+		m.AddrList = append(m.AddrList, r.ReadString())
+	}
+
+	return r.Finish()
+}
+
+func (m *block) serialize() []byte {
+	w := &wire.Writer{}
+	w.WriteUint8(messageVersion)
+	w.WriteVarBytes(m.Block)
+
+	return w.Bytes()
+}
+
+func (m *block) deserialize(data []byte) error {
+	r := wire.NewReader(data)
+	r.ReadVersion(messageVersion)
+	m.Block = r.ReadVarBytes()
+
+	return r.Finish()
+}
+
+func (m *getaddr) serialize() []byte {
+	w := &wire.Writer{}
+	w.WriteUint8(messageVersion)
+
+	return w.Bytes()
+}
+
+func (m *getaddr) deserialize(data []byte) error {
+	r := wire.NewReader(data)
+	r.ReadVersion(messageVersion)
+
+	return r.Finish()
+}
+
+func (m *getdata) serialize() []byte {
+	w := &wire.Writer{}
+	w.WriteUint8(messageVersion)
+	w.WriteString(m.Type)
+	w.WriteVarBytes(m.ID)
+
+	return w.Bytes()
+}
+
+func (m *getdata) deserialize(data []byte) error {
+	r := wire.NewReader(data)
+	r.ReadVersion(messageVersion)
+	m.Type = r.ReadString()
+	m.ID = r.ReadVarBytes()
+
+	return r.Finish()
+}
+
+func (m *getheaders) serialize() []byte {
+	w := &wire.Writer{}
+	w.WriteUint8(messageVersion)
+	w.WriteUint32(uint32(len(m.Locator)))
+	for _, hash := range m.Locator {
+		w.WriteVarBytes(hash)
+	}
+
+	return w.Bytes()
+}
+
+func (m *getheaders) deserialize(data []byte) error {
+	r := wire.NewReader(data)
+	r.ReadVersion(messageVersion)
+	count := r.ReadCount()
+	for i := 0; i < count; i++ {
+		m.Locator = append(m.Locator, r.ReadVarBytes())
+	}
+
+	return r.Finish()
+}
+
+func (m *headers) serialize() []byte {
+	w := &wire.Writer{}
+	w.WriteUint8(messageVersion)
+	w.WriteUint32(uint32(len(m.Headers)))
+	for _, header := range m.Headers {
+		w.WriteVarBytes(header)
+	}
+
+	return w.Bytes()
+}
+
+func (m *headers) deserialize(data []byte) error {
+	r := wire.NewReader(data)
+	r.ReadVersion(messageVersion)
+	count := r.ReadCount()
+	for i := 0; i < count; i++ {
+		m.Headers = append(m.Headers, r.ReadVarBytes())
+	}
+
+	return r.Finish()
+}
+
+func (m *inv) serialize() []byte {
+	w := &wire.Writer{}
+	w.WriteUint8(messageVersion)
+	w.WriteString(m.Type)
+	w.WriteUint32(uint32(len(m.Items)))
+	for _, item := range m.Items {
+		w.WriteVarBytes(item)
+	}
+
+	return w.Bytes()
+}
+
+func (m *inv) deserialize(data []byte) error {
+	r := wire.NewReader(data)
+	r.ReadVersion(messageVersion)
+	m.Type = r.ReadString()
+	count := r.ReadCount()
+	for i := 0; i < count; i++ {
+		m.Items = append(m.Items, r.ReadVarBytes())
+	}
+
+	return r.Finish()
+}
+
+func (m *ping) serialize() []byte {
+	w := &wire.Writer{}
+	w.WriteUint8(messageVersion)
+	w.WriteUint64(m.Nonce)
+
+	return w.Bytes()
+}
+
+func (m *ping) deserialize(data []byte) error {
+	r := wire.NewReader(data)
+	r.ReadVersion(messageVersion)
+	m.Nonce = r.ReadUint64()
+
+	return r.Finish()
+}
+
+func (m *pong) serialize() []byte {
+	w := &wire.Writer{}
+	w.WriteUint8(messageVersion)
+	w.WriteUint64(m.Nonce)
+
+	return w.Bytes()
+}
+
+func (m *pong) deserialize(data []byte) error {
+	r := wire.NewReader(data)
+	r.ReadVersion(messageVersion)
+	m.Nonce = r.ReadUint64()
+
+	return r.Finish()
+}
+
+func (m *tx) serialize() []byte {
+	w := &wire.Writer{}
+	w.WriteUint8(messageVersion)
+	w.WriteVarBytes(m.Transaction)
+
+	return w.Bytes()
+}
+
+func (m *tx) deserialize(data []byte) error {
+	r := wire.NewReader(data)
+	r.ReadVersion(messageVersion)
+	m.Transaction = r.ReadVarBytes()
+
+	return r.Finish()
+}
+
+func (m *verack) serialize() []byte {
+	w := &wire.Writer{}
+	w.WriteUint8(messageVersion)
+
+	return w.Bytes()
+}
+
+func (m *verack) deserialize(data []byte) error {
+	r := wire.NewReader(data)
+	r.ReadVersion(messageVersion)
+
+	return r.Finish()
+}
+
+func (m *version) serialize() []byte {
+	w := &wire.Writer{}
+	w.WriteUint8(messageVersion)
+	w.WriteInt32(int32(m.Version))
+	w.WriteUint64(m.Services)
+	w.WriteInt64(int64(m.BestHeight))
+	w.WriteString(m.AddrFrom)
+
+	return w.Bytes()
+}
+
+func (m *version) deserialize(data []byte) error {
+	r := wire.NewReader(data)
+	r.ReadVersion(messageVersion)
+	m.Version = int(r.ReadInt32())
+	m.Services = r.ReadUint64()
+	m.BestHeight = int(r.ReadInt64())
+	m.AddrFrom = r.ReadString()
+
+	return r.Finish()
+}
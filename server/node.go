@@ -0,0 +1,794 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	coin "github.com/thesoenke/go-coin"
+	"github.com/thesoenke/go-coin/mempool"
+)
+
+const (
+	// defaultMaxOutbound is the number of outbound connections a node keeps
+	// if the config does not set one
+	defaultMaxOutbound = 8
+
+	// connectInterval is the time between checks for missing outbound
+	// connections
+	connectInterval = 30 * time.Second
+
+	// saveAddrInterval is the time between writes of the peers file
+	saveAddrInterval = 10 * time.Minute
+)
+
+// Config configures a node
+type Config struct {
+	// Address is the address the node listens on and announces to its peers
+	Address string
+
+	// MiningAddress receives the rewards of mined blocks. A node without a
+	// mining address only relays transactions.
+	MiningAddress string
+
+	// Seeds are the addresses of nodes used to discover the network
+	Seeds []string
+
+	// PeersFile keeps the known addresses across restarts. Addresses are only
+	// kept in memory if it is empty.
+	PeersFile string
+
+	// MaxOutbound is the number of outbound connections the node maintains
+	MaxOutbound int
+
+	// BanFile keeps the banned hosts across restarts. Bans are only kept in
+	// memory if it is empty.
+	BanFile string
+
+	// BanThreshold is the misbehavior score at which a peer is banned
+	BanThreshold int
+
+	// BanDuration is the time a misbehaving peer stays banned
+	BanDuration time.Duration
+
+	// RPC configures the JSON-RPC server of the node
+	RPC RPCConfig
+
+	// RESTAddress is the address of the read-only REST API. The API is
+	// disabled if it is empty.
+	RESTAddress string
+
+	// WSAddress is the address of the WebSocket event stream. The stream is
+	// disabled if it is empty.
+	WSAddress string
+}
+
+// Node is a full node that syncs the blockchain with its peers, relays
+// transactions and mines blocks. All state is owned by the node, so several
+// nodes can run in one process.
+type Node struct {
+	address       string
+	miningAddress string
+	seeds         []string
+	maxOutbound   int
+	bc            *coin.Blockchain
+	miner         *coin.Miner
+	addrMgr       *addrManager
+	mempool       *mempool.Mempool
+	bans          *BanList
+	banThreshold  int
+	banDuration   time.Duration
+	events        *eventBus
+
+	// rpc, rest and ws are nil if the JSON-RPC server, the REST API or the
+	// WebSocket event stream are disabled
+	rpc  *rpcServer
+	rest *restServer
+	ws   *wsServer
+
+	mu       sync.Mutex
+	peers    map[string]*peer
+	listener net.Listener
+
+	// connectNow wakes up the connection loop
+	connectNow chan struct{}
+
+	// mining is set while a mining goroutine is running
+	mining     int32
+	miningMu   sync.Mutex
+	stopMining context.CancelFunc
+
+	wg      sync.WaitGroup
+	quit    chan struct{}
+	stopped sync.Once
+}
+
+// NewNode returns a node for the blockchain that is configured by cfg
+func NewNode(bc *coin.Blockchain, cfg Config) *Node {
+	maxOutbound := cfg.MaxOutbound
+	if maxOutbound <= 0 {
+		maxOutbound = defaultMaxOutbound
+	}
+	banThreshold := cfg.BanThreshold
+	if banThreshold <= 0 {
+		banThreshold = defaultBanThreshold
+	}
+	banDuration := cfg.BanDuration
+	if banDuration <= 0 {
+		banDuration = defaultBanDuration
+	}
+
+	n := &Node{
+		address:       cfg.Address,
+		miningAddress: cfg.MiningAddress,
+		seeds:         append([]string{}, cfg.Seeds...),
+		maxOutbound:   maxOutbound,
+		bc:            bc,
+		miner:         coin.NewMiner(),
+		addrMgr:       newAddrManager(cfg.PeersFile),
+		mempool:       mempool.New(coin.UTXOSet{Blockchain: bc}, bc.Params()),
+		bans:          NewBanList(cfg.BanFile),
+		banThreshold:  banThreshold,
+		banDuration:   banDuration,
+		events:        newEventBus(),
+		peers:         make(map[string]*peer),
+		connectNow:    make(chan struct{}, 1),
+		quit:          make(chan struct{}),
+	}
+	if cfg.RPC.Address != "" {
+		n.rpc = newRPCServer(n, cfg.RPC)
+	}
+	if cfg.RESTAddress != "" {
+		n.rest = newRESTServer(n, cfg.RESTAddress)
+	}
+	if cfg.WSAddress != "" {
+		n.ws = newWSServer(n, cfg.WSAddress)
+	}
+	bc.Subscribe(n.handleChainNotification)
+	n.mempool.Subscribe(n.handleMempoolNotification)
+
+	return n
+}
+
+// Start listens on the address of the node and loads the known addresses.
+// Peers are accepted, outbound connections are maintained and RPC, REST and
+// WebSocket clients are served in the background until Stop is called.
+func (n *Node) Start() error {
+	err := n.addrMgr.load()
+	if err != nil {
+		return fmt.Errorf("failed loading peers: %s", err)
+	}
+	err = n.bans.Load()
+	if err != nil {
+		return fmt.Errorf("failed loading ban list: %s", err)
+	}
+	n.addrMgr.addAddresses(n.seeds)
+
+	ln, err := net.Listen(protocol, n.address)
+	if err != nil {
+		return err
+	}
+
+	err = n.startServers()
+	if err != nil {
+		ln.Close()
+		n.stopServers()
+		return err
+	}
+
+	n.mu.Lock()
+	n.listener = ln
+	n.mu.Unlock()
+
+	n.wg.Add(2)
+	go n.acceptLoop(ln)
+	go n.connectionLoop()
+
+	return nil
+}
+
+// Stop closes the listeners, disconnects all peers and aborts mining. It
+// waits until all goroutines of the node have exited.
+func (n *Node) Stop() {
+	n.stopped.Do(func() {
+		close(n.quit)
+
+		n.mu.Lock()
+		if n.listener != nil {
+			n.listener.Close()
+		}
+		for _, p := range n.peers {
+			p.conn.Close()
+		}
+		n.mu.Unlock()
+
+		n.stopServers()
+		n.abortMining()
+	})
+
+	n.wg.Wait()
+
+	err := n.addrMgr.save()
+	if err != nil {
+		fmt.Printf("Failed saving peers: %s\n", err)
+	}
+}
+
+// startServers starts the enabled RPC, REST and WebSocket servers
+func (n *Node) startServers() error {
+	if n.rpc != nil {
+		err := n.rpc.start()
+		if err != nil {
+			return fmt.Errorf("failed starting RPC server: %s", err)
+		}
+	}
+
+	if n.rest != nil {
+		err := n.rest.start()
+		if err != nil {
+			return fmt.Errorf("failed starting REST server: %s", err)
+		}
+	}
+
+	if n.ws != nil {
+		err := n.ws.start()
+		if err != nil {
+			return fmt.Errorf("failed starting WebSocket server: %s", err)
+		}
+	}
+
+	return nil
+}
+
+// stopServers stops the enabled servers. Servers that were not started are
+// closed as well, so they can not be started anymore.
+func (n *Node) stopServers() {
+	if n.rpc != nil {
+		n.rpc.stop()
+	}
+	if n.rest != nil {
+		n.rest.stop()
+	}
+	if n.ws != nil {
+		n.ws.stop()
+	}
+}
+
+// Done returns a channel that is closed when the node is stopped
+func (n *Node) Done() <-chan struct{} {
+	return n.quit
+}
+
+// Address returns the address the node listens on
+func (n *Node) Address() string {
+	return n.address
+}
+
+// Blockchain returns the blockchain of the node
+func (n *Node) Blockchain() *coin.Blockchain {
+	return n.bc
+}
+
+// PeerCount returns the number of peers that completed the handshake
+func (n *Node) PeerCount() int {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	return len(n.peers)
+}
+
+// MempoolSize returns the number of transactions waiting to be mined
+func (n *Node) MempoolSize() int {
+	return n.mempool.Count()
+}
+
+// MempoolTxDescs returns the transactions waiting to be mined in the order
+// they were added
+func (n *Node) MempoolTxDescs() []*mempool.TxDesc {
+	return n.mempool.TxDescs()
+}
+
+// FetchMempoolTransaction returns the transaction with the ID if it is in the
+// mempool
+func (n *Node) FetchMempoolTransaction(id []byte) (*coin.Transaction, bool) {
+	return n.mempool.FetchTransaction(id)
+}
+
+// SubmitTransaction adds a transaction to the mempool and announces it to the
+// peers
+func (n *Node) SubmitTransaction(tx *coin.Transaction) error {
+	_, err := n.mempool.ProcessTransaction(tx)
+	if err != nil {
+		return err
+	}
+
+	n.relayTransaction(tx, nil)
+	return nil
+}
+
+// spendableOutputs returns the outputs of the public key hash that a new
+// transaction may spend. These are the outputs of the UTXO set that are mature
+// in the next block and not spent by the mempool, followed by the unspent
+// outputs of transactions in the mempool.
+func (n *Node) spendableOutputs(pubKeyHash []byte) ([]coin.UnspentOutput, error) {
+	utxos := coin.UTXOSet{Blockchain: n.bc}
+	unspent, err := utxos.FindUnspentOutputs(pubKeyHash)
+	if err != nil {
+		return nil, err
+	}
+
+	height, err := utxos.BestHeight()
+	if err != nil {
+		return nil, err
+	}
+
+	var spendable []coin.UnspentOutput
+	for _, out := range unspent {
+		if out.IsMature(height+1, n.bc.Params()) && !n.mempool.IsSpent(out.Txid, out.Vout) {
+			spendable = append(spendable, out)
+		}
+	}
+
+	return append(spendable, n.mempool.FindUnspentOutputs(pubKeyHash)...), nil
+}
+
+// findTransaction returns a transaction of the mempool or the main chain and
+// whether it is in the mempool
+func (n *Node) findTransaction(id []byte) (*coin.Transaction, bool, error) {
+	tx, ok := n.mempool.FetchTransaction(id)
+	if ok {
+		return tx, true, nil
+	}
+
+	found, err := n.bc.FindTransaction(id)
+	if err != nil {
+		return nil, false, err
+	}
+
+	return &found, false, nil
+}
+
+// serveHTTP listens on the address and serves requests with srv in the
+// background until srv is closed
+func (n *Node) serveHTTP(srv *http.Server, address, name string) error {
+	ln, err := net.Listen(protocol, address)
+	if err != nil {
+		return err
+	}
+
+	n.wg.Add(1)
+	go func() {
+		defer n.wg.Done()
+
+		err := srv.Serve(ln)
+		if err != http.ErrServerClosed {
+			fmt.Printf("%s server failed: %s\n", name, err)
+		}
+	}()
+
+	return nil
+}
+
+// PeerInfo describes a peer that completed the handshake
+type PeerInfo struct {
+	// Address is the remote address of the connection
+	Address string `json:"address"`
+
+	// ListenAddress is the address the peer was dialed at or announced
+	ListenAddress string `json:"listenaddress"`
+
+	Inbound    bool   `json:"inbound"`
+	Version    int    `json:"version"`
+	Services   uint64 `json:"services"`
+	BestHeight int    `json:"bestheight"`
+}
+
+func newPeerInfo(p *peer) PeerInfo {
+	return PeerInfo{
+		Address:       p.String(),
+		ListenAddress: p.addr,
+		Inbound:       p.inbound,
+		Version:       p.version,
+		Services:      p.services,
+		BestHeight:    p.bestHeight,
+	}
+}
+
+// Peers returns the peers that completed the handshake
+func (n *Node) Peers() []PeerInfo {
+	var infos []PeerInfo
+	for _, p := range n.connectedPeers() {
+		infos = append(infos, newPeerInfo(p))
+	}
+
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Address < infos[j].Address
+	})
+
+	return infos
+}
+
+// OutboundCount returns the number of connections the node opened
+func (n *Node) OutboundCount() int {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	count := 0
+	for _, p := range n.peers {
+		if !p.inbound {
+			count++
+		}
+	}
+
+	return count
+}
+
+// KnownAddresses returns the number of addresses in the address manager
+func (n *Node) KnownAddresses() int {
+	return n.addrMgr.size()
+}
+
+// Connect opens an outbound connection to the node at address
+func (n *Node) Connect(address string) error {
+	if n.isBanned(address) {
+		return fmt.Errorf("%s is banned", address)
+	}
+	n.addrMgr.attempt(address)
+
+	conn, err := net.DialTimeout(protocol, address, dialTimeout)
+	if err != nil {
+		fmt.Printf("%s is not available\n", address)
+		return err
+	}
+
+	// The address may be a name for a banned IP
+	if n.isBanned(conn.RemoteAddr().String()) {
+		conn.Close()
+		return fmt.Errorf("%s is banned", address)
+	}
+
+	p := newPeer(n, conn, false, n.bc.Params().Net)
+	p.addr = address
+
+	n.wg.Add(1)
+	err = n.runPeer(p, func() {
+		n.wg.Done()
+	})
+	if err != nil {
+		conn.Close()
+		n.wg.Done()
+	}
+
+	return err
+}
+
+// connectionLoop opens outbound connections to known addresses until the node
+// has maxOutbound of them and saves the known addresses from time to time. It
+// also reloads the ban list, so bans added on the command line take effect
+// while the node is running.
+func (n *Node) connectionLoop() {
+	defer n.wg.Done()
+
+	ticker := time.NewTicker(connectInterval)
+	defer ticker.Stop()
+	lastSave := time.Now()
+
+	for {
+		n.connectToPeers()
+
+		if time.Since(lastSave) > saveAddrInterval {
+			err := n.addrMgr.save()
+			if err != nil {
+				fmt.Printf("Failed saving peers: %s\n", err)
+			}
+			lastSave = time.Now()
+		}
+
+		select {
+		case <-ticker.C:
+			n.reloadBans()
+		case <-n.connectNow:
+		case <-n.quit:
+			return
+		}
+	}
+}
+
+func (n *Node) connectToPeers() {
+	for n.OutboundCount() < n.maxOutbound {
+		select {
+		case <-n.quit:
+			return
+		default:
+		}
+
+		address := n.addrMgr.getAddress(n.skipAddress)
+		if address == "" {
+			return
+		}
+
+		n.Connect(address)
+	}
+}
+
+// reloadBans merges the ban list file into the bans of the node and
+// disconnects peers that are banned
+func (n *Node) reloadBans() {
+	err := n.bans.Load()
+	if err != nil {
+		fmt.Printf("Failed loading ban list: %s\n", err)
+		return
+	}
+
+	for _, p := range n.connectedPeers() {
+		if n.isBanned(p.String()) {
+			fmt.Printf("Disconnecting banned peer %s\n", p)
+			p.conn.Close()
+		}
+	}
+}
+
+// wakeConnectionLoop lets the connection loop check for missing connections
+// without waiting for the next tick
+func (n *Node) wakeConnectionLoop() {
+	select {
+	case n.connectNow <- struct{}{}:
+	default:
+	}
+}
+
+// skipAddress reports whether the address is the node itself, a banned node
+// or a node that is already connected
+func (n *Node) skipAddress(address string) bool {
+	if address == n.address || n.isBanned(address) {
+		return true
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	for _, p := range n.peers {
+		if p.addr == address {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (n *Node) acceptLoop(ln net.Listener) {
+	defer n.wg.Done()
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			select {
+			case <-n.quit:
+			default:
+				fmt.Printf("Failed accepting connections: %s\n", err)
+				go n.Stop()
+			}
+			return
+		}
+
+		if n.isBanned(conn.RemoteAddr().String()) {
+			fmt.Printf("Rejected connection from banned %s\n", conn.RemoteAddr())
+			conn.Close()
+			continue
+		}
+
+		n.wg.Add(1)
+		go func() {
+			defer n.wg.Done()
+			defer conn.Close()
+
+			err := n.runPeer(newPeer(n, conn, true, n.bc.Params().Net), nil)
+			if err != nil {
+				fmt.Printf("Handshake with %s failed: %s\n", conn.RemoteAddr(), err)
+			}
+		}()
+	}
+}
+
+// runPeer performs the handshake and runs the read loop of the peer. Inbound
+// peers are run on the calling goroutine, outbound peers in the background.
+// done is called once the read loop of an outbound peer has exited.
+func (n *Node) runPeer(p *peer, done func()) error {
+	version, err := n.versionMsg()
+	if err != nil {
+		return err
+	}
+
+	err = p.handshake(version)
+	if err != nil {
+		return err
+	}
+
+	n.addPeer(p)
+
+	run := func() {
+		defer n.removePeer(p)
+		p.run()
+	}
+
+	if !p.inbound {
+		go func() {
+			defer done()
+			defer p.conn.Close()
+			run()
+		}()
+		return nil
+	}
+
+	run()
+	return nil
+}
+
+func (n *Node) versionMsg() (*version, error) {
+	bestHeight, err := n.bc.GetBestHeight()
+	if err != nil {
+		return nil, err
+	}
+
+	return &version{
+		Version:    nodeVersion,
+		Services:   serviceNodeNetwork,
+		BestHeight: bestHeight,
+		AddrFrom:   n.address,
+	}, nil
+}
+
+// addPeer registers a peer after the handshake and starts syncing with it if
+// it has a longer chain
+func (n *Node) addPeer(p *peer) {
+	n.mu.Lock()
+	select {
+	case <-n.quit:
+		// Stop already closed the connections of the registered peers
+		p.conn.Close()
+	default:
+	}
+	n.peers[p.String()] = p
+	n.mu.Unlock()
+
+	fmt.Printf("Connected to peer %s with protocol version %d\n", p, p.version)
+	info := newPeerInfo(p)
+	n.events.publish(&Event{Type: EventPeerConnected, Peer: &info})
+	if p.inbound {
+		// The peer announced the address it listens on
+		if p.addr != "" && p.addr != n.address {
+			n.addrMgr.addAddresses([]string{p.addr})
+		}
+	} else {
+		n.addrMgr.good(p.addr)
+
+		err := p.sendGetAddr()
+		if err != nil {
+			fmt.Printf("Failed requesting addresses from %s: %s\n", p, err)
+		}
+	}
+
+	bestHeight, err := n.bc.GetBestHeight()
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	if p.bestHeight > bestHeight {
+		err = n.requestHeadersFrom(p)
+		if err != nil {
+			fmt.Printf("Failed requesting headers from %s: %s\n", p, err)
+		}
+	}
+}
+
+func (n *Node) removePeer(p *peer) {
+	n.mu.Lock()
+	delete(n.peers, p.String())
+	n.mu.Unlock()
+
+	fmt.Printf("Peer %s disconnected\n", p)
+	info := newPeerInfo(p)
+	n.events.publish(&Event{Type: EventPeerDisconnected, Peer: &info})
+	if !p.inbound {
+		n.wakeConnectionLoop()
+	}
+}
+
+// connectedPeers returns the peers that completed the handshake
+func (n *Node) connectedPeers() []*peer {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	var list []*peer
+	for _, p := range n.peers {
+		list = append(list, p)
+	}
+
+	return list
+}
+
+// broadcastInv announces the items to all peers except the given one
+func (n *Node) broadcastInv(kind string, items [][]byte, except *peer) {
+	for _, p := range n.connectedPeers() {
+		if p == except {
+			continue
+		}
+
+		err := p.sendInv(kind, items)
+		if err != nil {
+			fmt.Printf("Could not reach peer %s\n", p)
+		}
+	}
+}
+
+// handleChainNotification publishes the changes of the main chain and keeps
+// the mempool consistent with it
+func (n *Node) handleChainNotification(notification *coin.Notification) {
+	switch notification.Type {
+	case coin.NTBlockConnected:
+		n.events.publish(&Event{Type: EventBlockConnected, Block: notification.Block})
+	case coin.NTBlockDisconnected:
+		n.events.publish(&Event{Type: EventBlockDisconnected, Block: notification.Block})
+	case coin.NTTipChanged:
+		// The mempool is updated with the whole change, as the UTXO set is
+		// already at the new tip
+		n.mempool.TipChanged(notification.Detached, notification.Attached)
+		n.events.publish(&Event{Type: EventTipChanged, Block: notification.Block})
+
+		// Waiting transactions may have become minable
+		if n.miningAddress != "" && n.MempoolSize() >= transactionsInBlock {
+			n.startMining()
+		}
+	}
+}
+
+// startMining mines blocks in the background until the mempool has less than
+// transactionsInBlock transactions or none of them can be mined yet. It does
+// nothing if the node is already mining.
+func (n *Node) startMining() {
+	if !atomic.CompareAndSwapInt32(&n.mining, 0, 1) {
+		return
+	}
+
+	n.wg.Add(1)
+	go func() {
+		defer n.wg.Done()
+		defer atomic.StoreInt32(&n.mining, 0)
+
+		for n.MempoolSize() >= transactionsInBlock {
+			select {
+			case <-n.quit:
+				return
+			default:
+			}
+
+			built, err := n.mineBlock()
+			if err != nil {
+				fmt.Printf("Mining failed: %s\n", err)
+				return
+			}
+
+			// Mining is started again by the next transaction or block
+			if !built {
+				return
+			}
+		}
+	}()
+}
+
+// abortMining stops the miner if it is currently working on a block
+func (n *Node) abortMining() {
+	n.miningMu.Lock()
+	defer n.miningMu.Unlock()
+
+	if n.stopMining != nil {
+		n.stopMining()
+		n.stopMining = nil
+	}
+}
@@ -0,0 +1,338 @@
+package server
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	coin "github.com/thesoenke/go-coin"
+	"github.com/thesoenke/go-coin/mempool"
+)
+
+// testParams are the parameters of the chains of the tests. Blocks of the
+// regression test network are mined instantly.
+var testParams = &coin.RegTestParams
+
+// openChains creates a blockchain for the first node ID in a temporary
+// directory and copies it for the other node IDs, so all chains share the
+// genesis block. It returns the chains and the directory.
+func openChains(t *testing.T, address string, nodeIDs ...int) ([]*coin.Blockchain, string) {
+	dir, err := ioutil.TempDir("", "coin")
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		os.RemoveAll(dir)
+	})
+
+	bc, err := coin.CreateBlockchain(dir, address, nodeIDs[0], testParams)
+	require.NoError(t, err)
+	require.NoError(t, bc.DB.Close())
+
+	data, err := ioutil.ReadFile(filepath.Join(dir, fmt.Sprintf("blockchain_%d.db", nodeIDs[0])))
+	require.NoError(t, err)
+
+	var chains []*coin.Blockchain
+	for i, nodeID := range nodeIDs {
+		if i > 0 {
+			err = ioutil.WriteFile(filepath.Join(dir, fmt.Sprintf("blockchain_%d.db", nodeID)), data, 0600)
+			require.NoError(t, err)
+		}
+
+		bc, err := coin.NewBlockchain(dir, nodeID, testParams)
+		require.NoError(t, err)
+		t.Cleanup(func() {
+			bc.DB.Close()
+		})
+		chains = append(chains, bc)
+	}
+
+	return chains, dir
+}
+
+// waitFor polls the condition until it is true or fails the test after five
+// seconds
+func waitFor(t *testing.T, what string, condition func() bool) {
+	deadline := time.Now().Add(5 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting until %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestNodesSyncInOneProcess(t *testing.T) {
+	wallet, err := coin.NewWallet()
+	require.NoError(t, err)
+	address := string(wallet.GetAddress(testParams))
+
+	chains, _ := openChains(t, address, 23000, 23001)
+	for i := 0; i < 3; i++ {
+		_, err := chains[0].MineBlock([]*coin.Transaction{coin.NewCoinbaseTX(address, "", 10)})
+		require.NoError(t, err)
+	}
+
+	seed := NewNode(chains[0], Config{Address: "localhost:23000", Seeds: []string{"localhost:23000"}})
+	require.NoError(t, seed.Start())
+	defer seed.Stop()
+
+	node := NewNode(chains[1], Config{Address: "localhost:23001", MiningAddress: address, Seeds: []string{"localhost:23000"}})
+	require.NoError(t, node.Start())
+	defer node.Stop()
+
+	waitFor(t, "node synced the chain", func() bool {
+		height, err := chains[1].GetBestHeight()
+		return err == nil && height == 3
+	})
+
+	assert.Equal(t, 1, seed.PeerCount())
+	assert.Equal(t, 1, node.PeerCount())
+
+	node.Stop()
+	waitFor(t, "seed noticed the disconnect", func() bool {
+		return seed.PeerCount() == 0
+	})
+}
+
+func TestNodesDiscoverPeersThroughSeed(t *testing.T) {
+	wallet, err := coin.NewWallet()
+	require.NoError(t, err)
+	chains, dir := openChains(t, string(wallet.GetAddress(testParams)), 23010, 23011, 23012)
+	peersFile := PeersFile(dir, 23012)
+
+	seed := NewNode(chains[0], Config{Address: "localhost:23010"})
+	require.NoError(t, seed.Start())
+	defer seed.Stop()
+
+	first := NewNode(chains[1], Config{Address: "localhost:23011", Seeds: []string{"localhost:23010"}})
+	require.NoError(t, first.Start())
+	defer first.Stop()
+
+	waitFor(t, "seed accepted the first node", func() bool {
+		return seed.PeerCount() == 1
+	})
+
+	second := NewNode(chains[2], Config{
+		Address:   "localhost:23012",
+		Seeds:     []string{"localhost:23010"},
+		PeersFile: peersFile,
+	})
+	require.NoError(t, second.Start())
+
+	// The second node only knows the seed and learns the first node from it
+	waitFor(t, "second node connected to both nodes", func() bool {
+		return second.OutboundCount() == 2
+	})
+	assert.Equal(t, 2, first.PeerCount())
+
+	second.Stop()
+
+	addrMgr := newAddrManager(peersFile)
+	require.NoError(t, addrMgr.load())
+	assert.Len(t, addrMgr.tried, 2)
+	assert.NotNil(t, addrMgr.tried["localhost:23011"])
+}
+
+func TestMisbehavingPeerIsBanned(t *testing.T) {
+	wallet, err := coin.NewWallet()
+	require.NoError(t, err)
+	chains, dir := openChains(t, string(wallet.GetAddress(testParams)), 23020)
+	banFile := BanFile(dir, 23020)
+
+	node := NewNode(chains[0], Config{Address: "localhost:23020", BanFile: banFile, BanDuration: time.Hour})
+	require.NoError(t, node.Start())
+	defer node.Stop()
+
+	conn, err := net.Dial(protocol, "localhost:23020")
+	require.NoError(t, err)
+	defer conn.Close()
+
+	p := newPeer(nil, conn, false, testParams.Net)
+	require.NoError(t, p.handshake(&version{Version: nodeVersion, AddrFrom: "localhost:23029"}))
+	waitFor(t, "node accepted the peer", func() bool {
+		return node.PeerCount() == 1
+	})
+
+	// Each malformed block adds 50 to the score, the second one reaches the
+	// ban threshold
+	for i := 0; i < 2; i++ {
+		require.NoError(t, p.sendMessage("block", (&block{[]byte{0xff}}).serialize()))
+	}
+
+	waitFor(t, "node disconnected the peer", func() bool {
+		return node.PeerCount() == 0
+	})
+
+	bans := NewBanList(banFile)
+	require.NoError(t, bans.Load())
+	assert.True(t, bans.IsBanned("127.0.0.1"))
+
+	// A banned host cannot connect again
+	conn, err = net.Dial(protocol, "localhost:23020")
+	require.NoError(t, err)
+	defer conn.Close()
+	p = newPeer(nil, conn, false, testParams.Net)
+	assert.Error(t, p.handshake(&version{Version: nodeVersion}))
+}
+
+func TestPeerOfOtherNetworkIsRejected(t *testing.T) {
+	wallet, err := coin.NewWallet()
+	require.NoError(t, err)
+	chains, _ := openChains(t, string(wallet.GetAddress(testParams)), 23040)
+
+	node := NewNode(chains[0], Config{Address: "localhost:23040"})
+	require.NoError(t, node.Start())
+	defer node.Stop()
+
+	conn, err := net.Dial(protocol, "localhost:23040")
+	require.NoError(t, err)
+	defer conn.Close()
+
+	p := newPeer(nil, conn, false, coin.MainNetParams.Net)
+	assert.Error(t, p.handshake(&version{Version: nodeVersion}))
+	assert.Equal(t, 0, node.PeerCount())
+}
+
+func TestUnrequestedTransactions(t *testing.T) {
+	wallet, err := coin.NewWallet()
+	require.NoError(t, err)
+	chains, _ := openChains(t, string(wallet.GetAddress(testParams)), 23050)
+
+	node := NewNode(chains[0], Config{Address: "localhost:23050"})
+	require.NoError(t, node.Start())
+	defer node.Stop()
+
+	// Coinbases are rejected by the mempool without a penalty, so only
+	// pushing them unrequested adds to the score
+	pushTransactions := func(p *peer, count int) {
+		for i := 0; i < count; i++ {
+			require.NoError(t, p.sendTx(coin.NewCoinbaseTX(string(wallet.GetAddress(testParams)), "", 1)))
+		}
+		require.NoError(t, p.sendMessage("ping", (&ping{}).serialize()))
+	}
+
+	conn, err := net.Dial(protocol, "localhost:23050")
+	require.NoError(t, err)
+	defer conn.Close()
+
+	submitter := newPeer(nil, conn, false, testParams.Net)
+	require.NoError(t, submitter.handshake(&version{Version: nodeVersion, Services: serviceSubmitOnly}))
+	waitFor(t, "node accepted the submitter", func() bool {
+		return node.PeerCount() == 1
+	})
+	pushTransactions(submitter, maxSubmittedTxs)
+	command, _, err := readMessage(conn, testParams.Net)
+	require.NoError(t, err)
+	assert.Equal(t, "pong", command)
+	assert.Equal(t, 1, node.PeerCount())
+
+	// Leaving out the listen address does not exempt a peer
+	conn, err = net.Dial(protocol, "localhost:23050")
+	require.NoError(t, err)
+	defer conn.Close()
+
+	p := newPeer(nil, conn, false, testParams.Net)
+	require.NoError(t, p.handshake(&version{Version: nodeVersion}))
+	waitFor(t, "node accepted the peer", func() bool {
+		return node.PeerCount() == 2
+	})
+	pushTransactions(p, 5)
+	waitFor(t, "node disconnected the peer", func() bool {
+		return node.PeerCount() == 1
+	})
+
+	// Announcing the submit-only service does not exempt a client beyond the
+	// transactions it may submit
+	pushTransactions(submitter, 5)
+	waitFor(t, "node disconnected the submitter", func() bool {
+		return node.PeerCount() == 0
+	})
+}
+
+func TestMineBlockWithoutTransactions(t *testing.T) {
+	wallet, err := coin.NewWallet()
+	require.NoError(t, err)
+	address := string(wallet.GetAddress(testParams))
+	chains, _ := openChains(t, address, 23060)
+
+	// Without transactions that can be selected no block is built, so the
+	// mining loop stops instead of retrying
+	node := NewNode(chains[0], Config{Address: "localhost:23060", MiningAddress: address})
+	built, err := node.mineBlock()
+	require.NoError(t, err)
+	assert.False(t, built)
+
+	height, err := chains[0].GetBestHeight()
+	require.NoError(t, err)
+	assert.Equal(t, 0, height)
+}
+
+// phantomUTXOs is a UTXO set with the outputs of a transaction that is not
+// in the chain, so the mempool accepts transactions that spend them
+type phantomUTXOs struct {
+	coin.UTXOSet
+	phantom *coin.Transaction
+}
+
+func (u phantomUTXOs) FetchEntry(txid []byte, vout int) (*coin.UtxoEntry, error) {
+	if bytes.Equal(txid, u.phantom.ID) && vout < len(u.phantom.Vout) {
+		return &coin.UtxoEntry{Output: u.phantom.Vout[vout]}, nil
+	}
+
+	return u.UTXOSet.FetchEntry(txid, vout)
+}
+
+func TestMineBlockEvictsInvalidTransactions(t *testing.T) {
+	wallet, err := coin.NewWallet()
+	require.NoError(t, err)
+	address := string(wallet.GetAddress(testParams))
+	chains, _ := openChains(t, address, 23066)
+	bc := chains[0]
+
+	// Mine until the genesis reward is mature
+	for i := 0; i < testParams.CoinbaseMaturity; i++ {
+		_, err := bc.MineBlock([]*coin.Transaction{coin.NewCoinbaseTX(address, "", 10)})
+		require.NoError(t, err)
+	}
+
+	phantom := coin.NewCoinbaseTX(address, "", 10)
+	node := NewNode(bc, Config{Address: "localhost:23066", MiningAddress: address})
+	node.mempool = mempool.New(phantomUTXOs{coin.UTXOSet{Blockchain: bc}, phantom}, testParams)
+
+	UTXOSet := coin.UTXOSet{Blockchain: bc}
+	valid, err := coin.NewUTXOTransaction(wallet, address, 3, 1, &UTXOSet)
+	require.NoError(t, err)
+	_, err = node.mempool.ProcessTransaction(valid)
+	require.NoError(t, err)
+
+	invalid := &coin.Transaction{
+		Vin:  []coin.TXInput{{Txid: phantom.ID, Vout: 0, PubKey: wallet.PublicKey}},
+		Vout: []coin.TXOutput{*coin.NewTXOutput(5, address)},
+	}
+	invalid.ID = invalid.Hash()
+	require.NoError(t, invalid.Sign(wallet.PrivateKey, map[string]coin.Transaction{hex.EncodeToString(phantom.ID): *phantom}))
+	_, err = node.mempool.ProcessTransaction(invalid)
+	require.NoError(t, err)
+
+	// The invalid transaction is evicted instead of failing the miner
+	built, err := node.mineBlock()
+	require.NoError(t, err)
+	assert.True(t, built)
+	assert.False(t, node.mempool.HaveTransaction(invalid.ID))
+
+	built, err = node.mineBlock()
+	require.NoError(t, err)
+	assert.True(t, built)
+
+	tip, err := bc.GetBlockByHeight(testParams.CoinbaseMaturity + 1)
+	require.NoError(t, err)
+	require.Len(t, tip.Transactions, 2)
+	assert.Equal(t, valid.ID, tip.Transactions[1].ID)
+}
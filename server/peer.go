@@ -0,0 +1,274 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	// messageHeaderLength is the length of the magic, command, payload length
+	// and checksum that precede the payload
+	messageHeaderLength = 4 + commandLength + 4 + 4
+
+	// maxPayloadLength limits the size of a single message
+	maxPayloadLength = 32 * 1024 * 1024
+
+	dialTimeout      = 10 * time.Second
+	handshakeTimeout = 30 * time.Second
+	writeTimeout     = 30 * time.Second
+
+	// A ping is sent every pingInterval. A peer that does not send anything
+	// for idleTimeout is considered dead.
+	pingInterval = 2 * time.Minute
+	idleTimeout  = 5 * time.Minute
+)
+
+// peer is a long-lived connection to another node. Messages of a peer are
+// handled sequentially by its read loop.
+type peer struct {
+	node    *Node
+	conn    net.Conn
+	inbound bool
+
+	// magic starts every message and identifies the network
+	magic uint32
+
+	// addr is the address the node was dialed at for outbound peers and the
+	// listen address announced in the version message for inbound peers
+	addr string
+
+	// Announced by the peer in its version message
+	version    int
+	services   uint64
+	bestHeight int
+
+	// submitAllowance is the number of transactions an inbound client that
+	// announced serviceSubmitOnly may still push without being asked for
+	// them. Any peer can announce the service, so the allowance is limited
+	// and further unrequested transactions are punished. It is only accessed
+	// by the read loop.
+	submitAllowance int
+
+	// blocksInTransit are the blocks that are requested from the peer next.
+	// It is only accessed by the read loop.
+	blocksInTransit [][]byte
+
+	// requested are the blocks and transactions requested from the peer and
+	// headersRequested is set while a getheaders message is unanswered. Both
+	// are only accessed by the read loop.
+	requested        map[string]bool
+	headersRequested bool
+
+	// score is the misbehavior score of the peer. It is only accessed by the
+	// read loop.
+	score int
+
+	writeMu sync.Mutex
+	quit    chan struct{}
+}
+
+func newPeer(node *Node, conn net.Conn, inbound bool, magic uint32) *peer {
+	return &peer{
+		node:      node,
+		conn:      conn,
+		inbound:   inbound,
+		magic:     magic,
+		requested: make(map[string]bool),
+		quit:      make(chan struct{}),
+	}
+}
+
+func (p *peer) String() string {
+	return p.conn.RemoteAddr().String()
+}
+
+// handshake exchanges version and verack messages with the peer. The node
+// that opened the connection sends its version first. Both sides use the
+// lower of the two protocol versions.
+func (p *peer) handshake(local *version) error {
+	p.conn.SetDeadline(time.Now().Add(handshakeTimeout))
+	defer p.conn.SetDeadline(time.Time{})
+
+	if !p.inbound {
+		err := p.sendMessage("version", local.serialize())
+		if err != nil {
+			return err
+		}
+	}
+
+	versionReceived := false
+	for {
+		command, payload, err := readMessage(p.conn, p.magic)
+		if err != nil {
+			return err
+		}
+
+		switch command {
+		case "version":
+			if versionReceived {
+				return errors.New("duplicate version message")
+			}
+
+			var msg version
+			err = msg.deserialize(payload)
+			if err != nil {
+				return err
+			}
+
+			if msg.Version < minProtocolVersion {
+				return fmt.Errorf("protocol version %d is not supported", msg.Version)
+			}
+
+			p.version = msg.Version
+			if p.version > nodeVersion {
+				p.version = nodeVersion
+			}
+			p.services = msg.Services
+			p.bestHeight = msg.BestHeight
+			if p.inbound {
+				p.addr = msg.AddrFrom
+				if msg.Services&serviceSubmitOnly != 0 {
+					p.submitAllowance = maxSubmittedTxs
+				}
+			}
+			versionReceived = true
+
+			if p.inbound {
+				err = p.sendMessage("version", local.serialize())
+				if err != nil {
+					return err
+				}
+			}
+
+			err = p.sendMessage("verack", (&verack{}).serialize())
+			if err != nil {
+				return err
+			}
+		case "verack":
+			if !versionReceived {
+				return errors.New("verack received before version")
+			}
+
+			return (&verack{}).deserialize(payload)
+		default:
+			return fmt.Errorf("received %s before the handshake completed", command)
+		}
+	}
+}
+
+// run reads and handles messages from the peer until the connection fails or
+// the peer is idle for too long
+func (p *peer) run() {
+	defer close(p.quit)
+	go p.pingLoop()
+
+	for {
+		p.conn.SetReadDeadline(time.Now().Add(idleTimeout))
+		command, payload, err := readMessage(p.conn, p.magic)
+		if err != nil {
+			if err != io.EOF {
+				fmt.Printf("Disconnecting peer %s: %s\n", p, err)
+			}
+			return
+		}
+
+		fmt.Printf("Received %s command from %s\n", command, p)
+		err = p.node.handleMessage(p, command, payload)
+		if m, ok := err.(*misbehavior); ok {
+			p.node.addMisbehavior(p, m)
+		} else if err != nil {
+			fmt.Printf("Failed handling %s from %s: %s\n", command, p, err)
+		}
+	}
+}
+
+// pingLoop keeps the connection alive until the read loop stops
+func (p *peer) pingLoop() {
+	ticker := time.NewTicker(pingInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			err := p.sendMessage("ping", (&ping{Nonce: rand.Uint64()}).serialize())
+			if err != nil {
+				p.conn.Close()
+				return
+			}
+		case <-p.quit:
+			return
+		}
+	}
+}
+
+// sendMessage frames the payload and writes it to the connection. It is safe
+// to call from multiple goroutines.
+func (p *peer) sendMessage(command string, payload []byte) error {
+	p.writeMu.Lock()
+	defer p.writeMu.Unlock()
+
+	p.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	return writeMessage(p.conn, p.magic, command, payload)
+}
+
+// writeMessage writes the magic, the command, the payload length, the
+// checksum of the payload and the payload
+func writeMessage(w io.Writer, magic uint32, command string, payload []byte) error {
+	msg := make([]byte, messageHeaderLength, messageHeaderLength+len(payload))
+	binary.BigEndian.PutUint32(msg[0:4], magic)
+	copy(msg[4:4+commandLength], commandToBytes(command))
+	binary.BigEndian.PutUint32(msg[4+commandLength:], uint32(len(payload)))
+	copy(msg[8+commandLength:], checksum(payload))
+	msg = append(msg, payload...)
+
+	_, err := w.Write(msg)
+	return err
+}
+
+// readMessage reads a message written by writeMessage and verifies its magic
+// and checksum
+func readMessage(r io.Reader, expectedMagic uint32) (string, []byte, error) {
+	var header [messageHeaderLength]byte
+	_, err := io.ReadFull(r, header[:])
+	if err != nil {
+		return "", nil, err
+	}
+
+	magic := binary.BigEndian.Uint32(header[0:4])
+	if magic != expectedMagic {
+		return "", nil, fmt.Errorf("unknown network magic %08x", magic)
+	}
+
+	command := bytesToCommand(header[4 : 4+commandLength])
+	length := binary.BigEndian.Uint32(header[4+commandLength:])
+	if length > maxPayloadLength {
+		return "", nil, fmt.Errorf("%s message of %d bytes is too large", command, length)
+	}
+
+	payload := make([]byte, length)
+	_, err = io.ReadFull(r, payload)
+	if err != nil {
+		return "", nil, err
+	}
+
+	if string(checksum(payload)) != string(header[8+commandLength:]) {
+		return "", nil, fmt.Errorf("%s message has an invalid checksum", command)
+	}
+
+	return command, payload, nil
+}
+
+// checksum returns the first four bytes of the double SHA-256 of the payload
+func checksum(payload []byte) []byte {
+	first := sha256.Sum256(payload)
+	second := sha256.Sum256(first[:])
+
+	return second[:4]
+}
@@ -0,0 +1,400 @@
+package server
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	coin "github.com/thesoenke/go-coin"
+	"github.com/thesoenke/go-coin/wire"
+)
+
+const restReadTimeout = 30 * time.Second
+
+// restVersion is the version of the raw responses that are not a block or a
+// transaction
+const restVersion = 1
+
+// Formats of REST responses. The format is selected by the extension of the
+// path and defaults to JSON.
+const (
+	restFormatJSON = ".json"
+	restFormatBin  = ".bin"
+	restFormatHex  = ".hex"
+)
+
+// ChainInfo is the response of /chaininfo
+type ChainInfo struct {
+	Network       string `json:"network"`
+	Height        int    `json:"height"`
+	BestBlockHash string `json:"bestblockhash"`
+	Bits          string `json:"bits"`
+	MempoolSize   int    `json:"mempoolsize"`
+	Peers         int    `json:"peers"`
+	TxIndex       bool   `json:"txindex"`
+	AddrIndex     bool   `json:"addrindex"`
+}
+
+// UTXOResult is an unspent output in the response of /address/{addr}/utxos
+type UTXOResult struct {
+	Txid     string `json:"txid"`
+	Vout     int    `json:"vout"`
+	Value    int    `json:"value"`
+	Height   int    `json:"height"`
+	Coinbase bool   `json:"coinbase"`
+
+	// Mature is set if the output may be spent by the next block
+	Mature bool `json:"mature"`
+}
+
+// restError is a failed REST request and the status code of its response
+type restError struct {
+	status  int
+	message string
+}
+
+func (e *restError) Error() string {
+	return e.message
+}
+
+func restErrorf(status int, format string, a ...interface{}) *restError {
+	return &restError{status: status, message: fmt.Sprintf(format, a...)}
+}
+
+// restHandler answers a request with the values of the wildcards of its
+// route. It returns the JSON response and the serialized object for the raw
+// formats. Errors that are not a *restError are internal errors.
+type restHandler func(s *restServer, args []string) (interface{}, []byte, error)
+
+// restRoute maps a path to a handler. A "*" segment of the pattern matches
+// any segment of the path.
+type restRoute struct {
+	pattern []string
+	handler restHandler
+}
+
+// restRoutes are the endpoints of the REST server. The first matching route
+// handles a request.
+var restRoutes = []restRoute{
+	{[]string{"block", "height", "*"}, handleRESTBlockByHeight},
+	{[]string{"block", "*"}, handleRESTBlock},
+	{[]string{"tx", "*"}, handleRESTTransaction},
+	{[]string{"address", "*", "utxos"}, handleRESTUTXOs},
+	{[]string{"address", "*", "balance"}, handleRESTBalance},
+	{[]string{"chaininfo"}, handleRESTChainInfo},
+	{[]string{"mempool"}, handleRESTMempool},
+}
+
+// restServer serves a read-only REST API of the blockchain and the mempool
+// of a node
+type restServer struct {
+	node    *Node
+	address string
+	http    *http.Server
+}
+
+func newRESTServer(node *Node, address string) *restServer {
+	s := &restServer{
+		node:    node,
+		address: address,
+	}
+	s.http = &http.Server{
+		Handler:     s,
+		ReadTimeout: restReadTimeout,
+	}
+
+	return s
+}
+
+func (s *restServer) start() error {
+	return s.node.serveHTTP(s.http, s.address, "REST")
+}
+
+func (s *restServer) stop() {
+	s.http.Close()
+}
+
+// ServeHTTP answers GET requests for the endpoints of restRoutes
+func (s *restServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		writeRESTError(w, restErrorf(http.StatusMethodNotAllowed, "only GET is allowed"))
+		return
+	}
+
+	p := strings.Trim(r.URL.Path, "/")
+	format := path.Ext(p)
+	switch format {
+	case restFormatJSON, restFormatBin, restFormatHex:
+		p = strings.TrimSuffix(p, format)
+	default:
+		format = restFormatJSON
+	}
+
+	handler, args := matchRESTRoute(strings.Split(p, "/"))
+	if handler == nil {
+		writeRESTError(w, restErrorf(http.StatusNotFound, "unknown endpoint /%s", p))
+		return
+	}
+
+	result, raw, err := handler(s, args)
+	if err != nil {
+		writeRESTError(w, err)
+		return
+	}
+
+	switch format {
+	case restFormatBin:
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Write(raw)
+	case restFormatHex:
+		w.Header().Set("Content-Type", "text/plain")
+		fmt.Fprintln(w, hex.EncodeToString(raw))
+	default:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(result)
+	}
+}
+
+// matchRESTRoute returns the handler of the first route that matches the
+// segments of a path and the values of the wildcards
+func matchRESTRoute(segments []string) (restHandler, []string) {
+	for _, route := range restRoutes {
+		if len(route.pattern) != len(segments) {
+			continue
+		}
+
+		var args []string
+		matched := true
+		for i, part := range route.pattern {
+			if part == "*" {
+				args = append(args, segments[i])
+			} else if part != segments[i] {
+				matched = false
+				break
+			}
+		}
+
+		if matched {
+			return route.handler, args
+		}
+	}
+
+	return nil, nil
+}
+
+func writeRESTError(w http.ResponseWriter, err error) {
+	restErr, ok := err.(*restError)
+	if !ok {
+		restErr = restErrorf(http.StatusInternalServerError, "%s", err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(restErr.status)
+	json.NewEncoder(w).Encode(map[string]string{"error": restErr.message})
+}
+
+func (s *restServer) blockResponse(block *coin.Block) (interface{}, []byte, error) {
+	result, err := newBlockResult(s.node.bc, block)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return result, block.Serialize(), nil
+}
+
+func handleRESTBlock(s *restServer, args []string) (interface{}, []byte, error) {
+	hash, err := hex.DecodeString(args[0])
+	if err != nil || len(hash) == 0 {
+		return nil, nil, restErrorf(http.StatusBadRequest, "invalid block hash %s", args[0])
+	}
+
+	block, err := s.node.bc.GetBlock(hash)
+	if err != nil {
+		return nil, nil, restErrorf(http.StatusNotFound, "block %s not found", args[0])
+	}
+
+	return s.blockResponse(&block)
+}
+
+func handleRESTBlockByHeight(s *restServer, args []string) (interface{}, []byte, error) {
+	height, err := strconv.Atoi(args[0])
+	if err != nil {
+		return nil, nil, restErrorf(http.StatusBadRequest, "invalid height %s", args[0])
+	}
+
+	block, err := s.node.bc.GetBlockByHeight(height)
+	if err != nil {
+		return nil, nil, restErrorf(http.StatusNotFound, "%s", err)
+	}
+
+	return s.blockResponse(&block)
+}
+
+// handleRESTTransaction looks up a transaction in the mempool and the main
+// chain. The transaction index is used if it is enabled.
+func handleRESTTransaction(s *restServer, args []string) (interface{}, []byte, error) {
+	id, err := hex.DecodeString(args[0])
+	if err != nil || len(id) == 0 {
+		return nil, nil, restErrorf(http.StatusBadRequest, "invalid transaction ID %s", args[0])
+	}
+
+	tx, inMempool, err := s.node.findTransaction(id)
+	if err != nil {
+		return nil, nil, restErrorf(http.StatusNotFound, "transaction %s not found", args[0])
+	}
+
+	result := newTxResult(tx, s.node.bc.Params())
+	result.InMempool = inMempool
+	return result, tx.Serialize(), nil
+}
+
+func (s *restServer) addressArg(address string) ([]byte, error) {
+	pubKeyHash, err := coin.DecodeAddress(address, s.node.bc.Params())
+	if err != nil {
+		return nil, restErrorf(http.StatusBadRequest, "%s", err)
+	}
+
+	return pubKeyHash, nil
+}
+
+// handleRESTUTXOs returns the unspent outputs of an address. The address
+// index is used if it is enabled. The raw form lists the ID of each
+// transaction with its serialized outputs that are paid to the address.
+func handleRESTUTXOs(s *restServer, args []string) (interface{}, []byte, error) {
+	pubKeyHash, err := s.addressArg(args[0])
+	if err != nil {
+		return nil, nil, err
+	}
+
+	best, err := s.node.bc.GetBestHeight()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	UTXOSet := coin.UTXOSet{Blockchain: s.node.bc}
+	unspent, err := UTXOSet.FindUnspentOutputs(pubKeyHash)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var txids [][]byte
+	outputs := make(map[string]coin.TXOutputs)
+	results := []UTXOResult{}
+	for _, out := range unspent {
+		txid := hex.EncodeToString(out.Txid)
+		outs, ok := outputs[txid]
+		if !ok {
+			outs = coin.TXOutputs{Height: out.Height, Coinbase: out.Coinbase, Outputs: make(map[int]coin.TXOutput)}
+			outputs[txid] = outs
+			txids = append(txids, out.Txid)
+		}
+		outs.Outputs[out.Vout] = out.Output
+
+		results = append(results, UTXOResult{
+			Txid:     hex.EncodeToString(out.Txid),
+			Vout:     out.Vout,
+			Value:    out.Output.Value,
+			Height:   out.Height,
+			Coinbase: out.Coinbase,
+			Mature:   out.IsMature(best+1, s.node.bc.Params()),
+		})
+	}
+
+	raw := &wire.Writer{}
+	raw.WriteUint8(restVersion)
+	raw.WriteUint32(uint32(len(txids)))
+	for _, txid := range txids {
+		raw.WriteVarBytes(txid)
+		raw.WriteVarBytes(outputs[hex.EncodeToString(txid)].Serialize())
+	}
+
+	return results, raw.Bytes(), nil
+}
+
+func handleRESTBalance(s *restServer, args []string) (interface{}, []byte, error) {
+	pubKeyHash, err := s.addressArg(args[0])
+	if err != nil {
+		return nil, nil, err
+	}
+
+	UTXOSet := coin.UTXOSet{Blockchain: s.node.bc}
+	balance, immature, err := UTXOSet.Balance(pubKeyHash)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	raw := &wire.Writer{}
+	raw.WriteUint8(restVersion)
+	raw.WriteInt64(int64(balance))
+	raw.WriteInt64(int64(immature))
+
+	return BalanceResult{Address: args[0], Balance: balance, Immature: immature}, raw.Bytes(), nil
+}
+
+func handleRESTChainInfo(s *restServer, args []string) (interface{}, []byte, error) {
+	bc := s.node.bc
+
+	best, err := bc.GetBestHeight()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	tip, err := bc.GetBlockByHeight(best)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	txIndex, err := bc.HasTxIndex()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	addrIndex, err := bc.HasAddrIndex()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	info := ChainInfo{
+		Network:       bc.Params().Name,
+		Height:        best,
+		BestBlockHash: hex.EncodeToString(tip.Hash),
+		Bits:          fmt.Sprintf("%08x", tip.Bits),
+		MempoolSize:   s.node.MempoolSize(),
+		Peers:         s.node.PeerCount(),
+		TxIndex:       txIndex,
+		AddrIndex:     addrIndex,
+	}
+
+	raw := &wire.Writer{}
+	raw.WriteUint8(restVersion)
+	raw.WriteString(info.Network)
+	raw.WriteInt64(int64(info.Height))
+	raw.WriteVarBytes(tip.Hash)
+	raw.WriteUint32(tip.Bits)
+	raw.WriteUint32(uint32(info.MempoolSize))
+	raw.WriteUint32(uint32(info.Peers))
+	raw.WriteBool(info.TxIndex)
+	raw.WriteBool(info.AddrIndex)
+
+	return info, raw.Bytes(), nil
+}
+
+// handleRESTMempool returns the transactions of the mempool. The raw form
+// lists the serialized transactions.
+func handleRESTMempool(s *restServer, args []string) (interface{}, []byte, error) {
+	descs := s.node.MempoolTxDescs()
+
+	raw := &wire.Writer{}
+	raw.WriteUint8(restVersion)
+	raw.WriteUint32(uint32(len(descs)))
+	for _, desc := range descs {
+		raw.WriteVarBytes(desc.Tx.Serialize())
+	}
+
+	return newMempoolEntries(descs), raw.Bytes(), nil
+}
@@ -0,0 +1,139 @@
+package server
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	coin "github.com/thesoenke/go-coin"
+	"github.com/thesoenke/go-coin/wire"
+)
+
+// restGet requests the path from the REST server at localhost:23050 and
+// returns the status code and the body
+func restGet(t *testing.T, path string) (int, []byte) {
+	resp, err := http.Get("http://localhost:23050" + path)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+
+	return resp.StatusCode, body
+}
+
+func TestREST(t *testing.T) {
+	wallet, err := coin.NewWallet()
+	require.NoError(t, err)
+	address := string(wallet.GetAddress(testParams))
+
+	chains, _ := openChains(t, address, 23051)
+	node := NewNode(chains[0], Config{Address: "localhost:23051", RESTAddress: "localhost:23050"})
+	require.NoError(t, node.Start())
+	defer node.Stop()
+
+	mined, err := chains[0].MineBlock([]*coin.Transaction{coin.NewCoinbaseTX(address, "", 10)})
+	require.NoError(t, err)
+	hash := hex.EncodeToString(mined.Hash)
+
+	status, body := restGet(t, "/block/"+hash)
+	require.Equal(t, http.StatusOK, status)
+	var block BlockResult
+	require.NoError(t, json.Unmarshal(body, &block))
+	assert.Equal(t, hash, block.Hash)
+	assert.Equal(t, 1, block.Height)
+	assert.Equal(t, 1, block.Confirmations)
+
+	status, body = restGet(t, "/block/height/1.bin")
+	require.Equal(t, http.StatusOK, status)
+	assert.Equal(t, mined.Serialize(), body)
+
+	status, body = restGet(t, "/block/height/1.hex")
+	require.Equal(t, http.StatusOK, status)
+	assert.Equal(t, hex.EncodeToString(mined.Serialize()), strings.TrimSpace(string(body)))
+
+	txid := hex.EncodeToString(mined.Transactions[0].ID)
+	status, body = restGet(t, "/tx/"+txid+".json")
+	require.Equal(t, http.StatusOK, status)
+	var tx TxResult
+	require.NoError(t, json.Unmarshal(body, &tx))
+	assert.Equal(t, txid, tx.Txid)
+	assert.Equal(t, address, tx.Vout[0].Address)
+
+	status, body = restGet(t, "/address/"+address+"/utxos")
+	require.Equal(t, http.StatusOK, status)
+	var utxos []UTXOResult
+	require.NoError(t, json.Unmarshal(body, &utxos))
+	require.Len(t, utxos, 2)
+	for _, utxo := range utxos {
+		assert.True(t, utxo.Coinbase)
+		assert.False(t, utxo.Mature)
+	}
+
+	status, body = restGet(t, "/address/"+address+"/utxos.bin")
+	require.Equal(t, http.StatusOK, status)
+	r := wire.NewReader(body)
+	r.ReadVersion(1)
+	require.Equal(t, 2, r.ReadCount())
+	for i := 0; i < 2; i++ {
+		txid := r.ReadVarBytes()
+		outputs := coin.DeserializeOutputs(r.ReadVarBytes())
+		assert.Contains(t, []string{utxos[0].Txid, utxos[1].Txid}, hex.EncodeToString(txid))
+		assert.True(t, outputs.Coinbase)
+		assert.Equal(t, 10, outputs.Outputs[0].Value)
+	}
+	require.NoError(t, r.Finish())
+
+	status, body = restGet(t, "/address/"+address+"/balance")
+	require.Equal(t, http.StatusOK, status)
+	var balance BalanceResult
+	require.NoError(t, json.Unmarshal(body, &balance))
+	assert.Equal(t, BalanceResult{Address: address, Balance: 0, Immature: 20}, balance)
+
+	status, body = restGet(t, "/address/"+address+"/balance.hex")
+	require.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "01"+"0000000000000000"+"0000000000000014", strings.TrimSpace(string(body)))
+
+	status, body = restGet(t, "/chaininfo")
+	require.Equal(t, http.StatusOK, status)
+	var info ChainInfo
+	require.NoError(t, json.Unmarshal(body, &info))
+	assert.Equal(t, "regtest", info.Network)
+	assert.Equal(t, 1, info.Height)
+	assert.Equal(t, hash, info.BestBlockHash)
+
+	status, body = restGet(t, "/chaininfo.bin")
+	require.Equal(t, http.StatusOK, status)
+	r = wire.NewReader(body)
+	r.ReadVersion(1)
+	assert.Equal(t, "regtest", r.ReadString())
+	assert.Equal(t, int64(1), r.ReadInt64())
+	assert.Equal(t, mined.Hash, r.ReadVarBytes())
+
+	status, body = restGet(t, "/mempool")
+	require.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "[]\n", string(body))
+
+	status, body = restGet(t, "/mempool.hex")
+	require.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "01"+"00000000", strings.TrimSpace(string(body)))
+
+	status, _ = restGet(t, "/block/height/2")
+	assert.Equal(t, http.StatusNotFound, status)
+	status, _ = restGet(t, "/block/xyz")
+	assert.Equal(t, http.StatusBadRequest, status)
+	status, _ = restGet(t, "/address/"+string(wallet.GetAddress(&coin.MainNetParams))+"/balance")
+	assert.Equal(t, http.StatusBadRequest, status)
+	status, _ = restGet(t, "/blocks")
+	assert.Equal(t, http.StatusNotFound, status)
+
+	resp, err := http.Post("http://localhost:23050/chaininfo", "application/json", nil)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+}
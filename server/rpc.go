@@ -0,0 +1,282 @@
+package server
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// maxRPCRequestSize limits the body of a request. It leaves room for a
+	// hex encoded block in a batch.
+	maxRPCRequestSize = 8 << 20
+
+	rpcReadTimeout = 30 * time.Second
+)
+
+// Error codes of JSON-RPC responses. The codes below -32000 are defined by
+// the JSON-RPC 2.0 specification.
+const (
+	RPCErrParse          = -32700
+	RPCErrInvalidRequest = -32600
+	RPCErrMethodNotFound = -32601
+	RPCErrInvalidParams  = -32602
+	RPCErrInternal       = -32603
+
+	// RPCErrWallet is returned if a wallet operation fails
+	RPCErrWallet = -4
+
+	// RPCErrNotFound is returned if a block, transaction or address is not
+	// known
+	RPCErrNotFound = -5
+
+	// RPCErrRejected is returned if a transaction is not accepted to the
+	// mempool
+	RPCErrRejected = -26
+)
+
+// RPCConfig configures the JSON-RPC server of a node
+type RPCConfig struct {
+	// Address is the address the server listens on. The server is disabled
+	// if it is empty.
+	Address string
+
+	// User and Password are required from clients with HTTP basic
+	// authentication. Both must be set to start the server.
+	User     string
+	Password string
+
+	// WalletDir and WalletID select the wallet file of the wallet methods
+	WalletDir string
+	WalletID  int
+}
+
+// RPCError is the error object of a JSON-RPC response
+type RPCError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *RPCError) Error() string {
+	return fmt.Sprintf("%s (code %d)", e.Message, e.Code)
+}
+
+func rpcError(code int, format string, a ...interface{}) *RPCError {
+	return &RPCError{Code: code, Message: fmt.Sprintf(format, a...)}
+}
+
+type rpcRequest struct {
+	JSONRPC string          `json:"jsonrpc"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params"`
+
+	// ID is nil for notifications, which are not answered
+	ID json.RawMessage `json:"id"`
+}
+
+type rpcResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *RPCError       `json:"error,omitempty"`
+	ID      json.RawMessage `json:"id"`
+}
+
+// rpcHandler runs a method with its params and returns the result. Errors
+// that are not an *RPCError are reported as internal errors.
+type rpcHandler func(s *rpcServer, params json.RawMessage) (interface{}, error)
+
+// rpcServer serves the JSON-RPC methods of a node over HTTP
+type rpcServer struct {
+	node     *Node
+	cfg      RPCConfig
+	authHash [sha256.Size]byte
+	http     *http.Server
+
+	// walletMu serializes the methods that read and write the wallet file
+	walletMu sync.Mutex
+
+	// sendMu serializes the selection and submission of outputs by send
+	sendMu sync.Mutex
+}
+
+func newRPCServer(node *Node, cfg RPCConfig) *rpcServer {
+	s := &rpcServer{
+		node:     node,
+		cfg:      cfg,
+		authHash: sha256.Sum256([]byte(cfg.User + ":" + cfg.Password)),
+	}
+	s.http = &http.Server{
+		Handler:     s,
+		ReadTimeout: rpcReadTimeout,
+	}
+
+	return s
+}
+
+// start listens on the address of the server and serves requests in the
+// background until it is stopped
+func (s *rpcServer) start() error {
+	if s.cfg.User == "" || s.cfg.Password == "" {
+		return fmt.Errorf("the RPC server requires a user and a password")
+	}
+
+	return s.node.serveHTTP(s.http, s.cfg.Address, "RPC")
+}
+
+func (s *rpcServer) stop() {
+	s.http.Close()
+}
+
+// authenticated checks the credentials of a request. The hashes are compared
+// in constant time, so the time does not reveal how much of them matched.
+func (s *rpcServer) authenticated(r *http.Request) bool {
+	user, password, ok := r.BasicAuth()
+	if !ok {
+		return false
+	}
+
+	hash := sha256.Sum256([]byte(user + ":" + password))
+	return subtle.ConstantTimeCompare(hash[:], s.authHash[:]) == 1
+}
+
+// ServeHTTP answers a JSON-RPC request or a batch of requests
+func (s *rpcServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !s.authenticated(r) {
+		w.Header().Set("WWW-Authenticate", `Basic realm="coin"`)
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "only POST is allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxRPCRequestSize))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	var reply interface{}
+	body = bytes.TrimSpace(body)
+	if len(body) > 0 && body[0] == '[' {
+		var batch []json.RawMessage
+		err = json.Unmarshal(body, &batch)
+		if err != nil {
+			reply = errorResponse(nil, rpcError(RPCErrParse, "parse error: %s", err))
+		} else if len(batch) == 0 {
+			reply = errorResponse(nil, rpcError(RPCErrInvalidRequest, "empty batch"))
+		} else {
+			var responses []*rpcResponse
+			for _, data := range batch {
+				resp := s.handleRequest(data)
+				if resp != nil {
+					responses = append(responses, resp)
+				}
+			}
+			if len(responses) > 0 {
+				reply = responses
+			}
+		}
+	} else {
+		resp := s.handleRequest(body)
+		if resp != nil {
+			reply = resp
+		}
+	}
+
+	// Only notifications were sent
+	if reply == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(reply)
+}
+
+// handleRequest runs a single request. It returns nil for notifications.
+func (s *rpcServer) handleRequest(data []byte) *rpcResponse {
+	var req rpcRequest
+	err := json.Unmarshal(data, &req)
+	if err != nil {
+		if _, ok := err.(*json.SyntaxError); ok {
+			return errorResponse(nil, rpcError(RPCErrParse, "parse error: %s", err))
+		}
+		return errorResponse(nil, rpcError(RPCErrInvalidRequest, "invalid request: %s", err))
+	}
+
+	if req.JSONRPC != "2.0" || req.Method == "" {
+		return errorResponse(req.ID, rpcError(RPCErrInvalidRequest, "invalid request"))
+	}
+
+	result, rpcErr := s.call(req.Method, req.Params)
+	if req.ID == nil {
+		return nil
+	}
+	if rpcErr != nil {
+		return errorResponse(req.ID, rpcErr)
+	}
+
+	data, err = json.Marshal(result)
+	if err != nil {
+		return errorResponse(req.ID, rpcError(RPCErrInternal, "%s", err))
+	}
+
+	return &rpcResponse{JSONRPC: "2.0", Result: data, ID: req.ID}
+}
+
+func (s *rpcServer) call(method string, params json.RawMessage) (interface{}, *RPCError) {
+	handler, ok := rpcHandlers[method]
+	if !ok {
+		return nil, rpcError(RPCErrMethodNotFound, "method '%s' not found", method)
+	}
+
+	result, err := handler(s, params)
+	if rpcErr, ok := err.(*RPCError); ok {
+		return nil, rpcErr
+	}
+	if err != nil {
+		return nil, rpcError(RPCErrInternal, "%s", err)
+	}
+
+	return result, nil
+}
+
+func errorResponse(id json.RawMessage, err *RPCError) *rpcResponse {
+	// The ID is null if it could not be read from the request
+	if id == nil {
+		id = json.RawMessage("null")
+	}
+
+	return &rpcResponse{JSONRPC: "2.0", Error: err, ID: id}
+}
+
+// parseParams decodes the params of a request into v. Params are passed by
+// name, so they have to be an object. Unknown names are an error, which
+// catches misspelled params.
+func parseParams(params json.RawMessage, v interface{}) error {
+	params = bytes.TrimSpace(params)
+	if len(params) == 0 || bytes.Equal(params, []byte("null")) {
+		return nil
+	}
+	if params[0] != '{' {
+		return rpcError(RPCErrInvalidParams, "params must be an object")
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(params))
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(v)
+	if err != nil {
+		return rpcError(RPCErrInvalidParams, "invalid params: %s", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,241 @@
+package server
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	coin "github.com/thesoenke/go-coin"
+)
+
+// startRPCNode starts a node with the RPC server at address on a new chain
+// that pays the genesis reward to the wallet
+func startRPCNode(t *testing.T, address string, wallet *coin.Wallet) (*Node, *RPCClient) {
+	chains, dir := openChains(t, string(wallet.GetAddress(testParams)), 23030)
+
+	node := NewNode(chains[0], Config{
+		Address: "localhost:23030",
+		RPC: RPCConfig{
+			Address:   address,
+			User:      "user",
+			Password:  "secret",
+			WalletDir: dir,
+			WalletID:  23030,
+		},
+	})
+	require.NoError(t, node.Start())
+	t.Cleanup(node.Stop)
+
+	return node, NewRPCClient(address, "user", "secret")
+}
+
+func TestRPCRequiresAuthentication(t *testing.T) {
+	wallet, err := coin.NewWallet()
+	require.NoError(t, err)
+	startRPCNode(t, "localhost:23031", wallet)
+
+	_, err = NewRPCClient("localhost:23031", "user", "wrong").BestHeight()
+	assert.EqualError(t, err, "RPC server returned 401 Unauthorized")
+
+	resp, err := http.Post("http://localhost:23031", "application/json", strings.NewReader(`{"jsonrpc":"2.0","method":"getbestheight","id":1}`))
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	// The server does not start without credentials
+	assert.Error(t, newRPCServer(nil, RPCConfig{Address: "localhost:23032"}).start())
+}
+
+func TestRPCChainQueries(t *testing.T) {
+	wallet, err := coin.NewWallet()
+	require.NoError(t, err)
+	node, client := startRPCNode(t, "localhost:23033", wallet)
+
+	mined, err := node.Blockchain().MineBlock([]*coin.Transaction{coin.NewCoinbaseTX(string(wallet.GetAddress(testParams)), "", 10)})
+	require.NoError(t, err)
+
+	height, err := client.BestHeight()
+	require.NoError(t, err)
+	assert.Equal(t, 1, height)
+
+	genesis, err := client.BlockByHeight(0)
+	require.NoError(t, err)
+	assert.Equal(t, 0, genesis.Height)
+	assert.Equal(t, 2, genesis.Confirmations)
+	require.Len(t, genesis.Transactions, 1)
+	assert.Equal(t, string(wallet.GetAddress(testParams)), genesis.Transactions[0].Vout[0].Address)
+
+	block, err := client.Block(mined.Hash)
+	require.NoError(t, err)
+	assert.Equal(t, hex.EncodeToString(mined.Hash), block.Hash)
+	assert.Equal(t, genesis.Hash, block.PrevBlockHash)
+
+	var raw string
+	require.NoError(t, client.Call("getblock", map[string]interface{}{"height": 1, "raw": true}, &raw))
+	assert.Equal(t, hex.EncodeToString(mined.Serialize()), raw)
+
+	tx, err := client.Transaction(mined.Transactions[0].ID)
+	require.NoError(t, err)
+	assert.Equal(t, hex.EncodeToString(mined.Transactions[0].ID), tx.Txid)
+	assert.False(t, tx.InMempool)
+
+	_, err = client.BlockByHeight(2)
+	require.IsType(t, &RPCError{}, err)
+	assert.Equal(t, RPCErrNotFound, err.(*RPCError).Code)
+
+	err = client.Call("getblock", []int{1}, nil)
+	require.IsType(t, &RPCError{}, err)
+	assert.Equal(t, RPCErrInvalidParams, err.(*RPCError).Code)
+
+	err = client.Call("getblock", map[string]interface{}{"heigth": 1}, nil)
+	require.IsType(t, &RPCError{}, err)
+	assert.Equal(t, RPCErrInvalidParams, err.(*RPCError).Code)
+
+	err = client.Call("stop", nil, nil)
+	require.IsType(t, &RPCError{}, err)
+	assert.Equal(t, RPCErrMethodNotFound, err.(*RPCError).Code)
+
+	peers, err := client.Peers()
+	require.NoError(t, err)
+	assert.Empty(t, peers)
+}
+
+func TestRPCSendRawTransaction(t *testing.T) {
+	wallet, err := coin.NewWallet()
+	require.NoError(t, err)
+	node, client := startRPCNode(t, "localhost:23034", wallet)
+
+	// Mine until the genesis reward is mature
+	address := string(wallet.GetAddress(testParams))
+	for i := 0; i < testParams.CoinbaseMaturity; i++ {
+		_, err := node.Blockchain().MineBlock([]*coin.Transaction{coin.NewCoinbaseTX(address, "", 10)})
+		require.NoError(t, err)
+	}
+
+	receiver, err := coin.NewWallet()
+	require.NoError(t, err)
+	UTXOSet := coin.UTXOSet{Blockchain: node.Blockchain()}
+	tx, err := coin.NewUTXOTransaction(wallet, string(receiver.GetAddress(testParams)), 3, 1, &UTXOSet)
+	require.NoError(t, err)
+
+	require.NoError(t, client.SendRawTransaction(tx))
+
+	err = client.SendRawTransaction(tx)
+	require.IsType(t, &RPCError{}, err)
+	assert.Equal(t, RPCErrRejected, err.(*RPCError).Code)
+
+	entries, err := client.Mempool()
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, hex.EncodeToString(tx.ID), entries[0].Txid)
+	assert.Equal(t, 1, entries[0].Fee)
+
+	result, err := client.Transaction(tx.ID)
+	require.NoError(t, err)
+	assert.True(t, result.InMempool)
+
+	balance, err := client.Balance(address)
+	require.NoError(t, err)
+	// The outputs of the genesis block and the first block may be spent by
+	// the next block
+	assert.Equal(t, 20, balance.Balance)
+	assert.Equal(t, 990, balance.Immature)
+}
+
+func TestRPCBatch(t *testing.T) {
+	wallet, err := coin.NewWallet()
+	require.NoError(t, err)
+	startRPCNode(t, "localhost:23035", wallet)
+
+	post := func(body string) (int, []byte) {
+		req, err := http.NewRequest(http.MethodPost, "http://localhost:23035", strings.NewReader(body))
+		require.NoError(t, err)
+		req.SetBasicAuth("user", "secret")
+
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		data, err := ioutil.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return resp.StatusCode, data
+	}
+
+	status, data := post(`[
+		{"jsonrpc": "2.0", "method": "getbestheight", "id": 1},
+		{"jsonrpc": "2.0", "method": "getbestheight"},
+		{"jsonrpc": "2.0", "method": "nope", "id": "a"},
+		{"method": "getbestheight", "id": 3}
+	]`)
+	require.Equal(t, http.StatusOK, status)
+
+	var responses []rpcResponse
+	require.NoError(t, json.Unmarshal(data, &responses))
+	require.Len(t, responses, 3)
+	assert.Equal(t, "0", string(responses[0].Result))
+	assert.Equal(t, `"a"`, string(responses[1].ID))
+	assert.Equal(t, RPCErrMethodNotFound, responses[1].Error.Code)
+	assert.Equal(t, RPCErrInvalidRequest, responses[2].Error.Code)
+
+	// Notifications are not answered
+	status, _ = post(`{"jsonrpc": "2.0", "method": "getbestheight"}`)
+	assert.Equal(t, http.StatusNoContent, status)
+
+	status, data = post(`{"jsonrpc": "2.0", "method"`)
+	require.Equal(t, http.StatusOK, status)
+	var response rpcResponse
+	require.NoError(t, json.Unmarshal(data, &response))
+	assert.Equal(t, RPCErrParse, response.Error.Code)
+	assert.Equal(t, "null", string(response.ID))
+}
+
+func TestSpendableOutputsSkipMempoolSpends(t *testing.T) {
+	wallet, err := coin.NewWallet()
+	require.NoError(t, err)
+	node, _ := startRPCNode(t, "localhost:23036", wallet)
+
+	receiver, err := coin.NewWallet()
+	require.NoError(t, err)
+	from := string(wallet.GetAddress(testParams))
+	to := string(receiver.GetAddress(testParams))
+	pubKeyHash := coin.HashPubKey(wallet.PublicKey)
+
+	// Only the genesis reward is mature in the next block
+	for i := 0; i < testParams.CoinbaseMaturity-1; i++ {
+		_, err := node.Blockchain().MineBlock([]*coin.Transaction{coin.NewCoinbaseTX(from, "", 10)})
+		require.NoError(t, err)
+	}
+
+	send := func(amount, fee int) (*coin.Transaction, error) {
+		spendable, err := node.spendableOutputs(pubKeyHash)
+		require.NoError(t, err)
+
+		tx, err := coin.NewTransactionFromOutputs(wallet, to, amount, fee, spendable, testParams)
+		if err != nil {
+			return nil, err
+		}
+
+		return tx, node.SubmitTransaction(tx)
+	}
+
+	first, err := send(3, 1)
+	require.NoError(t, err)
+
+	// The genesis reward is spent by the mempool, so the second transaction
+	// spends the change of the first
+	second, err := send(4, 1)
+	require.NoError(t, err)
+	require.Len(t, second.Vin, 1)
+	assert.Equal(t, first.ID, second.Vin[0].Txid)
+	assert.Equal(t, 1, second.Vin[0].Vout)
+
+	_, err = send(1, 1)
+	assert.EqualError(t, err, fmt.Sprintf("not enough funds in '%s'", from))
+	assert.Equal(t, 2, node.MempoolSize())
+}
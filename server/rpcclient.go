@@ -0,0 +1,174 @@
+package server
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	coin "github.com/thesoenke/go-coin"
+)
+
+const rpcClientTimeout = time.Minute
+
+// RPCClient calls the JSON-RPC methods of a running node
+type RPCClient struct {
+	url      string
+	user     string
+	password string
+	http     *http.Client
+	nextID   uint64
+}
+
+// NewRPCClient returns a client for the RPC server at address that
+// authenticates with user and password
+func NewRPCClient(address, user, password string) *RPCClient {
+	return &RPCClient{
+		url:      "http://" + address,
+		user:     user,
+		password: password,
+		http:     &http.Client{Timeout: rpcClientTimeout},
+	}
+}
+
+// Call runs a method with named params and decodes its result into result.
+// Errors of the method are returned as *RPCError.
+func (c *RPCClient) Call(method string, params interface{}, result interface{}) error {
+	rawParams, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+
+	id := atomic.AddUint64(&c.nextID, 1)
+	body, err := json.Marshal(&rpcRequest{
+		JSONRPC: "2.0",
+		Method:  method,
+		Params:  rawParams,
+		ID:      json.RawMessage(fmt.Sprint(id)),
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, c.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth(c.user, c.password)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("RPC server returned %s", resp.Status)
+	}
+
+	var response rpcResponse
+	err = json.Unmarshal(data, &response)
+	if err != nil {
+		return fmt.Errorf("malformed RPC response: %s", err)
+	}
+	if response.Error != nil {
+		return response.Error
+	}
+	if result == nil {
+		return nil
+	}
+
+	return json.Unmarshal(response.Result, result)
+}
+
+// BestHeight returns the height of the tip of the main chain
+func (c *RPCClient) BestHeight() (int, error) {
+	var height int
+	err := c.Call("getbestheight", nil, &height)
+	return height, err
+}
+
+// Block returns a block by its hash
+func (c *RPCClient) Block(hash []byte) (*BlockResult, error) {
+	var block BlockResult
+	err := c.Call("getblock", map[string]interface{}{"hash": hex.EncodeToString(hash)}, &block)
+	return &block, err
+}
+
+// BlockByHeight returns the block of the main chain at the height
+func (c *RPCClient) BlockByHeight(height int) (*BlockResult, error) {
+	var block BlockResult
+	err := c.Call("getblock", map[string]interface{}{"height": height}, &block)
+	return &block, err
+}
+
+// Transaction returns a transaction of the mempool or the main chain
+func (c *RPCClient) Transaction(id []byte) (*TxResult, error) {
+	var tx TxResult
+	err := c.Call("gettransaction", map[string]interface{}{"txid": hex.EncodeToString(id)}, &tx)
+	return &tx, err
+}
+
+// Mempool returns the transactions waiting to be mined
+func (c *RPCClient) Mempool() ([]MempoolEntry, error) {
+	var entries []MempoolEntry
+	err := c.Call("getmempool", nil, &entries)
+	return entries, err
+}
+
+// Peers returns the peers of the node
+func (c *RPCClient) Peers() ([]PeerInfo, error) {
+	var peers []PeerInfo
+	err := c.Call("getpeers", nil, &peers)
+	return peers, err
+}
+
+// SendRawTransaction submits a signed transaction to the node
+func (c *RPCClient) SendRawTransaction(tx *coin.Transaction) error {
+	return c.Call("sendrawtransaction", map[string]interface{}{"hex": hex.EncodeToString(tx.Serialize())}, nil)
+}
+
+// NewAddress creates a wallet on the node and returns its address
+func (c *RPCClient) NewAddress() (string, error) {
+	var address string
+	err := c.Call("getnewaddress", nil, &address)
+	return address, err
+}
+
+// ListAddresses returns the addresses of the wallets of the node
+func (c *RPCClient) ListAddresses() ([]string, error) {
+	var addresses []string
+	err := c.Call("listaddresses", nil, &addresses)
+	return addresses, err
+}
+
+// Balance returns the balance of an address
+func (c *RPCClient) Balance(address string) (*BalanceResult, error) {
+	var balance BalanceResult
+	err := c.Call("getbalance", map[string]interface{}{"address": address}, &balance)
+	return &balance, err
+}
+
+// AddressHistory returns the outputs of the main chain paid to an address
+func (c *RPCClient) AddressHistory(address string) ([]HistoryEntry, error) {
+	var history []HistoryEntry
+	err := c.Call("getaddresshistory", map[string]interface{}{"address": address}, &history)
+	return history, err
+}
+
+// Send creates a transaction with a wallet of the node and submits it. It
+// returns the ID of the transaction.
+func (c *RPCClient) Send(from, to string, amount, fee int) (string, error) {
+	var txid string
+	err := c.Call("send", map[string]interface{}{"from": from, "to": to, "amount": amount, "fee": fee}, &txid)
+	return txid, err
+}
@@ -0,0 +1,542 @@
+package server
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+
+	coin "github.com/thesoenke/go-coin"
+	"github.com/thesoenke/go-coin/mempool"
+)
+
+// rpcHandlers are the methods of the JSON-RPC server by name
+var rpcHandlers = map[string]rpcHandler{
+	"getbestheight":      handleGetBestHeight,
+	"getblock":           handleGetBlock,
+	"getblockhash":       handleGetBlockHash,
+	"gettransaction":     handleGetTransaction,
+	"getmempool":         handleGetMempool,
+	"getpeers":           handleGetPeers,
+	"sendrawtransaction": handleSendRawTransaction,
+	"getnewaddress":      handleGetNewAddress,
+	"listaddresses":      handleListAddresses,
+	"getbalance":         handleGetBalance,
+	"getaddresshistory":  handleGetAddressHistory,
+	"send":               handleSend,
+}
+
+// BlockResult is a block in the results of the JSON-RPC methods
+type BlockResult struct {
+	Hash          string `json:"hash"`
+	PrevBlockHash string `json:"previousblockhash"`
+	Height        int    `json:"height"`
+
+	// Confirmations is the number of blocks of the main chain from the
+	// block to the tip. It is 0 for blocks that are not in the main chain.
+	Confirmations int `json:"confirmations"`
+
+	Version      uint32     `json:"version"`
+	MerkleRoot   string     `json:"merkleroot"`
+	Time         int64      `json:"time"`
+	Bits         string     `json:"bits"`
+	Nonce        uint32     `json:"nonce"`
+	Size         int        `json:"size"`
+	Transactions []TxResult `json:"tx"`
+}
+
+// TxResult is a transaction in the results of the JSON-RPC methods
+type TxResult struct {
+	Txid string        `json:"txid"`
+	Size int           `json:"size"`
+	Vin  []TxInResult  `json:"vin"`
+	Vout []TxOutResult `json:"vout"`
+
+	// InMempool is set if the transaction is waiting to be mined. It is only
+	// set by gettransaction.
+	InMempool bool `json:"inmempool,omitempty"`
+}
+
+// TxInResult is an input of a TxResult. The txid of a coinbase input is
+// empty.
+type TxInResult struct {
+	Txid      string `json:"txid"`
+	Vout      int    `json:"vout"`
+	Signature string `json:"signature"`
+	PubKey    string `json:"pubkey"`
+}
+
+// TxOutResult is an output of a TxResult
+type TxOutResult struct {
+	Value      int    `json:"value"`
+	PubKeyHash string `json:"pubkeyhash"`
+	Address    string `json:"address"`
+}
+
+// MempoolEntry is a transaction in the result of getmempool
+type MempoolEntry struct {
+	Txid string `json:"txid"`
+	Fee  int    `json:"fee"`
+	Size int    `json:"size"`
+
+	// Time is the Unix time the transaction was added
+	Time int64 `json:"time"`
+}
+
+// BalanceResult is the result of getbalance
+type BalanceResult struct {
+	Address string `json:"address"`
+	Balance int    `json:"balance"`
+
+	// Immature is the value of the coinbase outputs that are not mature yet
+	Immature int `json:"immature"`
+}
+
+// HistoryEntry is an output in the result of getaddresshistory
+type HistoryEntry struct {
+	Txid   string `json:"txid"`
+	Vout   int    `json:"vout"`
+	Value  int    `json:"value"`
+	Height int    `json:"height"`
+
+	// SpentBy is the ID of the transaction that spends the output. It is
+	// empty if the output is unspent.
+	SpentBy string `json:"spentby,omitempty"`
+}
+
+func newTxResult(tx *coin.Transaction, params *coin.ChainParams) TxResult {
+	result := TxResult{
+		Txid: hex.EncodeToString(tx.ID),
+		Size: len(tx.Serialize()),
+		Vin:  []TxInResult{},
+		Vout: []TxOutResult{},
+	}
+
+	for _, in := range tx.Vin {
+		result.Vin = append(result.Vin, TxInResult{
+			Txid:      hex.EncodeToString(in.Txid),
+			Vout:      in.Vout,
+			Signature: hex.EncodeToString(in.Signature),
+			PubKey:    hex.EncodeToString(in.PubKey),
+		})
+	}
+
+	for _, out := range tx.Vout {
+		result.Vout = append(result.Vout, TxOutResult{
+			Value:      out.Value,
+			PubKeyHash: hex.EncodeToString(out.PubKeyHash),
+			Address:    coin.EncodeAddress(out.PubKeyHash, params),
+		})
+	}
+
+	return result
+}
+
+// newBlockResult describes a block of the blockchain
+func newBlockResult(bc *coin.Blockchain, block *coin.Block) (*BlockResult, error) {
+	result := &BlockResult{
+		Hash:          hex.EncodeToString(block.Hash),
+		PrevBlockHash: hex.EncodeToString(block.PrevBlockHash),
+		Height:        block.Height,
+		Version:       block.Version,
+		MerkleRoot:    hex.EncodeToString(block.MerkleRoot),
+		Time:          block.Timestamp,
+		Bits:          fmt.Sprintf("%08x", block.Bits),
+		Nonce:         block.Nonce,
+		Size:          len(block.Serialize()),
+		Transactions:  []TxResult{},
+	}
+
+	for _, tx := range block.Transactions {
+		result.Transactions = append(result.Transactions, newTxResult(tx, bc.Params()))
+	}
+
+	best, err := bc.GetBestHeight()
+	if err != nil {
+		return nil, err
+	}
+
+	// The block is in the main chain if the height index points to it
+	hash, err := bc.GetBlockHash(block.Height)
+	if err == nil && bytes.Equal(hash, block.Hash) {
+		result.Confirmations = best - block.Height + 1
+	}
+
+	return result, nil
+}
+
+// decodeHash decodes a hex encoded block hash or transaction ID of the params
+func decodeHash(name, value string) ([]byte, error) {
+	hash, err := hex.DecodeString(value)
+	if err != nil || len(hash) == 0 {
+		return nil, rpcError(RPCErrInvalidParams, "%s is not a valid hex string", name)
+	}
+
+	return hash, nil
+}
+
+func handleGetBestHeight(s *rpcServer, params json.RawMessage) (interface{}, error) {
+	err := parseParams(params, &struct{}{})
+	if err != nil {
+		return nil, err
+	}
+
+	return s.node.bc.GetBestHeight()
+}
+
+func handleGetBlockHash(s *rpcServer, params json.RawMessage) (interface{}, error) {
+	var p struct {
+		Height int `json:"height"`
+	}
+	err := parseParams(params, &p)
+	if err != nil {
+		return nil, err
+	}
+
+	hash, err := s.node.bc.GetBlockHash(p.Height)
+	if err != nil {
+		return nil, rpcError(RPCErrNotFound, "%s", err)
+	}
+
+	return hex.EncodeToString(hash), nil
+}
+
+// handleGetBlock returns a block by hash or by height in the main chain. The
+// serialized block is returned as hex string if raw is set.
+func handleGetBlock(s *rpcServer, params json.RawMessage) (interface{}, error) {
+	var p struct {
+		Hash   string `json:"hash"`
+		Height *int   `json:"height"`
+		Raw    bool   `json:"raw"`
+	}
+	err := parseParams(params, &p)
+	if err != nil {
+		return nil, err
+	}
+	if (p.Hash == "") == (p.Height == nil) {
+		return nil, rpcError(RPCErrInvalidParams, "either hash or height is required")
+	}
+
+	var block coin.Block
+	if p.Hash != "" {
+		hash, err := decodeHash("hash", p.Hash)
+		if err != nil {
+			return nil, err
+		}
+
+		block, err = s.node.bc.GetBlock(hash)
+		if err != nil {
+			return nil, rpcError(RPCErrNotFound, "block %s not found", p.Hash)
+		}
+	} else {
+		block, err = s.node.bc.GetBlockByHeight(*p.Height)
+		if err != nil {
+			return nil, rpcError(RPCErrNotFound, "%s", err)
+		}
+	}
+
+	if p.Raw {
+		return hex.EncodeToString(block.Serialize()), nil
+	}
+
+	return newBlockResult(s.node.bc, &block)
+}
+
+// handleGetTransaction returns a transaction of the mempool or the main
+// chain. The serialized transaction is returned as hex string if raw is set.
+func handleGetTransaction(s *rpcServer, params json.RawMessage) (interface{}, error) {
+	var p struct {
+		Txid string `json:"txid"`
+		Raw  bool   `json:"raw"`
+	}
+	err := parseParams(params, &p)
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := decodeHash("txid", p.Txid)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, inMempool, err := s.node.findTransaction(id)
+	if err != nil {
+		return nil, rpcError(RPCErrNotFound, "transaction %s not found", p.Txid)
+	}
+
+	if p.Raw {
+		return hex.EncodeToString(tx.Serialize()), nil
+	}
+
+	result := newTxResult(tx, s.node.bc.Params())
+	result.InMempool = inMempool
+	return result, nil
+}
+
+func handleGetMempool(s *rpcServer, params json.RawMessage) (interface{}, error) {
+	err := parseParams(params, &struct{}{})
+	if err != nil {
+		return nil, err
+	}
+
+	return newMempoolEntries(s.node.MempoolTxDescs()), nil
+}
+
+func newMempoolEntries(descs []*mempool.TxDesc) []MempoolEntry {
+	entries := []MempoolEntry{}
+	for _, desc := range descs {
+		entries = append(entries, MempoolEntry{
+			Txid: hex.EncodeToString(desc.Tx.ID),
+			Fee:  desc.Fee,
+			Size: desc.Size,
+			Time: desc.Added.Unix(),
+		})
+	}
+
+	return entries
+}
+
+func handleGetPeers(s *rpcServer, params json.RawMessage) (interface{}, error) {
+	err := parseParams(params, &struct{}{})
+	if err != nil {
+		return nil, err
+	}
+
+	peers := s.node.Peers()
+	if peers == nil {
+		peers = []PeerInfo{}
+	}
+
+	return peers, nil
+}
+
+// handleSendRawTransaction adds a hex encoded serialized transaction to the
+// mempool and relays it. It returns the ID of the transaction.
+func handleSendRawTransaction(s *rpcServer, params json.RawMessage) (interface{}, error) {
+	var p struct {
+		Hex string `json:"hex"`
+	}
+	err := parseParams(params, &p)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := hex.DecodeString(p.Hex)
+	if err != nil {
+		return nil, rpcError(RPCErrInvalidParams, "hex is not a valid hex string")
+	}
+
+	tx, err := coin.DeserializeTransaction(data)
+	if err != nil {
+		return nil, rpcError(RPCErrInvalidParams, "malformed transaction: %s", err)
+	}
+
+	return s.submit(&tx)
+}
+
+func (s *rpcServer) submit(tx *coin.Transaction) (interface{}, error) {
+	err := s.node.SubmitTransaction(tx)
+	if err != nil {
+		return nil, rpcError(RPCErrRejected, "transaction rejected: %s", err)
+	}
+
+	return hex.EncodeToString(tx.ID), nil
+}
+
+// loadWallets reads the wallet file of the server. A missing file is an
+// empty wallet.
+func (s *rpcServer) loadWallets() (*coin.Wallets, error) {
+	wallets, err := coin.NewWallets(s.cfg.WalletDir, s.cfg.WalletID)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, rpcError(RPCErrWallet, "failed loading wallets: %s", err)
+	}
+
+	return wallets, nil
+}
+
+func handleGetNewAddress(s *rpcServer, params json.RawMessage) (interface{}, error) {
+	err := parseParams(params, &struct{}{})
+	if err != nil {
+		return nil, err
+	}
+
+	s.walletMu.Lock()
+	defer s.walletMu.Unlock()
+
+	wallets, err := s.loadWallets()
+	if err != nil {
+		return nil, err
+	}
+
+	address, err := wallets.CreateWallet(s.node.bc.Params())
+	if err != nil {
+		return nil, rpcError(RPCErrWallet, "%s", err)
+	}
+
+	err = wallets.SaveToFile(s.cfg.WalletDir, s.cfg.WalletID)
+	if err != nil {
+		return nil, rpcError(RPCErrWallet, "failed saving wallets: %s", err)
+	}
+
+	return address, nil
+}
+
+func handleListAddresses(s *rpcServer, params json.RawMessage) (interface{}, error) {
+	err := parseParams(params, &struct{}{})
+	if err != nil {
+		return nil, err
+	}
+
+	s.walletMu.Lock()
+	defer s.walletMu.Unlock()
+
+	wallets, err := s.loadWallets()
+	if err != nil {
+		return nil, err
+	}
+
+	addresses := append([]string{}, wallets.GetAddresses()...)
+	sort.Strings(addresses)
+	return addresses, nil
+}
+
+// addressParam decodes an address of the network of the node
+func (s *rpcServer) addressParam(address string) ([]byte, error) {
+	pubKeyHash, err := coin.DecodeAddress(address, s.node.bc.Params())
+	if err != nil {
+		return nil, rpcError(RPCErrInvalidParams, "%s", err)
+	}
+
+	return pubKeyHash, nil
+}
+
+func handleGetBalance(s *rpcServer, params json.RawMessage) (interface{}, error) {
+	var p struct {
+		Address string `json:"address"`
+	}
+	err := parseParams(params, &p)
+	if err != nil {
+		return nil, err
+	}
+
+	pubKeyHash, err := s.addressParam(p.Address)
+	if err != nil {
+		return nil, err
+	}
+
+	UTXOSet := coin.UTXOSet{Blockchain: s.node.bc}
+	balance, immature, err := UTXOSet.Balance(pubKeyHash)
+	if err != nil {
+		return nil, err
+	}
+
+	return BalanceResult{Address: p.Address, Balance: balance, Immature: immature}, nil
+}
+
+// handleGetAddressHistory returns the outputs of the main chain paid to the
+// address. It requires the address index.
+func handleGetAddressHistory(s *rpcServer, params json.RawMessage) (interface{}, error) {
+	var p struct {
+		Address string `json:"address"`
+	}
+	err := parseParams(params, &p)
+	if err != nil {
+		return nil, err
+	}
+
+	pubKeyHash, err := s.addressParam(p.Address)
+	if err != nil {
+		return nil, err
+	}
+
+	enabled, err := s.node.bc.HasAddrIndex()
+	if err != nil {
+		return nil, err
+	}
+	if !enabled {
+		return nil, rpcError(RPCErrNotFound, "address index is not enabled")
+	}
+
+	history, err := s.node.bc.AddressHistory(pubKeyHash)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := []HistoryEntry{}
+	for _, out := range history {
+		entry := HistoryEntry{
+			Txid:   hex.EncodeToString(out.Txid),
+			Vout:   out.Vout,
+			Value:  out.Value,
+			Height: out.Height,
+		}
+		if out.SpentBy != nil {
+			entry.SpentBy = hex.EncodeToString(out.SpentBy)
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
+}
+
+// handleSend creates a transaction from an address of the wallet, submits it
+// to the mempool and relays it. It returns the ID of the transaction. Outputs
+// spent by the mempool are not used, but change of transactions in the
+// mempool is.
+func handleSend(s *rpcServer, params json.RawMessage) (interface{}, error) {
+	var p struct {
+		From   string `json:"from"`
+		To     string `json:"to"`
+		Amount int    `json:"amount"`
+		Fee    int    `json:"fee"`
+	}
+	err := parseParams(params, &p)
+	if err != nil {
+		return nil, err
+	}
+
+	pubKeyHash, err := s.addressParam(p.From)
+	if err != nil {
+		return nil, err
+	}
+	_, err = s.addressParam(p.To)
+	if err != nil {
+		return nil, err
+	}
+	if p.Amount <= 0 {
+		return nil, rpcError(RPCErrInvalidParams, "amount needs to be > 0")
+	}
+	if p.Fee < 0 {
+		return nil, rpcError(RPCErrInvalidParams, "fee must not be negative")
+	}
+
+	s.walletMu.Lock()
+	wallets, err := s.loadWallets()
+	s.walletMu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	wallet, err := wallets.GetWallet(p.From)
+	if err != nil {
+		return nil, rpcError(RPCErrWallet, "%s", err)
+	}
+
+	// Sends are serialized, so two of them do not select the same outputs
+	s.sendMu.Lock()
+	defer s.sendMu.Unlock()
+
+	spendable, err := s.node.spendableOutputs(pubKeyHash)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := coin.NewTransactionFromOutputs(&wallet, p.To, p.Amount, p.Fee, spendable, s.node.bc.Params())
+	if err != nil {
+		return nil, rpcError(RPCErrWallet, "%s", err)
+	}
+
+	return s.submit(tx)
+}
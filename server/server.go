@@ -1,27 +1,40 @@
 package server
 
 import (
-	"bytes"
+	"encoding/hex"
 	"fmt"
-	"io"
 	"net"
+	"path/filepath"
 
 	"github.com/thesoenke/go-coin"
 )
 
 const (
 	protocol            = "tcp"
-	nodeVersion         = 1
+	nodeVersion         = 2
+	minProtocolVersion  = 2
 	commandLength       = 12
 	transactionsInBlock = 2
-)
 
-var (
-	nodeAddress     string
-	miningAddress   string
-	knownNodes      = []string{"localhost:3000"}
-	blocksInTransit = [][]byte{}
-	mempool         = make(map[string]coin.Transaction)
+	// blockReservedSize is the space of a block template that is kept free
+	// for the header and the coinbase
+	blockReservedSize = 1000
+
+	// maxHeadersPerMsg is the maximum number of headers sent in response to a
+	// getheaders message
+	maxHeadersPerMsg = 2000
+
+	// serviceNodeNetwork signals that a node serves the full block chain
+	serviceNodeNetwork uint64 = 1
+
+	// serviceSubmitOnly signals a client that connects only to push the
+	// transactions it submits
+	serviceSubmitOnly uint64 = 2
+
+	// maxSubmittedTxs is the number of unrequested transactions a client that
+	// announced serviceSubmitOnly may push on a connection. SendTx submits a
+	// single transaction.
+	maxSubmittedTxs = 1
 )
 
 type addr struct {
@@ -29,168 +42,129 @@ type addr struct {
 }
 
 type block struct {
-	AddrFrom string
-	Block    []byte
+	Block []byte
 }
 
-type getblocks struct {
-	AddrFrom string
-}
+type getaddr struct{}
 
 type getdata struct {
-	AddrFrom string
-	Type     string
-	ID       []byte
+	Type string
+	ID   []byte
+}
+
+type getheaders struct {
+	Locator [][]byte
+}
+
+type headers struct {
+	Headers [][]byte
 }
 
 type inv struct {
-	AddrFrom string
-	Type     string
-	Items    [][]byte
+	Type  string
+	Items [][]byte
+}
+
+type ping struct {
+	Nonce uint64
+}
+
+type pong struct {
+	Nonce uint64
 }
 
 type tx struct {
-	AddFrom     string
 	Transaction []byte
 }
 
+type verack struct{}
+
 type version struct {
 	Version    int
+	Services   uint64
 	BestHeight int
 	AddrFrom   string
 }
 
-// Start server to run a node
-func Start(nodeID int, minerAddress string) error {
-	nodeAddress = fmt.Sprintf("localhost:%d", nodeID)
-	miningAddress = minerAddress
-	ln, err := net.Listen(protocol, nodeAddress)
-	if err != nil {
-		return err
-	}
-
-	defer ln.Close()
-	bc, err := coin.NewBlockchain(nodeID)
+// Start runs a node for the blockchain until it is stopped
+func Start(bc *coin.Blockchain, cfg Config) error {
+	node := NewNode(bc, cfg)
+	err := node.Start()
 	if err != nil {
 		return err
 	}
 
-	if nodeAddress != knownNodes[0] {
-		err = sendVersion(knownNodes[0], bc)
-		if err != nil {
-			fmt.Println("Failed sending version to central node")
-			return err
-		}
-	}
-
-	for {
-		conn, err := ln.Accept()
-		if err != nil {
-			return err
-		}
-		go handleConnection(conn, bc)
-	}
+	<-node.Done()
+	node.Stop()
+	return nil
 }
 
-// SendTx sends a transaction to another node
-func SendTx(tx *coin.Transaction) {
-	err := sendTx(knownNodes[0], tx)
-	if err != nil {
-		fmt.Println("Failed sending transaction to central node")
-	}
+// PeersFile returns the path of the known addresses of the node ID in the
+// directory
+func PeersFile(dir string, nodeID int) string {
+	return filepath.Join(dir, fmt.Sprintf("peers_%d.json", nodeID))
 }
 
-func sendAddr(address string) error {
-	nodes := addr{knownNodes}
-	nodes.AddrList = append(nodes.AddrList, nodeAddress)
-	payload := gobEncode(nodes)
-	request := append(commandToBytes("addr"), payload...)
-
-	err := sendData(address, request)
-	return err
+// BanFile returns the path of the ban list of the node ID in the directory
+func BanFile(dir string, nodeID int) string {
+	return filepath.Join(dir, fmt.Sprintf("bans_%d.json", nodeID))
 }
 
-func sendBlock(addr string, b *coin.Block) error {
-	data := block{nodeAddress, b.Serialize()}
-	payload := gobEncode(data)
-	request := append(commandToBytes("block"), payload...)
-
-	err := sendData(addr, request)
-	return err
-}
-
-func sendData(addr string, data []byte) error {
-	conn, err := net.Dial(protocol, addr)
+// SendTx sends a transaction to the node of the network at address, which
+// relays it to the network
+func SendTx(address string, t *coin.Transaction, params *coin.ChainParams) error {
+	conn, err := net.DialTimeout(protocol, address, dialTimeout)
 	if err != nil {
-		fmt.Printf("%s is not available\n", addr)
-		removeNode(addr)
 		return err
 	}
-
 	defer conn.Close()
-	_, err = io.Copy(conn, bytes.NewReader(data))
-	return err
-}
 
-func sendInv(address, kind string, items [][]byte) error {
-	inventory := inv{AddrFrom: nodeAddress, Type: kind, Items: items}
-	payload := gobEncode(inventory)
-	request := append(commandToBytes("inv"), payload...)
+	// A client that only submits a transaction does not serve blocks and has
+	// no listen address
+	p := newPeer(nil, conn, false, params.Net)
+	err = p.handshake(&version{Version: nodeVersion, Services: serviceSubmitOnly})
+	if err != nil {
+		return err
+	}
 
-	err := sendData(address, request)
-	return err
+	return p.sendTx(t)
 }
 
-func sendGetBlocks(address string) error {
-	payload := gobEncode(getblocks{AddrFrom: nodeAddress})
-	request := append(commandToBytes("getblocks"), payload...)
-
-	err := sendData(address, request)
-	return err
+func (p *peer) sendAddr(addresses []string) error {
+	return p.sendMessage("addr", (&addr{addresses}).serialize())
 }
 
-func sendGetData(address, kind string, id []byte) error {
-	payload := gobEncode(getdata{nodeAddress, kind, id})
-	request := append(commandToBytes("getdata"), payload...)
+func (p *peer) sendBlock(b *coin.Block) error {
+	return p.sendMessage("block", (&block{b.Serialize()}).serialize())
+}
 
-	err := sendData(address, request)
-	return err
+func (p *peer) sendGetAddr() error {
+	return p.sendMessage("getaddr", (&getaddr{}).serialize())
 }
 
-func sendTx(addr string, tnx *coin.Transaction) error {
-	data := tx{nodeAddress, tnx.Serialize()}
-	payload := gobEncode(data)
-	request := append(commandToBytes("tx"), payload...)
+func (p *peer) sendGetData(kind string, id []byte) error {
+	p.requested[hex.EncodeToString(id)] = true
+	return p.sendMessage("getdata", (&getdata{kind, id}).serialize())
+}
 
-	err := sendData(addr, request)
-	return err
+func (p *peer) sendGetHeaders(locator [][]byte) error {
+	p.headersRequested = true
+	return p.sendMessage("getheaders", (&getheaders{locator}).serialize())
 }
 
-func sendVersion(addr string, bc *coin.Blockchain) error {
-	bestHeight, err := bc.GetBestHeight()
-	if err != nil {
-		return err
+func (p *peer) sendHeaders(blockHeaders []*coin.BlockHeader) error {
+	var data headers
+	for _, header := range blockHeaders {
+		data.Headers = append(data.Headers, header.Serialize())
 	}
 
-	payload := gobEncode(version{
-		Version:    nodeVersion,
-		BestHeight: bestHeight,
-		AddrFrom:   nodeAddress,
-	})
-
-	request := append(commandToBytes("version"), payload...)
-
-	err = sendData(addr, request)
-	return err
+	return p.sendMessage("headers", data.serialize())
 }
 
-func removeNode(addr string) {
-	var updatedNodes []string
-	for _, node := range knownNodes {
-		if node != addr {
-			updatedNodes = append(updatedNodes, node)
-		}
-	}
+func (p *peer) sendInv(kind string, items [][]byte) error {
+	return p.sendMessage("inv", (&inv{Type: kind, Items: items}).serialize())
+}
 
-	knownNodes = updatedNodes
+func (p *peer) sendTx(t *coin.Transaction) error {
+	return p.sendMessage("tx", (&tx{t.Serialize()}).serialize())
 }
@@ -1,11 +1,6 @@
 package server
 
-import (
-	"bytes"
-	"encoding/gob"
-	"fmt"
-	"log"
-)
+import "fmt"
 
 func commandToBytes(command string) []byte {
 	var bytes [commandLength]byte
@@ -28,15 +23,3 @@ func bytesToCommand(bytes []byte) string {
 
 	return fmt.Sprintf("%s", command)
 }
-
-func gobEncode(data interface{}) []byte {
-	var buff bytes.Buffer
-
-	enc := gob.NewEncoder(&buff)
-	err := enc.Encode(data)
-	if err != nil {
-		log.Panic(err)
-	}
-
-	return buff.Bytes()
-}
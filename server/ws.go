@@ -0,0 +1,677 @@
+package server
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	coin "github.com/thesoenke/go-coin"
+)
+
+const (
+	// wsEventBuffer is the number of events queued for a client. A client
+	// that falls further behind is disconnected, so it can not stall the
+	// node.
+	wsEventBuffer = 256
+
+	// wsMaxRequestSize limits the messages of a client
+	wsMaxRequestSize = 4096
+
+	// wsMaxAddresses is the number of addresses a client may watch
+	wsMaxAddresses = 1000
+
+	wsWriteTimeout = 10 * time.Second
+
+	// A client has to answer a ping within wsPongTimeout
+	wsPongTimeout  = 60 * time.Second
+	wsPingInterval = wsPongTimeout * 9 / 10
+)
+
+// Topics of the WebSocket event stream
+const (
+	// TopicBlocks streams the blocks connected to the main chain
+	TopicBlocks = "blocks"
+
+	// TopicReorgs streams the blocks disconnected from the main chain
+	TopicReorgs = "reorgs"
+
+	// TopicTxs streams the transactions added to and removed from the
+	// mempool
+	TopicTxs = "txs"
+
+	// TopicPeers streams the peers that connect and disconnect
+	TopicPeers = "peers"
+
+	// TopicAddress streams the payments to an address
+	TopicAddress = "address"
+)
+
+// Events of TopicAddress
+const (
+	// wsEventPayment is sent when a transaction paying the address is
+	// accepted to the mempool or mined, whichever happens first
+	wsEventPayment = "payment"
+
+	// wsEventConfirmed is sent when the payment reaches the confirmations
+	// of the subscription. The payment is not watched afterwards.
+	wsEventConfirmed = "confirmed"
+
+	// wsEventReorged is sent when the block of a payment is disconnected
+	// before the payment is confirmed and the payment is mined on the new
+	// main chain or returns to the mempool. It is sent once the change of
+	// the main chain is complete.
+	wsEventReorged = "reorged"
+
+	// wsEventRemoved is sent when an unmined payment leaves the mempool
+	// because it conflicts with a mined transaction or became invalid, and
+	// when the block of a payment is disconnected and the payment is neither
+	// mined on the new main chain nor returns to the mempool. The payment is
+	// not watched afterwards.
+	wsEventRemoved = "removed"
+)
+
+// WSRequest is a message of a WebSocket client. Method is "subscribe" or
+// "unsubscribe". Address and Confirmations are only used by TopicAddress.
+type WSRequest struct {
+	// ID is returned in the reply and should not be zero
+	ID     int    `json:"id"`
+	Method string `json:"method"`
+	Topic  string `json:"topic"`
+
+	Address string `json:"address,omitempty"`
+
+	// Confirmations is the number of confirmations of a payment that is
+	// reported with a "confirmed" event. It defaults to 1.
+	Confirmations int `json:"confirmations,omitempty"`
+}
+
+// WSMessage is a message of the server, either the reply to a request or an
+// event of a subscribed topic
+type WSMessage struct {
+	// ID and either Result or Error are set in replies
+	ID     int    `json:"id,omitempty"`
+	Result string `json:"result,omitempty"`
+	Error  string `json:"error,omitempty"`
+
+	// Topic, Event and Data are set in events
+	Topic string          `json:"topic,omitempty"`
+	Event string          `json:"event,omitempty"`
+	Data  json.RawMessage `json:"data,omitempty"`
+}
+
+// BlockEvent is the data of the events of TopicBlocks and TopicReorgs
+type BlockEvent struct {
+	Hash          string   `json:"hash"`
+	PrevBlockHash string   `json:"prevblockhash"`
+	Height        int      `json:"height"`
+	Time          int64    `json:"time"`
+	Transactions  []string `json:"tx"`
+}
+
+// PaymentEvent is the data of the events of TopicAddress
+type PaymentEvent struct {
+	Address string `json:"address"`
+	Txid    string `json:"txid"`
+
+	// Value is the sum of the outputs of the transaction paying the address
+	Value int `json:"value"`
+
+	// Confirmations is 0 if the payment is not mined. BlockHash and Height
+	// are only set for mined payments.
+	Confirmations int    `json:"confirmations"`
+	BlockHash     string `json:"blockhash,omitempty"`
+	Height        int    `json:"height,omitempty"`
+}
+
+// wsServer streams the events of a node to WebSocket clients
+type wsServer struct {
+	node     *Node
+	address  string
+	http     *http.Server
+	upgrader websocket.Upgrader
+
+	mu      sync.Mutex
+	clients map[*wsClient]struct{}
+	stopped bool
+
+	// wg tracks the connections, which are not closed by the HTTP server
+	wg sync.WaitGroup
+}
+
+func newWSServer(node *Node, address string) *wsServer {
+	s := &wsServer{
+		node:    node,
+		address: address,
+		clients: make(map[*wsClient]struct{}),
+	}
+	s.http = &http.Server{Handler: s}
+
+	return s
+}
+
+func (s *wsServer) start() error {
+	return s.node.serveHTTP(s.http, s.address, "WebSocket")
+}
+
+// stop closes the server and the connections of the clients and waits until
+// they are gone
+func (s *wsServer) stop() {
+	s.http.Close()
+
+	s.mu.Lock()
+	s.stopped = true
+	for c := range s.clients {
+		c.close()
+	}
+	s.mu.Unlock()
+
+	s.wg.Wait()
+}
+
+// ServeHTTP upgrades a request to a WebSocket connection and streams events
+// until the connection is closed
+func (s *wsServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+
+	// Upgrade answers failed handshakes
+	conn, err := s.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+
+	c := newWSClient(s.node, conn)
+	if !s.addClient(c) {
+		conn.Close()
+		return
+	}
+	defer s.removeClient(c)
+
+	unsubscribe := s.node.Subscribe(c.queueEvent)
+	defer unsubscribe()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		c.run()
+	}()
+
+	c.readRequests()
+	c.close()
+	<-done
+}
+
+func (s *wsServer) addClient(c *wsClient) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.stopped {
+		return false
+	}
+
+	s.clients[c] = struct{}{}
+	s.wg.Add(1)
+	return true
+}
+
+func (s *wsServer) removeClient(c *wsClient) {
+	s.mu.Lock()
+	delete(s.clients, c)
+	s.mu.Unlock()
+
+	s.wg.Done()
+}
+
+// wsClient is a connection of the WebSocket server. The subscriptions are
+// only accessed by the goroutine of run, which also writes all messages.
+type wsClient struct {
+	node     *Node
+	conn     *websocket.Conn
+	events   chan *Event
+	requests chan []byte
+
+	quit      chan struct{}
+	closeOnce sync.Once
+
+	topics    map[string]bool
+	addresses map[string]*addressSubscription
+}
+
+func newWSClient(node *Node, conn *websocket.Conn) *wsClient {
+	return &wsClient{
+		node:      node,
+		conn:      conn,
+		events:    make(chan *Event, wsEventBuffer),
+		requests:  make(chan []byte),
+		quit:      make(chan struct{}),
+		topics:    make(map[string]bool),
+		addresses: make(map[string]*addressSubscription),
+	}
+}
+
+// close disconnects the client. It may be called several times.
+func (c *wsClient) close() {
+	c.closeOnce.Do(func() {
+		close(c.quit)
+		c.conn.Close()
+	})
+}
+
+// queueEvent is the callback of the event bus. It must not block the node,
+// so a client whose queue is full is disconnected.
+func (c *wsClient) queueEvent(event *Event) {
+	select {
+	case c.events <- event:
+	case <-c.quit:
+	default:
+		fmt.Printf("Disconnecting WebSocket client %s, it does not keep up with the events\n", c.conn.RemoteAddr())
+		c.close()
+	}
+}
+
+// readRequests passes the messages of the client to run until the
+// connection is closed
+func (c *wsClient) readRequests() {
+	c.conn.SetReadLimit(wsMaxRequestSize)
+	c.conn.SetReadDeadline(time.Now().Add(wsPongTimeout))
+	c.conn.SetPongHandler(func(string) error {
+		return c.conn.SetReadDeadline(time.Now().Add(wsPongTimeout))
+	})
+
+	for {
+		_, data, err := c.conn.ReadMessage()
+		if err != nil {
+			return
+		}
+
+		select {
+		case c.requests <- data:
+		case <-c.quit:
+			return
+		}
+	}
+}
+
+// run answers requests, writes the events of the subscribed topics and
+// pings the client until it is closed
+func (c *wsClient) run() {
+	ticker := time.NewTicker(wsPingInterval)
+	defer ticker.Stop()
+
+	for {
+		var messages []*WSMessage
+		select {
+		case data := <-c.requests:
+			messages = append(messages, c.handleRequest(data))
+		case event := <-c.events:
+			messages = c.handleEvent(event)
+		case <-ticker.C:
+			err := c.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsWriteTimeout))
+			if err != nil {
+				c.close()
+				return
+			}
+		case <-c.quit:
+			return
+		}
+
+		for _, message := range messages {
+			c.conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+			err := c.conn.WriteJSON(message)
+			if err != nil {
+				c.close()
+				return
+			}
+		}
+	}
+}
+
+// handleRequest changes the subscriptions and returns the reply
+func (c *wsClient) handleRequest(data []byte) *WSMessage {
+	var req WSRequest
+	err := json.Unmarshal(data, &req)
+	if err != nil {
+		return &WSMessage{Error: fmt.Sprintf("invalid request: %s", err)}
+	}
+
+	switch req.Method {
+	case "subscribe":
+		err = c.subscribe(&req)
+		if err != nil {
+			return &WSMessage{ID: req.ID, Error: err.Error()}
+		}
+		return &WSMessage{ID: req.ID, Result: "subscribed"}
+	case "unsubscribe":
+		err = c.unsubscribe(&req)
+		if err != nil {
+			return &WSMessage{ID: req.ID, Error: err.Error()}
+		}
+		return &WSMessage{ID: req.ID, Result: "unsubscribed"}
+	default:
+		return &WSMessage{ID: req.ID, Error: fmt.Sprintf("unknown method '%s'", req.Method)}
+	}
+}
+
+func (c *wsClient) subscribe(req *WSRequest) error {
+	switch req.Topic {
+	case TopicBlocks, TopicReorgs, TopicTxs, TopicPeers:
+		c.topics[req.Topic] = true
+		return nil
+	case TopicAddress:
+	default:
+		return fmt.Errorf("unknown topic '%s'", req.Topic)
+	}
+
+	pubKeyHash, err := coin.DecodeAddress(req.Address, c.node.bc.Params())
+	if err != nil {
+		return err
+	}
+
+	confirmations := req.Confirmations
+	if confirmations < 0 {
+		return fmt.Errorf("invalid confirmations %d", confirmations)
+	}
+	if confirmations == 0 {
+		confirmations = 1
+	}
+
+	if c.addresses[req.Address] == nil && len(c.addresses) >= wsMaxAddresses {
+		return fmt.Errorf("at most %d addresses can be watched", wsMaxAddresses)
+	}
+
+	// A new subscription of an address replaces the previous one
+	c.addresses[req.Address] = &addressSubscription{
+		address:       req.Address,
+		pubKeyHash:    pubKeyHash,
+		confirmations: confirmations,
+		payments:      make(map[string]*payment),
+	}
+
+	return nil
+}
+
+// unsubscribe removes a subscription. Without an address all addresses are
+// unsubscribed from TopicAddress.
+func (c *wsClient) unsubscribe(req *WSRequest) error {
+	switch req.Topic {
+	case TopicBlocks, TopicReorgs, TopicTxs, TopicPeers:
+		delete(c.topics, req.Topic)
+	case TopicAddress:
+		if req.Address == "" {
+			c.addresses = make(map[string]*addressSubscription)
+		} else {
+			delete(c.addresses, req.Address)
+		}
+	default:
+		return fmt.Errorf("unknown topic '%s'", req.Topic)
+	}
+
+	return nil
+}
+
+// handleEvent returns the messages of an event for the subscriptions of the
+// client
+func (c *wsClient) handleEvent(event *Event) []*WSMessage {
+	var messages []*WSMessage
+
+	topic, data := c.topicEvent(event)
+	if topic != "" && c.topics[topic] {
+		messages = append(messages, newEventMessage(topic, string(event.Type), data))
+	}
+
+	for _, sub := range c.addresses {
+		for _, p := range sub.handleEvent(event) {
+			messages = append(messages, newEventMessage(TopicAddress, p.event, p.data))
+		}
+	}
+
+	return messages
+}
+
+// topicEvent returns the topic of an event and its data
+func (c *wsClient) topicEvent(event *Event) (string, interface{}) {
+	switch event.Type {
+	case EventBlockConnected:
+		return TopicBlocks, newBlockEvent(event.Block)
+	case EventBlockDisconnected:
+		return TopicReorgs, newBlockEvent(event.Block)
+	case EventTxAccepted, EventTxRemoved:
+		result := newTxResult(event.Tx, c.node.bc.Params())
+		result.InMempool = event.Type == EventTxAccepted
+		return TopicTxs, result
+	case EventPeerConnected, EventPeerDisconnected:
+		return TopicPeers, event.Peer
+	}
+
+	return "", nil
+}
+
+func newEventMessage(topic, event string, data interface{}) *WSMessage {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		// The data types of the events always encode
+		panic(err)
+	}
+
+	return &WSMessage{Topic: topic, Event: event, Data: raw}
+}
+
+func newBlockEvent(block *coin.Block) *BlockEvent {
+	event := &BlockEvent{
+		Hash:          hex.EncodeToString(block.Hash),
+		PrevBlockHash: hex.EncodeToString(block.PrevBlockHash),
+		Height:        block.Height,
+		Time:          block.Timestamp,
+		Transactions:  []string{},
+	}
+	for _, tx := range block.Transactions {
+		event.Transactions = append(event.Transactions, hex.EncodeToString(tx.ID))
+	}
+
+	return event
+}
+
+// addressSubscription watches the payments to an address until they reach
+// the confirmations of the subscription
+type addressSubscription struct {
+	address       string
+	pubKeyHash    []byte
+	confirmations int
+
+	// payments are the watched transactions by ID
+	payments map[string]*payment
+}
+
+// payment is a transaction paying a watched address
+type payment struct {
+	txid  string
+	value int
+
+	// block is nil while the payment is not mined
+	block *coin.Block
+
+	// inMempool is set while the transaction is in the mempool
+	inMempool bool
+
+	// reorged is set when the block of the payment is disconnected until the
+	// change of the main chain is complete
+	reorged bool
+}
+
+// paymentEvent is an event of TopicAddress and its data
+type paymentEvent struct {
+	event string
+	data  *PaymentEvent
+}
+
+// handleEvent updates the payments with an event of the node and returns
+// the events of the subscription
+func (sub *addressSubscription) handleEvent(event *Event) []paymentEvent {
+	switch event.Type {
+	case EventTxAccepted:
+		return sub.txAccepted(event.Tx)
+	case EventTxRemoved:
+		return sub.txRemoved(event.Tx)
+	case EventBlockConnected:
+		return sub.blockConnected(event.Block)
+	case EventBlockDisconnected:
+		sub.blockDisconnected(event.Block)
+	case EventTipChanged:
+		return sub.tipChanged(event.Block)
+	}
+
+	return nil
+}
+
+// value returns the sum of the outputs of the transaction paying the address
+func (sub *addressSubscription) value(tx *coin.Transaction) int {
+	value := 0
+	for _, out := range tx.Vout {
+		if out.IsLockedWithKey(sub.pubKeyHash) {
+			value += out.Value
+		}
+	}
+
+	return value
+}
+
+func (sub *addressSubscription) paymentEvent(event string, p *payment, confirmations int) paymentEvent {
+	data := &PaymentEvent{
+		Address:       sub.address,
+		Txid:          p.txid,
+		Value:         p.value,
+		Confirmations: confirmations,
+	}
+	if p.block != nil {
+		data.BlockHash = hex.EncodeToString(p.block.Hash)
+		data.Height = p.block.Height
+	}
+
+	return paymentEvent{event: event, data: data}
+}
+
+func (sub *addressSubscription) txAccepted(tx *coin.Transaction) []paymentEvent {
+	id := hex.EncodeToString(tx.ID)
+	if p := sub.payments[id]; p != nil {
+		// Transactions of disconnected blocks return to the mempool and
+		// are already watched
+		p.inMempool = true
+		return nil
+	}
+
+	value := sub.value(tx)
+	if value == 0 {
+		return nil
+	}
+
+	p := &payment{txid: id, value: value, inMempool: true}
+	sub.payments[id] = p
+	return []paymentEvent{sub.paymentEvent(wsEventPayment, p, 0)}
+}
+
+func (sub *addressSubscription) txRemoved(tx *coin.Transaction) []paymentEvent {
+	id := hex.EncodeToString(tx.ID)
+	p := sub.payments[id]
+
+	if p == nil {
+		return nil
+	}
+
+	// Mined transactions are removed from the mempool after their block is
+	// connected. Reorged payments are decided once the change of the main
+	// chain is complete.
+	if p.block != nil || p.reorged {
+		p.inMempool = false
+		return nil
+	}
+
+	delete(sub.payments, id)
+	return []paymentEvent{sub.paymentEvent(wsEventRemoved, p, 0)}
+}
+
+func (sub *addressSubscription) blockConnected(block *coin.Block) []paymentEvent {
+	var events []paymentEvent
+	for _, tx := range block.Transactions {
+		id := hex.EncodeToString(tx.ID)
+		if p := sub.payments[id]; p != nil {
+			p.block = block
+			continue
+		}
+
+		value := sub.value(tx)
+		if value == 0 {
+			continue
+		}
+
+		p := &payment{txid: id, value: value, block: block}
+		sub.payments[id] = p
+		events = append(events, sub.paymentEvent(wsEventPayment, p, 1))
+	}
+
+	for id, p := range sub.payments {
+		if p.block == nil || p.reorged {
+			continue
+		}
+
+		confirmations := block.Height - p.block.Height + 1
+		if confirmations >= sub.confirmations {
+			delete(sub.payments, id)
+			events = append(events, sub.paymentEvent(wsEventConfirmed, p, confirmations))
+		}
+	}
+
+	return events
+}
+
+// blockDisconnected marks the payments of the block as reorged. They are
+// reported once the change of the main chain is complete, as they may be
+// mined again by a block of the new main chain.
+func (sub *addressSubscription) blockDisconnected(block *coin.Block) {
+	for _, tx := range block.Transactions {
+		p := sub.payments[hex.EncodeToString(tx.ID)]
+		if p == nil || p.block == nil || !bytes.Equal(p.block.Hash, block.Hash) {
+			continue
+		}
+
+		p.block = nil
+		p.reorged = true
+	}
+}
+
+// tipChanged reports the reorged payments as removed if they are neither
+// mined on the new main chain nor in the mempool, and as reorged otherwise
+func (sub *addressSubscription) tipChanged(tip *coin.Block) []paymentEvent {
+	var events []paymentEvent
+	for id, p := range sub.payments {
+		if !p.reorged {
+			continue
+		}
+		p.reorged = false
+
+		if p.block == nil && !p.inMempool {
+			delete(sub.payments, id)
+			events = append(events, sub.paymentEvent(wsEventRemoved, p, 0))
+			continue
+		}
+
+		confirmations := 0
+		if p.block != nil {
+			confirmations = tip.Height - p.block.Height + 1
+		}
+		events = append(events, sub.paymentEvent(wsEventReorged, p, confirmations))
+
+		if p.block != nil && confirmations >= sub.confirmations {
+			delete(sub.payments, id)
+			events = append(events, sub.paymentEvent(wsEventConfirmed, p, confirmations))
+		}
+	}
+
+	return events
+}
@@ -0,0 +1,256 @@
+package server
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	coin "github.com/thesoenke/go-coin"
+)
+
+// readWSMessage reads the next message of the server
+func readWSMessage(t *testing.T, conn *websocket.Conn) *WSMessage {
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+
+	var message WSMessage
+	require.NoError(t, conn.ReadJSON(&message))
+	return &message
+}
+
+// wsRequest sends a request and returns the reply
+func wsRequest(t *testing.T, conn *websocket.Conn, req *WSRequest) *WSMessage {
+	require.NoError(t, conn.WriteJSON(req))
+
+	reply := readWSMessage(t, conn)
+	require.Equal(t, req.ID, reply.ID)
+	return reply
+}
+
+// readWSEvent reads the next message, which has to be the event, and decodes
+// its data into data
+func readWSEvent(t *testing.T, conn *websocket.Conn, topic, event string, data interface{}) {
+	message := readWSMessage(t, conn)
+	require.Equal(t, topic, message.Topic)
+	require.Equal(t, event, message.Event)
+	require.NoError(t, json.Unmarshal(message.Data, data))
+}
+
+func TestWebSocketEvents(t *testing.T) {
+	wallet, err := coin.NewWallet()
+	require.NoError(t, err)
+	address := string(wallet.GetAddress(testParams))
+
+	chains, _ := openChains(t, address, 23061)
+	bc := chains[0]
+	node := NewNode(bc, Config{Address: "localhost:23061", WSAddress: "localhost:23060"})
+	require.NoError(t, node.Start())
+	defer node.Stop()
+
+	// Mine until the genesis reward is mature
+	for i := 0; i < testParams.CoinbaseMaturity; i++ {
+		_, err := bc.MineBlock([]*coin.Transaction{coin.NewCoinbaseTX(address, "", 10)})
+		require.NoError(t, err)
+	}
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws://localhost:23060/", nil)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	receiver, err := coin.NewWallet()
+	require.NoError(t, err)
+	receiverAddress := string(receiver.GetAddress(testParams))
+
+	for i, topic := range []string{TopicBlocks, TopicReorgs, TopicTxs} {
+		reply := wsRequest(t, conn, &WSRequest{ID: i + 1, Method: "subscribe", Topic: topic})
+		assert.Equal(t, "subscribed", reply.Result)
+	}
+	reply := wsRequest(t, conn, &WSRequest{ID: 4, Method: "subscribe", Topic: TopicAddress, Address: receiverAddress, Confirmations: 2})
+	assert.Equal(t, "subscribed", reply.Result)
+
+	reply = wsRequest(t, conn, &WSRequest{ID: 5, Method: "subscribe", Topic: TopicAddress, Address: string(receiver.GetAddress(&coin.MainNetParams))})
+	assert.NotEmpty(t, reply.Error)
+	reply = wsRequest(t, conn, &WSRequest{ID: 6, Method: "subscribe", Topic: "headers"})
+	assert.Equal(t, "unknown topic 'headers'", reply.Error)
+	reply = wsRequest(t, conn, &WSRequest{ID: 7, Method: "listen"})
+	assert.Equal(t, "unknown method 'listen'", reply.Error)
+
+	// The payment is reported when it enters the mempool
+	UTXOSet := coin.UTXOSet{Blockchain: bc}
+	tx, err := coin.NewUTXOTransaction(wallet, receiverAddress, 3, 1, &UTXOSet)
+	require.NoError(t, err)
+	txid := hex.EncodeToString(tx.ID)
+	require.NoError(t, node.SubmitTransaction(tx))
+
+	var txResult TxResult
+	readWSEvent(t, conn, TopicTxs, string(EventTxAccepted), &txResult)
+	assert.Equal(t, txid, txResult.Txid)
+	assert.True(t, txResult.InMempool)
+
+	var payment PaymentEvent
+	readWSEvent(t, conn, TopicAddress, wsEventPayment, &payment)
+	assert.Equal(t, PaymentEvent{Address: receiverAddress, Txid: txid, Value: 3}, payment)
+
+	// Mining the payment is not reported before it has two confirmations
+	mined, err := bc.MineBlock([]*coin.Transaction{coin.NewCoinbaseTX(address, "", 10), tx})
+	require.NoError(t, err)
+
+	var block BlockEvent
+	readWSEvent(t, conn, TopicBlocks, string(EventBlockConnected), &block)
+	assert.Equal(t, hex.EncodeToString(mined.Hash), block.Hash)
+	assert.Equal(t, testParams.CoinbaseMaturity+1, block.Height)
+	assert.Equal(t, []string{hex.EncodeToString(mined.Transactions[0].ID), txid}, block.Transactions)
+
+	var removed TxResult
+	readWSEvent(t, conn, TopicTxs, string(EventTxRemoved), &removed)
+	assert.Equal(t, txid, removed.Txid)
+	assert.False(t, removed.InMempool)
+
+	// The payment returns to the mempool when its block is disconnected
+	require.NoError(t, bc.InvalidateBlock(mined.Hash))
+
+	readWSEvent(t, conn, TopicReorgs, string(EventBlockDisconnected), &block)
+	assert.Equal(t, hex.EncodeToString(mined.Hash), block.Hash)
+
+	readWSEvent(t, conn, TopicTxs, string(EventTxAccepted), &txResult)
+	assert.Equal(t, txid, txResult.Txid)
+
+	readWSEvent(t, conn, TopicAddress, wsEventReorged, &payment)
+	assert.Equal(t, PaymentEvent{Address: receiverAddress, Txid: txid, Value: 3}, payment)
+
+	reply = wsRequest(t, conn, &WSRequest{ID: 8, Method: "unsubscribe", Topic: TopicTxs})
+	assert.Equal(t, "unsubscribed", reply.Result)
+
+	mined, err = bc.MineBlock([]*coin.Transaction{coin.NewCoinbaseTX(address, "", 10), tx})
+	require.NoError(t, err)
+	readWSEvent(t, conn, TopicBlocks, string(EventBlockConnected), &block)
+	assert.Equal(t, hex.EncodeToString(mined.Hash), block.Hash)
+
+	next, err := bc.MineBlock([]*coin.Transaction{coin.NewCoinbaseTX(address, "", 10)})
+	require.NoError(t, err)
+	readWSEvent(t, conn, TopicBlocks, string(EventBlockConnected), &block)
+	assert.Equal(t, hex.EncodeToString(next.Hash), block.Hash)
+
+	readWSEvent(t, conn, TopicAddress, wsEventConfirmed, &payment)
+	assert.Equal(t, PaymentEvent{
+		Address:       receiverAddress,
+		Txid:          txid,
+		Value:         3,
+		Confirmations: 2,
+		BlockHash:     hex.EncodeToString(mined.Hash),
+		Height:        mined.Height,
+	}, payment)
+
+	// A coinbase does not return to the mempool when its block is
+	// disconnected
+	mined, err = bc.MineBlock([]*coin.Transaction{coin.NewCoinbaseTX(receiverAddress, "", 10)})
+	require.NoError(t, err)
+	coinbaseID := hex.EncodeToString(mined.Transactions[0].ID)
+
+	readWSEvent(t, conn, TopicBlocks, string(EventBlockConnected), &block)
+	readWSEvent(t, conn, TopicAddress, wsEventPayment, &payment)
+	assert.Equal(t, coinbaseID, payment.Txid)
+	assert.Equal(t, 1, payment.Confirmations)
+
+	require.NoError(t, bc.InvalidateBlock(mined.Hash))
+	readWSEvent(t, conn, TopicReorgs, string(EventBlockDisconnected), &block)
+	assert.Equal(t, hex.EncodeToString(mined.Hash), block.Hash)
+
+	var removedPayment PaymentEvent
+	readWSEvent(t, conn, TopicAddress, wsEventRemoved, &removedPayment)
+	assert.Equal(t, PaymentEvent{Address: receiverAddress, Txid: coinbaseID, Value: 10}, removedPayment)
+
+	// The removed payment is not watched anymore
+	next, err = bc.MineBlock([]*coin.Transaction{coin.NewCoinbaseTX(address, "", 10)})
+	require.NoError(t, err)
+	readWSEvent(t, conn, TopicBlocks, string(EventBlockConnected), &block)
+	assert.Equal(t, hex.EncodeToString(next.Hash), block.Hash)
+
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(100*time.Millisecond)))
+	_, _, err = conn.ReadMessage()
+	assert.Error(t, err)
+}
+
+// mineBlockOn mines a block with the transactions on top of the parent and
+// adds it to the chain. The parent does not have to be the tip.
+func mineBlockOn(t *testing.T, bc *coin.Blockchain, parent *coin.Block, transactions []*coin.Transaction) *coin.Block {
+	block := coin.NewBlockTemplate(transactions, parent.Hash, parent.Height+1, parent.Bits)
+	if block.Timestamp <= parent.Timestamp {
+		block.Timestamp = parent.Timestamp + 1
+	}
+	require.NoError(t, coin.NewMiner().Mine(context.Background(), block))
+	require.NoError(t, bc.AddBlock(block))
+
+	return block
+}
+
+func TestWebSocketPaymentMinedOnBothBranches(t *testing.T) {
+	wallet, err := coin.NewWallet()
+	require.NoError(t, err)
+	address := string(wallet.GetAddress(testParams))
+
+	chains, _ := openChains(t, address, 23063)
+	bc := chains[0]
+	node := NewNode(bc, Config{Address: "localhost:23063", WSAddress: "localhost:23062"})
+	require.NoError(t, node.Start())
+	defer node.Stop()
+
+	// Mine until the genesis reward is mature
+	var fork *coin.Block
+	for i := 0; i < testParams.CoinbaseMaturity; i++ {
+		fork, err = bc.MineBlock([]*coin.Transaction{coin.NewCoinbaseTX(address, "", 10)})
+		require.NoError(t, err)
+	}
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws://localhost:23062/", nil)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	receiver, err := coin.NewWallet()
+	require.NoError(t, err)
+	receiverAddress := string(receiver.GetAddress(testParams))
+	reply := wsRequest(t, conn, &WSRequest{ID: 1, Method: "subscribe", Topic: TopicAddress, Address: receiverAddress, Confirmations: 3})
+	assert.Equal(t, "subscribed", reply.Result)
+
+	UTXOSet := coin.UTXOSet{Blockchain: bc}
+	tx, err := coin.NewUTXOTransaction(wallet, receiverAddress, 3, 1, &UTXOSet)
+	require.NoError(t, err)
+	txid := hex.EncodeToString(tx.ID)
+	require.NoError(t, node.SubmitTransaction(tx))
+
+	var payment PaymentEvent
+	readWSEvent(t, conn, TopicAddress, wsEventPayment, &payment)
+	assert.Equal(t, txid, payment.Txid)
+
+	_, err = bc.MineBlock([]*coin.Transaction{coin.NewCoinbaseTX(address, "", 10), tx})
+	require.NoError(t, err)
+
+	// The heavier branch mines the payment as well, so it stays watched with
+	// the confirmations of its new block
+	side := mineBlockOn(t, bc, fork, []*coin.Transaction{coin.NewCoinbaseTX(address, "", 10), tx})
+	mineBlockOn(t, bc, side, []*coin.Transaction{coin.NewCoinbaseTX(address, "", 10)})
+
+	var reorged PaymentEvent
+	readWSEvent(t, conn, TopicAddress, wsEventReorged, &reorged)
+	assert.Equal(t, PaymentEvent{
+		Address:       receiverAddress,
+		Txid:          txid,
+		Value:         3,
+		Confirmations: 2,
+		BlockHash:     hex.EncodeToString(side.Hash),
+		Height:        side.Height,
+	}, reorged)
+
+	_, err = bc.MineBlock([]*coin.Transaction{coin.NewCoinbaseTX(address, "", 10)})
+	require.NoError(t, err)
+
+	var confirmed PaymentEvent
+	readWSEvent(t, conn, TopicAddress, wsEventConfirmed, &confirmed)
+	assert.Equal(t, txid, confirmed.Txid)
+	assert.Equal(t, 3, confirmed.Confirmations)
+	assert.Equal(t, hex.EncodeToString(side.Hash), confirmed.BlockHash)
+}
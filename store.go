@@ -0,0 +1,66 @@
+package coin
+
+import "errors"
+
+var (
+	// ErrBucketNotFound is returned when a bucket that does not exist is
+	// deleted
+	ErrBucketNotFound = errors.New("bucket not found")
+
+	// ErrBucketExists is returned when a bucket that already exists is
+	// created
+	ErrBucketExists = errors.New("bucket already exists")
+)
+
+// ChainStore persists a blockchain as named buckets of sorted key/value
+// pairs. A chain uses these buckets:
+//   - blocks: serialized blocks by hash and the hash of the tip under "l"
+//   - headers, chainwork, invalid: the header index
+//   - chainstate, undo: the UTXO set and the outputs spent by each block
+//   - heights: the hashes of the main chain by height
+//   - txindex, addrhistory, addrutxo: the optional indexes
+//
+// All changes of an Update are applied atomically, so a block is always
+// stored together with the changes of the UTXO set and the indexes.
+type ChainStore interface {
+	// View calls fn with a read-only transaction
+	View(fn func(StoreTx) error) error
+
+	// Update calls fn with a read-write transaction. The changes are
+	// committed if fn returns nil and discarded otherwise.
+	Update(fn func(StoreTx) error) error
+
+	Close() error
+}
+
+// StoreTx is a transaction of a ChainStore. Slices returned by a transaction
+// are only valid until it ends and must not be modified.
+type StoreTx interface {
+	// Bucket returns nil if the bucket does not exist
+	Bucket(name []byte) StoreBucket
+	CreateBucket(name []byte) (StoreBucket, error)
+	DeleteBucket(name []byte) error
+}
+
+// StoreBucket is a collection of key/value pairs sorted by key
+type StoreBucket interface {
+	// Get returns nil if the key does not exist
+	Get(key []byte) []byte
+	Put(key, value []byte) error
+	Delete(key []byte) error
+
+	// ForEach calls fn for every pair in the order of the keys. The bucket
+	// must not be modified during the iteration.
+	ForEach(fn func(k, v []byte) error) error
+	Cursor() StoreCursor
+}
+
+// StoreCursor iterates over the pairs of a bucket in the order of the keys.
+// All methods return a nil key at the end of the bucket.
+type StoreCursor interface {
+	First() (key, value []byte)
+
+	// Seek moves to the first key that is equal to or greater than seek
+	Seek(seek []byte) (key, value []byte)
+	Next() (key, value []byte)
+}
@@ -0,0 +1,254 @@
+package coin
+
+import (
+	"errors"
+	"sort"
+	"sync"
+)
+
+var (
+	errStoreClosed = errors.New("store is closed")
+	errTxReadOnly  = errors.New("transaction is read-only")
+)
+
+// memStore is a ChainStore that keeps all buckets in memory. Committed
+// buckets are never modified. An Update copies a bucket on its first write,
+// so readers keep a consistent snapshot without blocking the writer.
+type memStore struct {
+	// updateMu serializes the read-write transactions
+	updateMu sync.Mutex
+
+	mu      sync.RWMutex
+	buckets map[string]map[string][]byte
+	closed  bool
+}
+
+// NewMemStore returns an empty ChainStore that lives in memory. It is meant
+// for tests and simulations, as every Update copies the buckets it writes.
+func NewMemStore() ChainStore {
+	return &memStore{buckets: make(map[string]map[string][]byte)}
+}
+
+func (s *memStore) snapshot() (map[string]map[string][]byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.closed {
+		return nil, errStoreClosed
+	}
+
+	return s.buckets, nil
+}
+
+func (s *memStore) View(fn func(StoreTx) error) error {
+	buckets, err := s.snapshot()
+	if err != nil {
+		return err
+	}
+
+	return fn(&memTx{buckets: buckets})
+}
+
+func (s *memStore) Update(fn func(StoreTx) error) error {
+	s.updateMu.Lock()
+	defer s.updateMu.Unlock()
+
+	buckets, err := s.snapshot()
+	if err != nil {
+		return err
+	}
+
+	tx := &memTx{
+		buckets:  make(map[string]map[string][]byte, len(buckets)),
+		writable: true,
+		copied:   make(map[string]bool),
+	}
+	for name, pairs := range buckets {
+		tx.buckets[name] = pairs
+	}
+
+	err = fn(tx)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return errStoreClosed
+	}
+	s.buckets = tx.buckets
+
+	return nil
+}
+
+func (s *memStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.closed = true
+	return nil
+}
+
+type memTx struct {
+	buckets  map[string]map[string][]byte
+	writable bool
+
+	// copied holds the buckets that belong to the transaction and may be
+	// written
+	copied map[string]bool
+}
+
+func (t *memTx) Bucket(name []byte) StoreBucket {
+	if t.buckets[string(name)] == nil {
+		return nil
+	}
+
+	return &memBucket{tx: t, name: string(name)}
+}
+
+func (t *memTx) CreateBucket(name []byte) (StoreBucket, error) {
+	if !t.writable {
+		return nil, errTxReadOnly
+	}
+	if t.buckets[string(name)] != nil {
+		return nil, ErrBucketExists
+	}
+
+	t.buckets[string(name)] = make(map[string][]byte)
+	t.copied[string(name)] = true
+
+	return &memBucket{tx: t, name: string(name)}, nil
+}
+
+func (t *memTx) DeleteBucket(name []byte) error {
+	if !t.writable {
+		return errTxReadOnly
+	}
+	if t.buckets[string(name)] == nil {
+		return ErrBucketNotFound
+	}
+
+	delete(t.buckets, string(name))
+	delete(t.copied, string(name))
+
+	return nil
+}
+
+type memBucket struct {
+	tx   *memTx
+	name string
+}
+
+func (b *memBucket) pairs() map[string][]byte {
+	return b.tx.buckets[b.name]
+}
+
+// writablePairs returns the pairs of the bucket after copying them on the
+// first write of the transaction
+func (b *memBucket) writablePairs() (map[string][]byte, error) {
+	if !b.tx.writable {
+		return nil, errTxReadOnly
+	}
+
+	pairs := b.pairs()
+	if pairs == nil {
+		return nil, ErrBucketNotFound
+	}
+
+	if !b.tx.copied[b.name] {
+		clone := make(map[string][]byte, len(pairs))
+		for k, v := range pairs {
+			clone[k] = v
+		}
+
+		pairs = clone
+		b.tx.buckets[b.name] = clone
+		b.tx.copied[b.name] = true
+	}
+
+	return pairs, nil
+}
+
+func (b *memBucket) Get(key []byte) []byte {
+	return b.pairs()[string(key)]
+}
+
+func (b *memBucket) Put(key, value []byte) error {
+	pairs, err := b.writablePairs()
+	if err != nil {
+		return err
+	}
+
+	// Like Bolt, an empty value is stored as a non-nil slice
+	pairs[string(key)] = append([]byte{}, value...)
+	return nil
+}
+
+func (b *memBucket) Delete(key []byte) error {
+	pairs, err := b.writablePairs()
+	if err != nil {
+		return err
+	}
+
+	delete(pairs, string(key))
+	return nil
+}
+
+func (b *memBucket) ForEach(fn func(k, v []byte) error) error {
+	c := b.Cursor()
+	for k, v := c.First(); k != nil; k, v = c.Next() {
+		err := fn(k, v)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (b *memBucket) Cursor() StoreCursor {
+	pairs := b.pairs()
+	keys := make([]string, 0, len(pairs))
+	for k := range pairs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	return &memCursor{pairs: pairs, keys: keys}
+}
+
+// memCursor iterates over the keys a bucket had when the cursor was created
+type memCursor struct {
+	pairs map[string][]byte
+	keys  []string
+	pos   int
+}
+
+func (c *memCursor) current() ([]byte, []byte) {
+	if c.pos >= len(c.keys) {
+		return nil, nil
+	}
+
+	k := c.keys[c.pos]
+	return []byte(k), c.pairs[k]
+}
+
+func (c *memCursor) First() ([]byte, []byte) {
+	c.pos = 0
+	return c.current()
+}
+
+func (c *memCursor) Seek(seek []byte) ([]byte, []byte) {
+	c.pos = sort.SearchStrings(c.keys, string(seek))
+	return c.current()
+}
+
+func (c *memCursor) Next() ([]byte, []byte) {
+	if c.pos < len(c.keys) {
+		c.pos++
+	}
+
+	return c.current()
+}
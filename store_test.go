@@ -0,0 +1,83 @@
+package coin
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testChainStore(t *testing.T, store ChainStore) {
+	name := []byte("bucket")
+
+	err := store.Update(func(tx StoreTx) error {
+		b, err := tx.CreateBucket(name)
+		require.NoError(t, err)
+
+		for _, k := range []string{"b", "d", "a", "c"} {
+			require.NoError(t, b.Put([]byte(k), []byte("v"+k)))
+		}
+		require.NoError(t, b.Delete([]byte("d")))
+
+		_, err = tx.CreateBucket(name)
+		assert.Equal(t, ErrBucketExists, err)
+		assert.Equal(t, ErrBucketNotFound, tx.DeleteBucket([]byte("missing")))
+
+		return nil
+	})
+	require.NoError(t, err)
+
+	// A failed update is rolled back
+	failed := errors.New("failed")
+	err = store.Update(func(tx StoreTx) error {
+		require.NoError(t, tx.Bucket(name).Put([]byte("e"), []byte("ve")))
+		return failed
+	})
+	assert.Equal(t, failed, err)
+
+	err = store.View(func(tx StoreTx) error {
+		assert.Nil(t, tx.Bucket([]byte("missing")))
+
+		b := tx.Bucket(name)
+		assert.Equal(t, []byte("va"), b.Get([]byte("a")))
+		assert.Nil(t, b.Get([]byte("e")))
+
+		var keys []string
+		require.NoError(t, b.ForEach(func(k, v []byte) error {
+			keys = append(keys, string(k))
+			return nil
+		}))
+		assert.Equal(t, []string{"a", "b", "c"}, keys)
+
+		c := b.Cursor()
+		k, v := c.Seek([]byte("bb"))
+		assert.Equal(t, "c", string(k))
+		assert.Equal(t, "vc", string(v))
+		k, _ = c.Next()
+		assert.Nil(t, k)
+
+		assert.Error(t, b.Put([]byte("x"), nil))
+		return nil
+	})
+	require.NoError(t, err)
+
+	require.NoError(t, store.Close())
+}
+
+func TestBoltStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "store")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	store, err := NewBoltStore(filepath.Join(dir, "chain.db"))
+	require.NoError(t, err)
+	testChainStore(t, store)
+}
+
+func TestMemStore(t *testing.T) {
+	testChainStore(t, NewMemStore())
+}
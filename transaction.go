@@ -1,19 +1,20 @@
 package coin
 
 import (
-	"bytes"
 	"crypto/ecdsa"
 	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/sha256"
-	"encoding/gob"
 	"encoding/hex"
 	"fmt"
 	"log"
 	"math/big"
+	"strings"
+
+	"github.com/thesoenke/go-coin/wire"
 )
 
-const subsidy = 10
+const txVersion = 1
 
 // Transaction represents a Blockchain transaction
 type Transaction struct {
@@ -27,32 +28,72 @@ func (tx Transaction) IsCoinbase() bool {
 	return len(tx.Vin) == 1 && len(tx.Vin[0].Txid) == 0 && tx.Vin[0].Vout == -1
 }
 
-// Serialize returns a serialized Transaction
+// Serialize returns a serialized Transaction. The ID is not serialized as it
+// is derived from the other fields.
 func (tx Transaction) Serialize() []byte {
-	var encoded bytes.Buffer
+	w := &wire.Writer{}
+	tx.serialize(w)
+	return w.Bytes()
+}
 
-	enc := gob.NewEncoder(&encoded)
-	err := enc.Encode(tx)
-	if err != nil {
-		log.Panic(err)
+func (tx *Transaction) serialize(w *wire.Writer) {
+	w.WriteUint8(txVersion)
+
+	w.WriteUint32(uint32(len(tx.Vin)))
+	for _, vin := range tx.Vin {
+		vin.serialize(w)
+	}
+
+	w.WriteUint32(uint32(len(tx.Vout)))
+	for _, vout := range tx.Vout {
+		vout.serialize(w)
+	}
+}
+
+func readTransaction(r *wire.Reader) Transaction {
+	var tx Transaction
+
+	r.ReadVersion(txVersion)
+
+	inputs := r.ReadCount()
+	for i := 0; i < inputs; i++ {
+		tx.Vin = append(tx.Vin, readTXInput(r))
+	}
+
+	outputs := r.ReadCount()
+	for i := 0; i < outputs; i++ {
+		tx.Vout = append(tx.Vout, readTXOutput(r))
+	}
+
+	if r.Err() == nil {
+		tx.ID = tx.Hash()
 	}
 
-	return encoded.Bytes()
+	return tx
 }
 
-// NewCoinbaseTX creates a new coinbase transaction
-func NewCoinbaseTX(to, data string) *Transaction {
+// NewCoinbaseTX creates a new coinbase transaction that pays value to the
+// address. The coinbase of a block may pay at most the block subsidy plus the
+// fees of the block.
+func NewCoinbaseTX(to, data string, value int) *Transaction {
 	if data == "" {
-		data = fmt.Sprintf("Reward to '%s'", to)
+		// Random data keeps coinbases to the same address unique
+		randData := make([]byte, 20)
+		_, err := rand.Read(randData)
+		if err != nil {
+			log.Panic(err)
+		}
+
+		data = fmt.Sprintf("%x", randData)
 	}
 
 	txin := TXInput{
-		Txid:      []byte{},
+		Txid:      nil,
 		Vout:      -1,
 		Signature: nil,
 		PubKey:    []byte(data),
 	}
-	txout := NewTXOutput(subsidy, to)
+	txout := NewTXOutput(value, to)
 	tx := Transaction{
 		ID:   nil,
 		Vin:  []TXInput{txin},
@@ -69,25 +110,34 @@ func (tx *Transaction) Sign(privKey ecdsa.PrivateKey, prevTXs map[string]Transac
 		return nil
 	}
 
+	var spent []TXOutput
 	for _, vin := range tx.Vin {
-		if prevTXs[hex.EncodeToString(vin.Txid)].ID == nil {
+		prevTx := prevTXs[hex.EncodeToString(vin.Txid)]
+		if prevTx.ID == nil || vin.Vout < 0 || vin.Vout >= len(prevTx.Vout) {
 			return fmt.Errorf("previous transaction is not correct")
 		}
+
+		spent = append(spent, prevTx.Vout[vin.Vout])
 	}
 
+	return tx.signInputs(privKey, spent)
+}
+
+// signInputs signs each input for the output it spends. spent holds the
+// spent output for every input in order.
+func (tx *Transaction) signInputs(privKey ecdsa.PrivateKey, spent []TXOutput) error {
 	txCopy := tx.TrimmedCopy()
-	for inID, vin := range txCopy.Vin {
-		prevTx := prevTXs[hex.EncodeToString(vin.Txid)]
+	for inID := range txCopy.Vin {
 		txCopy.Vin[inID].Signature = nil
-		txCopy.Vin[inID].PubKey = prevTx.Vout[vin.Vout].PubKeyHash
+		txCopy.Vin[inID].PubKey = spent[inID].PubKeyHash
 
-		dataToSign := fmt.Sprintf("%x\n", txCopy)
-		r, s, err := ecdsa.Sign(rand.Reader, &privKey, []byte(dataToSign))
+		dataToSign := txCopy.sigHash()
+		r, s, err := ecdsa.Sign(rand.Reader, &privKey, dataToSign)
 		if err != nil {
 			return err
 		}
 
-		signature := append(r.Bytes(), s.Bytes()...)
+		signature := append(padBytes(r, 32), padBytes(s, 32)...)
 		tx.Vin[inID].Signature = signature
 		txCopy.Vin[inID].PubKey = nil
 	}
@@ -118,20 +168,33 @@ func (tx *Transaction) Verify(prevTXs map[string]Transaction) bool {
 		return true
 	}
 
+	var spent []TXOutput
 	for _, vin := range tx.Vin {
-		if prevTXs[hex.EncodeToString(vin.Txid)].ID == nil {
-			log.Printf("error: previous transaction of '%s' is not correct\n", vin.Txid)
+		prevTx := prevTXs[hex.EncodeToString(vin.Txid)]
+		if prevTx.ID == nil || vin.Vout < 0 || vin.Vout >= len(prevTx.Vout) {
+			log.Printf("error: previous transaction of '%x' is not correct\n", vin.Txid)
 			return false
 		}
+
+		spent = append(spent, prevTx.Vout[vin.Vout])
 	}
 
+	return tx.verifyInputs(spent)
+}
+
+// verifyInputs verifies the signature of each input against the output it
+// spends. spent holds the spent output for every input in order.
+func (tx *Transaction) verifyInputs(spent []TXOutput) bool {
 	txCopy := tx.TrimmedCopy()
 	curve := elliptic.P256()
 
 	for inID, vin := range tx.Vin {
-		prevTx := prevTXs[hex.EncodeToString(vin.Txid)]
+		if !vin.UsesKey(spent[inID].PubKeyHash) {
+			return false
+		}
+
 		txCopy.Vin[inID].Signature = nil
-		txCopy.Vin[inID].PubKey = prevTx.Vout[vin.Vout].PubKeyHash
+		txCopy.Vin[inID].PubKey = spent[inID].PubKeyHash
 
 		r := big.Int{}
 		s := big.Int{}
@@ -145,10 +208,10 @@ func (tx *Transaction) Verify(prevTXs map[string]Transaction) bool {
 		x.SetBytes(vin.PubKey[:(keyLen / 2)])
 		y.SetBytes(vin.PubKey[(keyLen / 2):])
 
-		dataToVerify := fmt.Sprintf("%x\n", txCopy)
+		dataToVerify := txCopy.sigHash()
 
 		rawPubKey := ecdsa.PublicKey{Curve: curve, X: &x, Y: &y}
-		if ecdsa.Verify(&rawPubKey, []byte(dataToVerify), &r, &s) == false {
+		if ecdsa.Verify(&rawPubKey, dataToVerify, &r, &s) == false {
 			return false
 		}
 		txCopy.Vin[inID].PubKey = nil
@@ -157,64 +220,127 @@ func (tx *Transaction) Verify(prevTXs map[string]Transaction) bool {
 	return true
 }
 
-// NewUTXOTransaction creates a new transaction
-func NewUTXOTransaction(wallet *Wallet, to string, amount int, UTXOSet *UTXOSet) (*Transaction, error) {
-	var inputs []TXInput
-	var outputs []TXOutput
+// NewUTXOTransaction creates a new transaction that pays amount to the
+// receiver. The fee is left to the miner by not returning it as change.
+func NewUTXOTransaction(wallet *Wallet, to string, amount, fee int, UTXOSet *UTXOSet) (*Transaction, error) {
+	params := UTXOSet.Blockchain.params
+	unspent, err := UTXOSet.FindUnspentOutputs(HashPubKey(wallet.PublicKey))
+	if err != nil {
+		return nil, err
+	}
 
-	pubKeyHash := HashPubKey(wallet.PublicKey)
-	acc, validOutputs, err := UTXOSet.FindSpendableOutputs(pubKeyHash, amount)
+	height, err := UTXOSet.BestHeight()
 	if err != nil {
 		return nil, err
 	}
 
-	if acc < amount {
-		return nil, fmt.Errorf("not enough funds in '%s'", wallet.GetAddress())
+	var spendable []UnspentOutput
+	for _, out := range unspent {
+		if out.IsMature(height+1, params) {
+			spendable = append(spendable, out)
+		}
+	}
+
+	return NewTransactionFromOutputs(wallet, to, amount, fee, spendable, params)
+}
+
+// NewTransactionFromOutputs creates a new transaction that pays amount to the
+// receiver from the outputs of the wallet in spendable. Outputs are used in
+// order until they cover the amount and the fee. The caller has to make sure
+// that the outputs are unspent and mature.
+func NewTransactionFromOutputs(wallet *Wallet, to string, amount, fee int, spendable []UnspentOutput, params *ChainParams) (*Transaction, error) {
+	var inputs []TXInput
+	var outputs []TXOutput
+	var spent []TXOutput
+
+	if fee < 0 {
+		return nil, fmt.Errorf("fee must not be negative")
+	}
+
+	if !ValidateAddress(to, params) {
+		return nil, fmt.Errorf("address '%s' is not valid on the %s network", to, params.Name)
 	}
 
 	// Build a list of inputs
-	for txid, outs := range validOutputs {
-		txID, err := hex.DecodeString(txid)
-		if err != nil {
-			return nil, err
+	acc := 0
+	for _, out := range spendable {
+		if acc >= amount+fee {
+			break
 		}
 
-		for _, out := range outs {
-			input := TXInput{txID, out, nil, wallet.PublicKey}
-			inputs = append(inputs, input)
-		}
+		acc += out.Output.Value
+		inputs = append(inputs, TXInput{out.Txid, out.Vout, nil, wallet.PublicKey})
+		spent = append(spent, out.Output)
+	}
+
+	if acc < amount+fee {
+		return nil, fmt.Errorf("not enough funds in '%s'", wallet.GetAddress(params))
 	}
 
 	// Build a list of outputs
-	from := fmt.Sprintf("%s", wallet.GetAddress())
+	from := fmt.Sprintf("%s", wallet.GetAddress(params))
 	outputs = append(outputs, *NewTXOutput(amount, to))
-	if acc > amount {
-		outputs = append(outputs, *NewTXOutput(acc-amount, from)) // a change
+	if acc > amount+fee {
+		outputs = append(outputs, *NewTXOutput(acc-amount-fee, from)) // a change
 	}
 
 	tx := Transaction{nil, inputs, outputs}
 	tx.ID = tx.Hash()
-	err = UTXOSet.Blockchain.SignTransaction(&tx, wallet.PrivateKey)
+	err := tx.signInputs(wallet.PrivateKey, spent)
 	return &tx, err
 }
 
-// Hash returns the hash of the Transaction
+// Hash returns the hash of the Transaction. Signatures are not part of the
+// hash as they sign the data the hash is derived from.
 func (tx *Transaction) Hash() []byte {
 	var hash [32]byte
 
 	txCopy := *tx
 	txCopy.ID = []byte{}
+	txCopy.Vin = make([]TXInput, len(tx.Vin))
+	for i, vin := range tx.Vin {
+		txCopy.Vin[i] = TXInput{vin.Txid, vin.Vout, nil, vin.PubKey}
+	}
 
 	hash = sha256.Sum256(txCopy.Serialize())
 
 	return hash[:]
 }
 
+// sigHash returns the hash an input signs. It is called on a trimmed copy
+// in which only the PubKey of the signed input is set to the PubKeyHash of
+// the output it spends.
+func (tx *Transaction) sigHash() []byte {
+	hash := sha256.Sum256(tx.Serialize())
+	return hash[:]
+}
+
+// String returns a human-readable representation of a transaction
+func (tx Transaction) String() string {
+	var lines []string
+
+	lines = append(lines, fmt.Sprintf("--- Transaction %x:", tx.ID))
+
+	for i, input := range tx.Vin {
+		lines = append(lines, fmt.Sprintf("     Input %d:", i))
+		lines = append(lines, fmt.Sprintf("       TXID:      %x", input.Txid))
+		lines = append(lines, fmt.Sprintf("       Out:       %d", input.Vout))
+		lines = append(lines, fmt.Sprintf("       Signature: %x", input.Signature))
+		lines = append(lines, fmt.Sprintf("       PubKey:    %x", input.PubKey))
+	}
+
+	for i, output := range tx.Vout {
+		lines = append(lines, fmt.Sprintf("     Output %d:", i))
+		lines = append(lines, fmt.Sprintf("       Value:  %d", output.Value))
+		lines = append(lines, fmt.Sprintf("       Script: %x", output.PubKeyHash))
+	}
+
+	return strings.Join(lines, "\n")
+}
+
 // DeserializeTransaction deserializes a transaction
 func DeserializeTransaction(data []byte) (Transaction, error) {
-	var transaction Transaction
-
-	decoder := gob.NewDecoder(bytes.NewReader(data))
-	err := decoder.Decode(&transaction)
-	return transaction, err
+	r := wire.NewReader(data)
+	transaction := readTransaction(r)
+	return transaction, r.Finish()
 }
@@ -1,7 +1,10 @@
 package coin
 
-// TXInput represents a transaction input
-import "bytes"
+import (
+	"bytes"
+
+	"github.com/thesoenke/go-coin/wire"
+)
 
 // TXInput represents a transaction input
 type TXInput struct {
@@ -16,3 +19,19 @@ func (in *TXInput) UsesKey(pubKeyHash []byte) bool {
 	lockingHash := HashPubKey(in.PubKey)
 	return bytes.Compare(lockingHash, pubKeyHash) == 0
 }
+
+func (in *TXInput) serialize(w *wire.Writer) {
+	w.WriteVarBytes(in.Txid)
+	w.WriteInt32(int32(in.Vout))
+	w.WriteVarBytes(in.Signature)
+	w.WriteVarBytes(in.PubKey)
+}
+
+func readTXInput(r *wire.Reader) TXInput {
+	return TXInput{
+		Txid:      r.ReadVarBytes(),
+		Vout:      int(r.ReadInt32()),
+		Signature: r.ReadVarBytes(),
+		PubKey:    r.ReadVarBytes(),
+	}
+}
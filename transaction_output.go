@@ -2,10 +2,14 @@ package coin
 
 import (
 	"bytes"
-	"encoding/gob"
 	"log"
+	"sort"
+
+	"github.com/thesoenke/go-coin/wire"
 )
 
+const outputsVersion = 2
+
 // TXOutput represents a transaction output
 type TXOutput struct {
 	Value      int
@@ -33,33 +37,93 @@ func NewTXOutput(value int, address string) *TXOutput {
 	return txo
 }
 
-// TXOutputs collects TXOutput
+// TXOutputs collects the unspent outputs of a transaction keyed by their
+// index in the transaction. Height is the height of the block that contains
+// the transaction.
 type TXOutputs struct {
-	Outputs []TXOutput
+	Height   int
+	Coinbase bool
+	Outputs  map[int]TXOutput
+}
+
+// entry returns the output with the index together with the block it was
+// created in
+func (outs TXOutputs) entry(outIdx int) (*UtxoEntry, bool) {
+	out, ok := outs.Outputs[outIdx]
+	if !ok {
+		return nil, false
+	}
+
+	return &UtxoEntry{Output: out, Height: outs.Height, Coinbase: outs.Coinbase}, true
+}
+
+// UtxoEntry is an unspent output together with the block it was created in
+type UtxoEntry struct {
+	Output   TXOutput
+	Height   int
+	Coinbase bool
+}
+
+// IsMature reports whether the output may be spent by a transaction in a
+// block at the height
+func (e *UtxoEntry) IsMature(height int, params *ChainParams) bool {
+	return !e.Coinbase || height-e.Height >= params.CoinbaseMaturity
 }
 
-// Serialize serializes TXOutputs
+// Serialize serializes TXOutputs ordered by output index
 func (outs TXOutputs) Serialize() []byte {
-	var buff bytes.Buffer
+	var indexes []int
+	for outIdx := range outs.Outputs {
+		indexes = append(indexes, outIdx)
+	}
+	sort.Ints(indexes)
 
-	enc := gob.NewEncoder(&buff)
-	err := enc.Encode(outs)
-	if err != nil {
-		log.Panic(err)
+	w := &wire.Writer{}
+	w.WriteUint8(outputsVersion)
+	w.WriteInt64(int64(outs.Height))
+	w.WriteBool(outs.Coinbase)
+	w.WriteUint32(uint32(len(indexes)))
+	for _, outIdx := range indexes {
+		out := outs.Outputs[outIdx]
+		w.WriteUint32(uint32(outIdx))
+		out.serialize(w)
 	}
 
-	return buff.Bytes()
+	return w.Bytes()
 }
 
 // DeserializeOutputs deserializes TXOutputs
 func DeserializeOutputs(data []byte) TXOutputs {
-	var outputs TXOutputs
+	outputs := TXOutputs{Outputs: make(map[int]TXOutput)}
+
+	r := wire.NewReader(data)
+	r.ReadVersion(outputsVersion)
+	outputs.Height = int(r.ReadInt64())
+	outputs.Coinbase = r.ReadBool()
+	count := r.ReadCount()
+	for i := 0; i < count; i++ {
+		outIdx := int(r.ReadUint32())
+		outputs.Outputs[outIdx] = readTXOutput(r)
+	}
 
-	dec := gob.NewDecoder(bytes.NewReader(data))
-	err := dec.Decode(&outputs)
+	err := r.Finish()
 	if err != nil {
 		log.Panic(err)
 	}
 
 	return outputs
 }
+
+func (out *TXOutput) serialize(w *wire.Writer) {
+	w.WriteInt64(int64(out.Value))
+	w.WriteVarBytes(out.PubKeyHash)
+	w.WriteString(out.Address)
+}
+
+func readTXOutput(r *wire.Reader) TXOutput {
+	return TXOutput{
+		Value:      int(r.ReadInt64()),
+		PubKeyHash: r.ReadVarBytes(),
+		Address:    r.ReadString(),
+	}
+}
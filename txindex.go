@@ -0,0 +1,145 @@
+package coin
+
+import (
+	"github.com/thesoenke/go-coin/wire"
+)
+
+const (
+	txIndexBucket       = "txindex"
+	txIndexEntryVersion = 1
+)
+
+// txIndexEntry locates a transaction of the main chain
+type txIndexEntry struct {
+	BlockHash []byte
+	Position  int
+}
+
+func (e *txIndexEntry) serialize() []byte {
+	w := &wire.Writer{}
+	w.WriteUint8(txIndexEntryVersion)
+	w.WriteVarBytes(e.BlockHash)
+	w.WriteUint32(uint32(e.Position))
+
+	return w.Bytes()
+}
+
+func deserializeTxIndexEntry(d []byte) (*txIndexEntry, error) {
+	var entry txIndexEntry
+
+	r := wire.NewReader(d)
+	r.ReadVersion(txIndexEntryVersion)
+	entry.BlockHash = r.ReadVarBytes()
+	entry.Position = int(r.ReadUint32())
+
+	return &entry, r.Finish()
+}
+
+// HasTxIndex reports whether the transaction index is enabled
+func (bc *Blockchain) HasTxIndex() (bool, error) {
+	enabled := false
+
+	err := bc.DB.View(func(tx StoreTx) error {
+		enabled = tx.Bucket([]byte(txIndexBucket)) != nil
+		return nil
+	})
+
+	return enabled, err
+}
+
+// ReindexTransactions enables the transaction index and rebuilds it from the
+// blocks of the main chain. Once enabled, the index is kept in sync when
+// blocks are connected and disconnected.
+func (bc *Blockchain) ReindexTransactions() error {
+	return bc.DB.Update(func(tx StoreTx) error {
+		err := tx.DeleteBucket([]byte(txIndexBucket))
+		if err != nil && err != ErrBucketNotFound {
+			return err
+		}
+
+		_, err = tx.CreateBucket([]byte(txIndexBucket))
+		if err != nil {
+			return err
+		}
+
+		b := tx.Bucket([]byte(blocksBucket))
+		hash := b.Get([]byte("l"))
+		for len(hash) > 0 {
+			block, err := getBlock(b, hash)
+			if err != nil {
+				return err
+			}
+
+			err = connectTxIndex(tx, block)
+			if err != nil {
+				return err
+			}
+
+			hash = block.PrevBlockHash
+		}
+
+		return nil
+	})
+}
+
+// fetchIndexedTransaction looks up a transaction in the transaction index.
+// It returns nil if the transaction is not part of the main chain.
+func fetchIndexedTransaction(tx StoreTx, txid []byte) (*Transaction, error) {
+	data := tx.Bucket([]byte(txIndexBucket)).Get(txid)
+	if data == nil {
+		return nil, nil
+	}
+
+	entry, err := deserializeTxIndexEntry(data)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := getBlock(tx.Bucket([]byte(blocksBucket)), entry.BlockHash)
+	if err != nil {
+		return nil, err
+	}
+
+	if entry.Position >= len(block.Transactions) {
+		return nil, nil
+	}
+
+	return block.Transactions[entry.Position], nil
+}
+
+// connectTxIndex adds the transactions of a block that was connected to the
+// main chain to the transaction index, if it is enabled
+func connectTxIndex(tx StoreTx, block *Block) error {
+	idx := tx.Bucket([]byte(txIndexBucket))
+	if idx == nil {
+		return nil
+	}
+
+	for pos, t := range block.Transactions {
+		entry := txIndexEntry{BlockHash: block.Hash, Position: pos}
+		err := idx.Put(t.ID, entry.serialize())
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// disconnectTxIndex removes the transactions of a block that was
+// disconnected from the main chain from the transaction index
+func disconnectTxIndex(tx StoreTx, block *Block) error {
+	idx := tx.Bucket([]byte(txIndexBucket))
+	if idx == nil {
+		return nil
+	}
+
+	for _, t := range block.Transactions {
+		err := idx.Delete(t.ID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,78 @@
+package coin
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/thesoenke/go-coin/wire"
+)
+
+const undoVersion = 2
+
+// SpentOutput is an output spent by a block together with its position and
+// the block it was created in
+type SpentOutput struct {
+	Txid     []byte
+	Vout     int
+	Output   TXOutput
+	Height   int
+	Coinbase bool
+}
+
+// BlockUndo collects the outputs spent by a block so that the block can be
+// disconnected from the UTXO set again
+type BlockUndo struct {
+	Spent []SpentOutput
+}
+
+// Serialize serializes BlockUndo
+func (undo BlockUndo) Serialize() []byte {
+	w := &wire.Writer{}
+	w.WriteUint8(undoVersion)
+	w.WriteUint32(uint32(len(undo.Spent)))
+	for _, spent := range undo.Spent {
+		w.WriteVarBytes(spent.Txid)
+		w.WriteInt32(int32(spent.Vout))
+		spent.Output.serialize(w)
+		w.WriteInt64(int64(spent.Height))
+		w.WriteBool(spent.Coinbase)
+	}
+
+	return w.Bytes()
+}
+
+// DeserializeBlockUndo deserializes BlockUndo
+func DeserializeBlockUndo(data []byte) BlockUndo {
+	var undo BlockUndo
+
+	r := wire.NewReader(data)
+	r.ReadVersion(undoVersion)
+	count := r.ReadCount()
+	for i := 0; i < count; i++ {
+		spent := SpentOutput{
+			Txid: r.ReadVarBytes(),
+			Vout: int(r.ReadInt32()),
+		}
+		spent.Output = readTXOutput(r)
+		spent.Height = int(r.ReadInt64())
+		spent.Coinbase = r.ReadBool()
+		undo.Spent = append(undo.Spent, spent)
+	}
+
+	err := r.Finish()
+	if err != nil {
+		log.Panic(err)
+	}
+
+	return undo
+}
+
+// getBlockUndo returns the undo data of a block of the main chain
+func getBlockUndo(tx StoreTx, block *Block) (BlockUndo, error) {
+	undoData := tx.Bucket([]byte(undoBucket)).Get(block.Hash)
+	if undoData == nil {
+		return BlockUndo{}, fmt.Errorf("no undo data for block %x", block.Hash)
+	}
+
+	return DeserializeBlockUndo(undoData), nil
+}
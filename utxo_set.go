@@ -1,51 +1,52 @@
 package coin
 
 import (
+	"bytes"
 	"encoding/hex"
-	"log"
-
-	"github.com/boltdb/bolt"
+	"fmt"
+	"sort"
 )
 
-const utxoBucket = "chainstate"
+const (
+	utxoBucket = "chainstate"
+	undoBucket = "undo"
+)
 
 type UTXOSet struct {
 	Blockchain *Blockchain
 }
 
-// Reindex all UTXOs in the DB
+// Reindex all UTXOs in the DB by connecting the blocks of the main chain
+// starting with the genesis block
 func (u UTXOSet) Reindex() error {
-	db := u.Blockchain.DB
-	bucketName := []byte(utxoBucket)
+	var blocks []*Block
+	bci := u.Blockchain.Iterator()
 
-	err := db.Update(func(tx *bolt.Tx) error {
-		err := tx.DeleteBucket(bucketName)
-		if err != nil && err != bolt.ErrBucketNotFound {
-			log.Panic(err)
-		}
+	for {
+		block := bci.Next()
+		blocks = append([]*Block{block}, blocks...)
 
-		_, err = tx.CreateBucket(bucketName)
-		if err != nil {
-			return err
+		if len(block.PrevBlockHash) == 0 {
+			break
 		}
-
-		return nil
-	})
-	if err != nil {
-		return err
 	}
 
-	UTXO := u.Blockchain.FindUTXO()
-	err = db.Update(func(tx *bolt.Tx) error {
-		b := tx.Bucket(bucketName)
+	db := u.Blockchain.DB
+	err := db.Update(func(tx StoreTx) error {
+		for _, bucketName := range []string{utxoBucket, undoBucket} {
+			err := tx.DeleteBucket([]byte(bucketName))
+			if err != nil && err != ErrBucketNotFound {
+				return err
+			}
 
-		for txID, outs := range UTXO {
-			key, err := hex.DecodeString(txID)
+			_, err = tx.CreateBucket([]byte(bucketName))
 			if err != nil {
 				return err
 			}
+		}
 
-			err = b.Put(key, outs.Serialize())
+		for _, block := range blocks {
+			err := connectUTXO(tx, block)
 			if err != nil {
 				return err
 			}
@@ -57,57 +58,133 @@ func (u UTXOSet) Reindex() error {
 	return err
 }
 
-// FindSpendableOutputs finds and returns unspent outputs to reference in inputs
+// FindSpendableOutputs finds and returns unspent outputs to reference in inputs.
+// Coinbase outputs that are not mature in the next block are skipped.
 func (u UTXOSet) FindSpendableOutputs(pubkeyHash []byte, amount int) (int, map[string][]int, error) {
 	unspentOutputs := make(map[string][]int)
 	accumulated := 0
 	db := u.Blockchain.DB
+	params := u.Blockchain.params
 
-	err := db.View(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte(utxoBucket))
-		c := b.Cursor()
+	err := db.View(func(tx StoreTx) error {
+		height, err := bestHeight(tx)
+		if err != nil {
+			return err
+		}
+		spendHeight := height + 1
 
-		for k, v := c.First(); k != nil; k, v = c.Next() {
-			txID := hex.EncodeToString(k)
-			outs := DeserializeOutputs(v)
+		return forEachAddressEntry(tx, pubkeyHash, func(txid []byte, vout int, entry *UtxoEntry) error {
+			if entry.IsMature(spendHeight, params) && accumulated < amount {
+				txID := hex.EncodeToString(txid)
+				accumulated += entry.Output.Value
+				unspentOutputs[txID] = append(unspentOutputs[txID], vout)
+			}
 
-			for outIdx, out := range outs.Outputs {
-				if out.IsLockedWithKey(pubkeyHash) && accumulated < amount {
-					accumulated += out.Value
-					unspentOutputs[txID] = append(unspentOutputs[txID], outIdx)
-				}
+			return nil
+		})
+	})
+
+	return accumulated, unspentOutputs, err
+}
+
+// Balance returns the value of the unspent outputs of a public key hash.
+// Coinbase outputs that are not mature in the next block are counted as
+// immature.
+func (u UTXOSet) Balance(pubKeyHash []byte) (spendable, immature int, err error) {
+	params := u.Blockchain.params
+
+	err = u.Blockchain.DB.View(func(tx StoreTx) error {
+		height, err := bestHeight(tx)
+		if err != nil {
+			return err
+		}
+		spendHeight := height + 1
+
+		return forEachAddressEntry(tx, pubKeyHash, func(txid []byte, vout int, entry *UtxoEntry) error {
+			if entry.IsMature(spendHeight, params) {
+				spendable += entry.Output.Value
+			} else {
+				immature += entry.Output.Value
 			}
+
+			return nil
+		})
+	})
+
+	return spendable, immature, err
+}
+
+// FetchEntry returns the unspent output vout of the transaction txid together
+// with the block it was created in. It returns nil if the output does not
+// exist or is spent.
+func (u UTXOSet) FetchEntry(txid []byte, vout int) (*UtxoEntry, error) {
+	var entry *UtxoEntry
+
+	err := u.Blockchain.DB.View(func(tx StoreTx) error {
+		outsBytes := tx.Bucket([]byte(utxoBucket)).Get(txid)
+		if outsBytes == nil {
+			return nil
 		}
 
+		entry, _ = DeserializeOutputs(outsBytes).entry(vout)
 		return nil
 	})
 
-	return accumulated, unspentOutputs, err
+	return entry, err
+}
+
+// BestHeight returns the height of the block the UTXO set is at
+func (u UTXOSet) BestHeight() (int, error) {
+	return u.Blockchain.GetBestHeight()
+}
+
+// UnspentOutput is an entry of the UTXO set together with its outpoint
+type UnspentOutput struct {
+	Txid []byte
+	Vout int
+	UtxoEntry
 }
 
 // FindUTXO finds UTXO for a public key hash
 func (u UTXOSet) FindUTXO(pubKeyHash []byte) ([]TXOutput, error) {
+	unspent, err := u.FindUnspentOutputs(pubKeyHash)
+	if err != nil {
+		return nil, err
+	}
+
 	var UTXOs []TXOutput
-	db := u.Blockchain.DB
+	for _, out := range unspent {
+		UTXOs = append(UTXOs, out.Output)
+	}
 
-	err := db.View(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte(utxoBucket))
-		c := b.Cursor()
+	return UTXOs, nil
+}
 
-		for k, v := c.First(); k != nil; k, v = c.Next() {
-			outs := DeserializeOutputs(v)
+// FindUnspentOutputs returns the unspent outputs of a public key hash ordered
+// by transaction ID and output index. The address index is used if it is
+// enabled.
+func (u UTXOSet) FindUnspentOutputs(pubKeyHash []byte) ([]UnspentOutput, error) {
+	var unspent []UnspentOutput
+
+	err := u.Blockchain.DB.View(func(tx StoreTx) error {
+		return forEachAddressEntry(tx, pubKeyHash, func(txid []byte, vout int, entry *UtxoEntry) error {
+			unspent = append(unspent, UnspentOutput{
+				Txid:      append([]byte{}, txid...),
+				Vout:      vout,
+				UtxoEntry: *entry,
+			})
+			return nil
+		})
+	})
 
-			for _, out := range outs.Outputs {
-				if out.IsLockedWithKey(pubKeyHash) {
-					UTXOs = append(UTXOs, out)
-				}
-			}
+	sort.Slice(unspent, func(i, j int) bool {
+		if c := bytes.Compare(unspent[i].Txid, unspent[j].Txid); c != 0 {
+			return c < 0
 		}
-
-		return nil
+		return unspent[i].Vout < unspent[j].Vout
 	})
 
-	return UTXOs, err
+	return unspent, err
 }
 
 // Update updates the UTXO set with transactions from the Block
@@ -115,52 +192,148 @@ func (u UTXOSet) FindUTXO(pubKeyHash []byte) ([]TXOutput, error) {
 func (u UTXOSet) Update(block *Block) error {
 	db := u.Blockchain.DB
 
-	err := db.Update(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte(utxoBucket))
+	err := db.Update(func(tx StoreTx) error {
+		return connectUTXO(tx, block)
+	})
 
-		for _, tx := range block.Transactions {
-			if tx.IsCoinbase() == false {
-				for _, vin := range tx.Vin {
-					updatedOuts := TXOutputs{}
-					outsBytes := b.Get(vin.Txid)
-					outs := DeserializeOutputs(outsBytes)
-
-					for outIdx, out := range outs.Outputs {
-						if outIdx != vin.Vout {
-							updatedOuts.Outputs = append(updatedOuts.Outputs, out)
-						}
-					}
+	return err
+}
 
-					if len(updatedOuts.Outputs) == 0 {
-						err := b.Delete(vin.Txid)
-						if err != nil {
-							return err
-						}
-					} else {
-						err := b.Put(vin.Txid, updatedOuts.Serialize())
-						if err != nil {
-							return err
-						}
-					}
+// Disconnect reverts Update for the block using its undo data. The Block is
+// considered to be the tip of a blockchain
+func (u UTXOSet) Disconnect(block *Block) error {
+	db := u.Blockchain.DB
+
+	err := db.Update(func(tx StoreTx) error {
+		return disconnectUTXO(tx, block)
+	})
+
+	return err
+}
 
+// connectUTXO spends the outputs referenced by the inputs of the block and
+// adds the outputs created by its transactions. The spent outputs are stored
+// as undo data of the block.
+func connectUTXO(tx StoreTx, block *Block) error {
+	b := tx.Bucket([]byte(utxoBucket))
+	undo := BlockUndo{}
+
+	for _, t := range block.Transactions {
+		if t.IsCoinbase() == false {
+			for _, vin := range t.Vin {
+				outsBytes := b.Get(vin.Txid)
+				if outsBytes == nil {
+					return fmt.Errorf("input %x:%d of transaction %x is not in the UTXO set", vin.Txid, vin.Vout, t.ID)
 				}
-			}
 
-			newOutputs := TXOutputs{}
-			for _, out := range tx.Vout {
-				newOutputs.Outputs = append(newOutputs.Outputs, out)
+				outs := DeserializeOutputs(outsBytes)
+				out, ok := outs.Outputs[vin.Vout]
+				if !ok {
+					return fmt.Errorf("input %x:%d of transaction %x is not in the UTXO set", vin.Txid, vin.Vout, t.ID)
+				}
+				delete(outs.Outputs, vin.Vout)
+				undo.Spent = append(undo.Spent, SpentOutput{
+					Txid:     vin.Txid,
+					Vout:     vin.Vout,
+					Output:   out,
+					Height:   outs.Height,
+					Coinbase: outs.Coinbase,
+				})
+
+				if len(outs.Outputs) == 0 {
+					err := b.Delete(vin.Txid)
+					if err != nil {
+						return err
+					}
+				} else {
+					err := b.Put(vin.Txid, outs.Serialize())
+					if err != nil {
+						return err
+					}
+				}
 			}
+		}
 
-			err := b.Put(tx.ID, newOutputs.Serialize())
-			if err != nil {
-				return err
-			}
+		newOutputs := TXOutputs{
+			Height:   block.Height,
+			Coinbase: t.IsCoinbase(),
+			Outputs:  make(map[int]TXOutput),
+		}
+		for outIdx, out := range t.Vout {
+			newOutputs.Outputs[outIdx] = out
 		}
 
-		return nil
+		err := b.Put(t.ID, newOutputs.Serialize())
+		if err != nil {
+			return err
+		}
+	}
+
+	u := tx.Bucket([]byte(undoBucket))
+	return u.Put(block.Hash, undo.Serialize())
+}
+
+// disconnectUTXO reverts connectUTXO for the block. The block has to be the
+// last block that was connected to the UTXO set.
+func disconnectUTXO(tx StoreTx, block *Block) error {
+	b := tx.Bucket([]byte(utxoBucket))
+	u := tx.Bucket([]byte(undoBucket))
+
+	undo, err := getBlockUndo(tx, block)
+	if err != nil {
+		return err
+	}
+
+	blockTXs := make(map[string]bool)
+	for _, t := range block.Transactions {
+		blockTXs[hex.EncodeToString(t.ID)] = true
+
+		err := b.Delete(t.ID)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, spent := range undo.Spent {
+		// Outputs created and spent within the block are gone with the block
+		if blockTXs[hex.EncodeToString(spent.Txid)] {
+			continue
+		}
+
+		outs := TXOutputs{
+			Height:   spent.Height,
+			Coinbase: spent.Coinbase,
+			Outputs:  make(map[int]TXOutput),
+		}
+		if outsBytes := b.Get(spent.Txid); outsBytes != nil {
+			outs = DeserializeOutputs(outsBytes)
+		}
+		outs.Outputs[spent.Vout] = spent.Output
+
+		err := b.Put(spent.Txid, outs.Serialize())
+		if err != nil {
+			return err
+		}
+	}
+
+	return u.Delete(block.Hash)
+}
+
+// TotalValue returns the sum of the values of all unspent outputs
+func (u UTXOSet) TotalValue() (int, error) {
+	total := 0
+
+	err := u.Blockchain.DB.View(func(tx StoreTx) error {
+		return tx.Bucket([]byte(utxoBucket)).ForEach(func(k, v []byte) error {
+			for _, out := range DeserializeOutputs(v).Outputs {
+				total += out.Value
+			}
+
+			return nil
+		})
 	})
 
-	return err
+	return total, err
 }
 
 // CountTransactions returns the number of transactions in the UTXO set
@@ -168,7 +341,7 @@ func (u UTXOSet) CountTransactions() (int, error) {
 	db := u.Blockchain.DB
 	counter := 0
 
-	err := db.View(func(tx *bolt.Tx) error {
+	err := db.View(func(tx StoreTx) error {
 		b := tx.Bucket([]byte(utxoBucket))
 		c := b.Cursor()
 
@@ -0,0 +1,96 @@
+package coin
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// utxoSnapshot returns the serialized entries of the UTXO set keyed by
+// transaction ID
+func utxoSnapshot(t *testing.T, bc *Blockchain) map[string]string {
+	snapshot := make(map[string]string)
+
+	err := bc.DB.View(func(tx StoreTx) error {
+		return tx.Bucket([]byte(utxoBucket)).ForEach(func(k, v []byte) error {
+			snapshot[string(k)] = string(v)
+			return nil
+		})
+	})
+	require.NoError(t, err)
+
+	return snapshot
+}
+
+func TestBlockUndoSerialization(t *testing.T) {
+	undo := BlockUndo{Spent: []SpentOutput{
+		{Txid: []byte{1, 2, 3}, Vout: 0, Output: TXOutput{Value: 10, PubKeyHash: []byte{4, 5}}, Height: 0, Coinbase: true},
+		{Txid: []byte{6}, Vout: 3, Output: TXOutput{Value: 7, PubKeyHash: []byte{8}, Address: "address"}, Height: 12},
+	}}
+
+	assert.Equal(t, undo, DeserializeBlockUndo(undo.Serialize()))
+	assert.Equal(t, BlockUndo{}, DeserializeBlockUndo(BlockUndo{}.Serialize()))
+}
+
+func TestUTXOSetDisconnect(t *testing.T) {
+	bc, wallet, params := newTestChain(t)
+	params.CoinbaseMaturity = 1
+	UTXOSet := UTXOSet{Blockchain: bc}
+
+	genesis, err := bc.GetBlockByHeight(0)
+	require.NoError(t, err)
+
+	receiver, err := NewWallet()
+	require.NoError(t, err)
+	spend, err := NewUTXOTransaction(wallet, string(receiver.GetAddress(params)), 3, 1, &UTXOSet)
+	require.NoError(t, err)
+
+	// The second transaction spends the change of the first one in the same
+	// block, so the change output is not restored by Disconnect
+	chained := &Transaction{
+		Vin:  []TXInput{{Txid: spend.ID, Vout: 1, PubKey: wallet.PublicKey}},
+		Vout: []TXOutput{*NewTXOutput(spend.Vout[1].Value, string(receiver.GetAddress(params)))},
+	}
+	chained.ID = chained.Hash()
+	require.NoError(t, chained.Sign(wallet.PrivateKey, map[string]Transaction{hex.EncodeToString(spend.ID): *spend}))
+
+	before := utxoSnapshot(t, bc)
+	coinbase := NewCoinbaseTX(string(wallet.GetAddress(params)), "", CalcBlockSubsidy(1, params)+1)
+	block, err := bc.MineBlock([]*Transaction{coinbase, spend, chained})
+	require.NoError(t, err)
+	after := utxoSnapshot(t, bc)
+	assert.NotEqual(t, before, after)
+
+	err = bc.DB.View(func(tx StoreTx) error {
+		undo, err := getBlockUndo(tx, block)
+		require.NoError(t, err)
+		require.Len(t, undo.Spent, 2)
+		assert.Equal(t, genesis.Transactions[0].ID, undo.Spent[0].Txid)
+		assert.Equal(t, 0, undo.Spent[0].Vout)
+		assert.Equal(t, genesis.Transactions[0].Vout[0], undo.Spent[0].Output)
+		assert.Equal(t, 0, undo.Spent[0].Height)
+		assert.True(t, undo.Spent[0].Coinbase)
+		assert.Equal(t, spend.ID, undo.Spent[1].Txid)
+		assert.Equal(t, 1, undo.Spent[1].Height)
+		assert.False(t, undo.Spent[1].Coinbase)
+		return nil
+	})
+	require.NoError(t, err)
+
+	// Disconnecting restores the UTXO set and removes the undo data
+	require.NoError(t, UTXOSet.Disconnect(block))
+	assert.Equal(t, before, utxoSnapshot(t, bc))
+	err = bc.DB.View(func(tx StoreTx) error {
+		_, err := getBlockUndo(tx, block)
+		assert.Error(t, err)
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Error(t, UTXOSet.Disconnect(block))
+
+	// Connecting the block again recreates the same state
+	require.NoError(t, UTXOSet.Update(block))
+	assert.Equal(t, after, utxoSnapshot(t, bc))
+}
@@ -0,0 +1,342 @@
+package coin
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"time"
+)
+
+const (
+	// medianTimeBlocks is the number of blocks whose median timestamp the
+	// timestamp of a child block has to exceed
+	medianTimeBlocks = 11
+
+	// maxTimeOffset is the number of seconds the timestamp of a block may be
+	// ahead of the local time
+	maxTimeOffset = 2 * 60 * 60
+)
+
+// outpoint identifies a transaction output
+type outpoint struct {
+	txid string
+	vout int
+}
+
+// CheckBlockSanity performs the checks on a block that do not depend on the
+// chain it is added to
+func CheckBlockSanity(block *Block, params *ChainParams) error {
+	if bytes.Compare(block.BlockHash(), block.Hash) != 0 {
+		return ruleError(ErrBadHash, fmt.Sprintf("block hash %x does not match its header", block.Hash))
+	}
+
+	err := CheckHeaderSanity(&block.BlockHeader, params)
+	if err != nil {
+		return err
+	}
+
+	if len(block.Transactions) == 0 {
+		return ruleError(ErrNoTransactions, "block does not contain any transactions")
+	}
+
+	size := len(block.Serialize())
+	if size > MaxBlockSize {
+		return ruleError(ErrBlockTooBig, fmt.Sprintf("block of %d bytes is larger than the maximum of %d", size, MaxBlockSize))
+	}
+
+	if bytes.Compare(block.HashTransactions(), block.MerkleRoot) != 0 {
+		return ruleError(ErrBadMerkleRoot, fmt.Sprintf("block merkle root %x does not match its transactions", block.MerkleRoot))
+	}
+
+	if !block.Transactions[0].IsCoinbase() {
+		return ruleError(ErrFirstTxNotCoinbase, "first transaction in block is not a coinbase")
+	}
+
+	spent := make(map[outpoint]bool)
+	for i, tx := range block.Transactions {
+		if i > 0 && tx.IsCoinbase() {
+			return ruleError(ErrMultipleCoinbases, fmt.Sprintf("block contains second coinbase at index %d", i))
+		}
+
+		err = CheckTransactionSanity(tx, params)
+		if err != nil {
+			return err
+		}
+
+		if tx.IsCoinbase() {
+			continue
+		}
+
+		for _, vin := range tx.Vin {
+			op := outpoint{hex.EncodeToString(vin.Txid), vin.Vout}
+			if spent[op] {
+				return ruleError(ErrDoubleSpend, fmt.Sprintf("output %x:%d is spent twice in the block", vin.Txid, vin.Vout))
+			}
+			spent[op] = true
+		}
+	}
+
+	return nil
+}
+
+// CheckHeaderSanity performs the checks on a block header that do not depend
+// on the chain it is added to
+func CheckHeaderSanity(header *BlockHeader, params *ChainParams) error {
+	if header.Version != blockHeaderVersion {
+		return ruleError(ErrBadBlockVersion, fmt.Sprintf("block version %d is not supported", header.Version))
+	}
+
+	pow := NewProofOfWork(header)
+	if pow.target.Sign() <= 0 || pow.target.Cmp(params.PowLimit) > 0 {
+		return ruleError(ErrBadBits, fmt.Sprintf("block target %064x is out of range", pow.target))
+	}
+
+	if !pow.Validate() {
+		return ruleError(ErrHighHash, fmt.Sprintf("block hash %x is higher than the target", pow.Hash()))
+	}
+
+	return nil
+}
+
+// CheckTransactionSanity performs the checks on a transaction that do not
+// depend on the outputs it spends
+func CheckTransactionSanity(tx *Transaction, params *ChainParams) error {
+	if bytes.Compare(tx.Hash(), tx.ID) != 0 {
+		return ruleError(ErrBadTxID, fmt.Sprintf("transaction ID %x does not match its hash", tx.ID))
+	}
+
+	if len(tx.Vin) == 0 {
+		return ruleError(ErrNoTxInputs, fmt.Sprintf("transaction %x has no inputs", tx.ID))
+	}
+
+	if len(tx.Vout) == 0 {
+		return ruleError(ErrNoTxOutputs, fmt.Sprintf("transaction %x has no outputs", tx.ID))
+	}
+
+	_, err := sumOutputs(tx.ID, tx.Vout, params)
+	return err
+}
+
+// CheckTransactionInputs checks a transaction against the outputs it spends.
+// spent holds the spent output for every input in order. It returns the fee
+// of the transaction, which is the value of the inputs that is not spent by
+// the outputs.
+func CheckTransactionInputs(tx *Transaction, spent []TXOutput, params *ChainParams) (int, error) {
+	inputValue, err := sumOutputs(tx.ID, spent, params)
+	if err != nil {
+		return 0, err
+	}
+
+	outputValue, err := sumOutputs(tx.ID, tx.Vout, params)
+	if err != nil {
+		return 0, err
+	}
+
+	if outputValue > inputValue {
+		return 0, ruleError(ErrSpendTooHigh, fmt.Sprintf("transaction %x spends %d but has inputs of %d", tx.ID, outputValue, inputValue))
+	}
+
+	if !tx.verifyInputs(spent) {
+		return 0, ruleError(ErrBadSignature, fmt.Sprintf("transaction %x has an invalid signature", tx.ID))
+	}
+
+	return inputValue - outputValue, nil
+}
+
+// sumOutputs returns the total value of the outputs of the transaction txid.
+// Every value and every partial sum has to be between zero and the maximum
+// supply, so the sum cannot overflow.
+func sumOutputs(txid []byte, outs []TXOutput, params *ChainParams) (int, error) {
+	maxValue := params.MaxSupply()
+	total := 0
+
+	for _, out := range outs {
+		if out.Value < 0 {
+			return 0, ruleError(ErrBadTxOutValue, fmt.Sprintf("transaction %x has a negative output value", txid))
+		}
+
+		if out.Value > maxValue {
+			return 0, ruleError(ErrBadTxOutValue, fmt.Sprintf("transaction %x has an output value of %d which is higher than the maximum supply of %d", txid, out.Value, maxValue))
+		}
+
+		if total > maxValue-out.Value {
+			return 0, ruleError(ErrBadTxOutValue, fmt.Sprintf("outputs of transaction %x add up to more than the maximum supply of %d", txid, maxValue))
+		}
+		total += out.Value
+	}
+
+	return total, nil
+}
+
+// checkBlockContext checks a block against its ancestors. The body of the
+// parent has to be known.
+func checkBlockContext(tx StoreTx, block *Block, params *ChainParams) error {
+	if tx.Bucket([]byte(blocksBucket)).Get(block.PrevBlockHash) == nil {
+		return ErrOrphanBlock
+	}
+
+	parent, err := checkHeaderContext(tx, &block.BlockHeader, params)
+	if err != nil {
+		return err
+	}
+
+	if block.Height != parent.Height+1 {
+		return ruleError(ErrBadHeight, fmt.Sprintf("block height %d does not follow parent height %d", block.Height, parent.Height))
+	}
+
+	return nil
+}
+
+// checkHeaderContext checks a header against the header index and returns
+// the parent of the header
+func checkHeaderContext(tx StoreTx, header *BlockHeader, params *ChainParams) (*headerNode, error) {
+	h := tx.Bucket([]byte(headersBucket))
+	if h.Get(header.PrevBlockHash) == nil {
+		return nil, ErrOrphanBlock
+	}
+
+	if tx.Bucket([]byte(invalidBucket)).Get(header.PrevBlockHash) != nil {
+		return nil, ruleError(ErrInvalidAncestor, "block descends from an invalid block")
+	}
+
+	parent, err := getHeaderNode(h, header.PrevBlockHash)
+	if err != nil {
+		return nil, err
+	}
+
+	// Retargeting relies on the timestamps, so they may neither go back
+	// before the recent blocks nor run far ahead of the actual time
+	medianTime, err := calcPastMedianTime(h, parent)
+	if err != nil {
+		return nil, err
+	}
+
+	if header.Timestamp <= medianTime {
+		return nil, ruleError(ErrTimeTooOld, fmt.Sprintf("block timestamp %d is not after the median time %d of the last blocks", header.Timestamp, medianTime))
+	}
+
+	maxTimestamp := time.Now().Unix() + maxTimeOffset
+	if header.Timestamp > maxTimestamp {
+		return nil, ruleError(ErrTimeTooNew, fmt.Sprintf("block timestamp %d is later than the maximum of %d", header.Timestamp, maxTimestamp))
+	}
+
+	expectedBits, err := calcNextRequiredBits(h, parent, params)
+	if err != nil {
+		return nil, err
+	}
+
+	if header.Bits != expectedBits {
+		return nil, ruleError(ErrUnexpectedDifficulty, fmt.Sprintf("block bits %08x do not match the expected bits %08x", header.Bits, expectedBits))
+	}
+
+	return parent, nil
+}
+
+// calcPastMedianTime returns the median timestamp of the header node and up
+// to medianTimeBlocks-1 of its ancestors
+func calcPastMedianTime(h StoreBucket, node *headerNode) (int64, error) {
+	var timestamps []int64
+	var err error
+
+	for {
+		timestamps = append(timestamps, node.Timestamp)
+		if len(timestamps) == medianTimeBlocks || len(node.PrevBlockHash) == 0 {
+			break
+		}
+
+		node, err = getHeaderNode(h, node.PrevBlockHash)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	sort.Slice(timestamps, func(i, j int) bool { return timestamps[i] < timestamps[j] })
+	return timestamps[len(timestamps)/2], nil
+}
+
+// checkConnectBlock checks the transactions of a block against the UTXO set.
+// The block has to be a child of the current tip of the UTXO set.
+func checkConnectBlock(tx StoreTx, block *Block, params *ChainParams) error {
+	_, err := checkConnectTransactions(tx, block, params)
+	return err
+}
+
+// checkConnectTransactions performs the checks of checkConnectBlock. If they
+// fail, it returns the index of the failing transaction, which is 0 if the
+// coinbase pays too much.
+func checkConnectTransactions(tx StoreTx, block *Block, params *ChainParams) (int, error) {
+	b := tx.Bucket([]byte(utxoBucket))
+	created := make(map[outpoint]*UtxoEntry)
+	maxValue := params.MaxSupply()
+	fees := 0
+
+	for i, t := range block.Transactions {
+		// Adding the outputs would overwrite the unspent outputs of an
+		// earlier transaction with the same ID
+		txid := hex.EncodeToString(t.ID)
+		if b.Get(t.ID) != nil {
+			return i, ruleError(ErrOverwriteTx, fmt.Sprintf("transaction %x already has unspent outputs", t.ID))
+		}
+		for outIdx := range t.Vout {
+			if created[outpoint{txid, outIdx}] != nil {
+				return i, ruleError(ErrOverwriteTx, fmt.Sprintf("transaction %x already has unspent outputs", t.ID))
+			}
+		}
+
+		if !t.IsCoinbase() {
+			var spent []TXOutput
+
+			for _, vin := range t.Vin {
+				op := outpoint{hex.EncodeToString(vin.Txid), vin.Vout}
+				entry, ok := created[op]
+				if ok {
+					delete(created, op)
+				} else {
+					outsBytes := b.Get(vin.Txid)
+					if outsBytes == nil {
+						return i, ruleError(ErrMissingInput, fmt.Sprintf("input %x:%d of transaction %x is not in the UTXO set", vin.Txid, vin.Vout, t.ID))
+					}
+
+					entry, ok = DeserializeOutputs(outsBytes).entry(vin.Vout)
+					if !ok {
+						return i, ruleError(ErrMissingInput, fmt.Sprintf("input %x:%d of transaction %x is not in the UTXO set", vin.Txid, vin.Vout, t.ID))
+					}
+				}
+
+				if !entry.IsMature(block.Height, params) {
+					return i, ruleError(ErrImmatureSpend, fmt.Sprintf("transaction %x spends coinbase output %x:%d from height %d at height %d", t.ID, vin.Txid, vin.Vout, entry.Height, block.Height))
+				}
+
+				spent = append(spent, entry.Output)
+			}
+
+			fee, err := CheckTransactionInputs(t, spent, params)
+			if err != nil {
+				return i, err
+			}
+
+			if fee < 0 || fees > maxValue-fee {
+				return i, ruleError(ErrBadFees, fmt.Sprintf("fee %d of transaction %x is negative or the fees of the block exceed the maximum supply of %d", fee, t.ID, maxValue))
+			}
+			fees += fee
+		}
+
+		for outIdx, out := range t.Vout {
+			created[outpoint{txid, outIdx}] = &UtxoEntry{Output: out, Height: block.Height, Coinbase: t.IsCoinbase()}
+		}
+	}
+
+	coinbase := block.Transactions[0]
+	coinbaseValue, err := sumOutputs(coinbase.ID, coinbase.Vout, params)
+	if err != nil {
+		return 0, err
+	}
+
+	maxCoinbaseValue := CalcBlockSubsidy(block.Height, params) + fees
+	if coinbaseValue > maxCoinbaseValue {
+		return 0, ruleError(ErrBadCoinbaseValue, fmt.Sprintf("coinbase pays %d which is more than subsidy and fees of %d", coinbaseValue, maxCoinbaseValue))
+	}
+
+	return 0, nil
+}
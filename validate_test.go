@@ -0,0 +1,419 @@
+package coin
+
+import (
+	"context"
+	"encoding/hex"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// assertRuleError asserts that err is a RuleError with the code
+func assertRuleError(t *testing.T, err error, code ErrorCode) {
+	rerr, ok := err.(RuleError)
+	require.True(t, ok, "error %v is not a RuleError", err)
+	assert.Equal(t, code, rerr.ErrorCode, rerr.Description)
+}
+
+// newTestTransaction returns an unsigned transaction that spends txid:0 and
+// pays the values to the wallet
+func newTestTransaction(wallet *Wallet, params *ChainParams, txid []byte, values ...int) *Transaction {
+	tx := &Transaction{Vin: []TXInput{{Txid: txid, Vout: 0, PubKey: wallet.PublicKey}}}
+	for _, value := range values {
+		tx.Vout = append(tx.Vout, *NewTXOutput(value, string(wallet.GetAddress(params))))
+	}
+	tx.ID = tx.Hash()
+
+	return tx
+}
+
+func TestCheckTransactionSanityValues(t *testing.T) {
+	params := RegTestParams
+	maxSupply := params.MaxSupply()
+	wallet, err := NewWallet()
+	require.NoError(t, err)
+
+	tests := []struct {
+		name   string
+		values []int
+		valid  bool
+	}{
+		{"maximum supply", []int{maxSupply}, true},
+		{"sum of maximum supply", []int{maxSupply - 1, 1}, true},
+		{"negative output", []int{-1}, false},
+		{"output above maximum supply", []int{maxSupply + 1}, false},
+		{"sum above maximum supply", []int{maxSupply, 1}, false},
+		{"sum that overflows", []int{1 << 62, 1 << 62}, false},
+	}
+
+	for _, test := range tests {
+		tx := newTestTransaction(wallet, &params, []byte{1}, test.values...)
+		err := CheckTransactionSanity(tx, &params)
+		if test.valid {
+			assert.NoError(t, err, test.name)
+		} else {
+			assertRuleError(t, err, ErrBadTxOutValue)
+		}
+	}
+}
+
+func TestCheckTransactionInputsValues(t *testing.T) {
+	params := RegTestParams
+	maxSupply := params.MaxSupply()
+	wallet, err := NewWallet()
+	require.NoError(t, err)
+
+	prevTx := newTestTransaction(wallet, &params, []byte{1}, 10)
+	spent := prevTx.Vout
+	prevTXs := map[string]Transaction{hex.EncodeToString(prevTx.ID): *prevTx}
+
+	tx := newTestTransaction(wallet, &params, prevTx.ID, 4, 5)
+	require.NoError(t, tx.Sign(wallet.PrivateKey, prevTXs))
+	fee, err := CheckTransactionInputs(tx, spent, &params)
+	require.NoError(t, err)
+	assert.Equal(t, 1, fee)
+
+	// Outputs that overflow to a negative sum would result in a positive
+	// fee, or in a negative one if the inputs overflow as well
+	tx = newTestTransaction(wallet, &params, prevTx.ID, 1<<62, 1<<62)
+	require.NoError(t, tx.Sign(wallet.PrivateKey, prevTXs))
+	fee, err = CheckTransactionInputs(tx, spent, &params)
+	assertRuleError(t, err, ErrBadTxOutValue)
+	assert.Equal(t, 0, fee)
+
+	tx = newTestTransaction(wallet, &params, prevTx.ID, 1)
+	require.NoError(t, tx.Sign(wallet.PrivateKey, prevTXs))
+	_, err = CheckTransactionInputs(tx, []TXOutput{{Value: 1 << 62, PubKeyHash: spent[0].PubKeyHash}}, &params)
+	assertRuleError(t, err, ErrBadTxOutValue)
+
+	_, err = CheckTransactionInputs(tx, []TXOutput{{Value: -1, PubKeyHash: spent[0].PubKeyHash}}, &params)
+	assertRuleError(t, err, ErrBadTxOutValue)
+
+	tx = newTestTransaction(wallet, &params, prevTx.ID, 11)
+	require.NoError(t, tx.Sign(wallet.PrivateKey, prevTXs))
+	_, err = CheckTransactionInputs(tx, spent, &params)
+	assertRuleError(t, err, ErrSpendTooHigh)
+
+	tx = newTestTransaction(wallet, &params, prevTx.ID, maxSupply)
+	tx.Vin = append(tx.Vin, TXInput{Txid: prevTx.ID, Vout: 1, PubKey: wallet.PublicKey})
+	_, err = CheckTransactionInputs(tx, []TXOutput{{Value: maxSupply}, {Value: 1}}, &params)
+	assertRuleError(t, err, ErrBadTxOutValue)
+}
+
+func TestCheckConnectBlockOverflowingFee(t *testing.T) {
+	bc, wallet, params := newTestChain(t)
+	params.CoinbaseMaturity = 1
+
+	genesis, err := bc.GetBlockByHeight(0)
+	require.NoError(t, err)
+	prevTXs := map[string]Transaction{hex.EncodeToString(genesis.Transactions[0].ID): *genesis.Transactions[0]}
+
+	// The outputs add up to -2^63, which without the range checks would be a
+	// negative fee that lowers the value the coinbase may claim
+	spend := newTestTransaction(wallet, params, genesis.Transactions[0].ID, 1<<62, 1<<62)
+	require.NoError(t, spend.Sign(wallet.PrivateKey, prevTXs))
+	coinbase := NewCoinbaseTX(string(wallet.GetAddress(params)), "", CalcBlockSubsidy(1, params))
+	block := NewBlockTemplate([]*Transaction{coinbase, spend}, genesis.Hash, 1, genesis.Bits)
+
+	err = bc.DB.View(func(tx StoreTx) error {
+		return checkConnectBlock(tx, block, params)
+	})
+	assertRuleError(t, err, ErrBadTxOutValue)
+}
+
+func TestRejectOverwrittenTransaction(t *testing.T) {
+	bc, wallet, params := newTestChain(t)
+
+	coinbase := NewCoinbaseTX(string(wallet.GetAddress(params)), "duplicate", CalcBlockSubsidy(1, params))
+	_, err := bc.MineBlock([]*Transaction{coinbase})
+	require.NoError(t, err)
+
+	// The same coinbase in the next block has the same ID
+	duplicate := NewCoinbaseTX(string(wallet.GetAddress(params)), "duplicate", CalcBlockSubsidy(2, params))
+	require.Equal(t, coinbase.ID, duplicate.ID)
+	_, err = bc.NewBlockTemplate([]*Transaction{duplicate})
+	assertRuleError(t, err, ErrOverwriteTx)
+
+	tip := bc.Iterator().Next()
+	assertRuleError(t, bc.AddBlock(newBlockOn(t, tip, []*Transaction{duplicate})), ErrOverwriteTx)
+
+	entry, err := UTXOSet{Blockchain: bc}.FetchEntry(coinbase.ID, 0)
+	require.NoError(t, err)
+	require.NotNil(t, entry)
+	assert.Equal(t, 1, entry.Height)
+}
+
+func TestCheckBlockSanityRules(t *testing.T) {
+	params := RegTestParams
+	wallet, err := NewWallet()
+	require.NoError(t, err)
+	address := string(wallet.GetAddress(&params))
+
+	prevTx := newTestTransaction(wallet, &params, []byte{1}, 10)
+	prevTXs := map[string]Transaction{hex.EncodeToString(prevTx.ID): *prevTx}
+	signed := func(values ...int) *Transaction {
+		tx := newTestTransaction(wallet, &params, prevTx.ID, values...)
+		require.NoError(t, tx.Sign(wallet.PrivateKey, prevTXs))
+		return tx
+	}
+	mine := func(block *Block) {
+		require.NoError(t, NewMiner().Mine(context.Background(), block))
+	}
+
+	tests := []struct {
+		name   string
+		mutate func(block *Block)
+		code   ErrorCode
+	}{
+		{"hash", func(block *Block) {
+			mine(block)
+			block.Hash = []byte{1}
+		}, ErrBadHash},
+		{"version", func(block *Block) {
+			block.Version = blockHeaderVersion + 1
+			mine(block)
+		}, ErrBadBlockVersion},
+		{"zero target", func(block *Block) {
+			block.Bits = 0
+			block.Hash = block.BlockHash()
+		}, ErrBadBits},
+		{"target above limit", func(block *Block) {
+			block.Bits = BigToCompact(new(big.Int).Lsh(params.PowLimit, 1))
+			block.Hash = block.BlockHash()
+		}, ErrBadBits},
+		{"proof of work", func(block *Block) {
+			for NewProofOfWork(&block.BlockHeader).Validate() {
+				block.Nonce++
+			}
+			block.Hash = block.BlockHash()
+		}, ErrHighHash},
+		{"no transactions", func(block *Block) {
+			block.Transactions = nil
+			mine(block)
+		}, ErrNoTransactions},
+		{"size", func(block *Block) {
+			block.Transactions[0] = NewCoinbaseTX(address, strings.Repeat("x", MaxBlockSize), 10)
+			block.MerkleRoot = block.HashTransactions()
+			mine(block)
+		}, ErrBlockTooBig},
+		{"merkle root", func(block *Block) {
+			block.MerkleRoot = make([]byte, 32)
+			mine(block)
+		}, ErrBadMerkleRoot},
+		{"first transaction not coinbase", func(block *Block) {
+			block.Transactions = block.Transactions[1:]
+			block.MerkleRoot = block.HashTransactions()
+			mine(block)
+		}, ErrFirstTxNotCoinbase},
+		{"second coinbase", func(block *Block) {
+			block.Transactions = append(block.Transactions, NewCoinbaseTX(address, "", 10))
+			block.MerkleRoot = block.HashTransactions()
+			mine(block)
+		}, ErrMultipleCoinbases},
+		{"transaction ID", func(block *Block) {
+			block.Transactions[1].ID = []byte{1}
+			mine(block)
+		}, ErrBadTxID},
+		{"no inputs", func(block *Block) {
+			tx := &Transaction{Vout: []TXOutput{*NewTXOutput(1, address)}}
+			tx.ID = tx.Hash()
+			block.Transactions[1] = tx
+			block.MerkleRoot = block.HashTransactions()
+			mine(block)
+		}, ErrNoTxInputs},
+		{"no outputs", func(block *Block) {
+			block.Transactions[1] = signed()
+			block.MerkleRoot = block.HashTransactions()
+			mine(block)
+		}, ErrNoTxOutputs},
+		{"negative output", func(block *Block) {
+			block.Transactions[1] = signed(-1)
+			block.MerkleRoot = block.HashTransactions()
+			mine(block)
+		}, ErrBadTxOutValue},
+		{"double spend", func(block *Block) {
+			block.Transactions = append(block.Transactions, signed(8))
+			block.MerkleRoot = block.HashTransactions()
+			mine(block)
+		}, ErrDoubleSpend},
+	}
+
+	for _, test := range tests {
+		coinbase := NewCoinbaseTX(address, "", 10)
+		block := NewBlockTemplate([]*Transaction{coinbase, signed(9)}, []byte{1}, 1, params.PowLimitBits)
+
+		test.mutate(block)
+		err := CheckBlockSanity(block, &params)
+		require.Error(t, err, test.name)
+		assertRuleError(t, err, test.code)
+	}
+
+	block := NewBlockTemplate([]*Transaction{NewCoinbaseTX(address, "", 10), signed(9)}, []byte{1}, 1, params.PowLimitBits)
+	mine(block)
+	assert.NoError(t, CheckBlockSanity(block, &params))
+}
+
+func TestCheckConnectBlockRules(t *testing.T) {
+	bc, wallet, params := newTestChain(t)
+	address := string(wallet.GetAddress(params))
+
+	other, err := NewWallet()
+	require.NoError(t, err)
+
+	genesis, err := bc.GetBlockByHeight(0)
+	require.NoError(t, err)
+	genesisCoinbase := genesis.Transactions[0]
+	prevTXs := map[string]Transaction{hex.EncodeToString(genesisCoinbase.ID): *genesisCoinbase}
+	signed := func(w *Wallet, values ...int) *Transaction {
+		tx := newTestTransaction(w, params, genesisCoinbase.ID, values...)
+		require.NoError(t, tx.Sign(w.PrivateKey, prevTXs))
+		return tx
+	}
+	check := func(maturity int, transactions ...*Transaction) error {
+		params.CoinbaseMaturity = maturity
+		block := NewBlockTemplate(transactions, genesis.Hash, 1, genesis.Bits)
+
+		return bc.DB.View(func(tx StoreTx) error {
+			return checkConnectBlock(tx, block, params)
+		})
+	}
+	coinbase := func(value int) *Transaction {
+		return NewCoinbaseTX(address, "", value)
+	}
+	subsidy := CalcBlockSubsidy(1, params)
+
+	unknown := newTestTransaction(wallet, params, []byte{1}, 1)
+	require.NoError(t, unknown.Sign(wallet.PrivateKey, map[string]Transaction{"01": *genesisCoinbase}))
+	assertRuleError(t, check(1, coinbase(subsidy), unknown), ErrMissingInput)
+
+	spentVout := signed(wallet, 9)
+	spentVout.Vin[0].Vout = 1
+	assertRuleError(t, check(1, coinbase(subsidy), spentVout), ErrMissingInput)
+
+	assertRuleError(t, check(2, coinbase(subsidy), signed(wallet, 9)), ErrImmatureSpend)
+	assertRuleError(t, check(1, coinbase(subsidy), signed(wallet, 11)), ErrSpendTooHigh)
+
+	badSignature := signed(wallet, 9)
+	badSignature.Vin[0].Signature[0] ^= 0xff
+	assertRuleError(t, check(1, coinbase(subsidy), badSignature), ErrBadSignature)
+
+	// A key that does not match the spent output is rejected even with a
+	// valid signature
+	assertRuleError(t, check(1, coinbase(subsidy), signed(other, 9)), ErrBadSignature)
+
+	assertRuleError(t, check(1, coinbase(subsidy+2), signed(wallet, 9)), ErrBadCoinbaseValue)
+
+	// A transaction may spend an output created earlier in the block, but not
+	// twice
+	first := signed(wallet, 9)
+	chained := &Transaction{
+		Vin:  []TXInput{{Txid: first.ID, Vout: 0, PubKey: wallet.PublicKey}},
+		Vout: []TXOutput{*NewTXOutput(8, address)},
+	}
+	chained.ID = chained.Hash()
+	require.NoError(t, chained.Sign(wallet.PrivateKey, map[string]Transaction{hex.EncodeToString(first.ID): *first}))
+	assert.NoError(t, check(1, coinbase(subsidy+2), first, chained))
+
+	again := &Transaction{
+		Vin:  []TXInput{{Txid: first.ID, Vout: 0, PubKey: wallet.PublicKey}},
+		Vout: []TXOutput{*NewTXOutput(7, address)},
+	}
+	again.ID = again.Hash()
+	require.NoError(t, again.Sign(wallet.PrivateKey, map[string]Transaction{hex.EncodeToString(first.ID): *first}))
+	assertRuleError(t, check(1, coinbase(subsidy), first, chained, again), ErrMissingInput)
+}
+
+func TestBlockTimestampRules(t *testing.T) {
+	bc, wallet, params := newTestChain(t)
+	address := string(wallet.GetAddress(params))
+
+	genesis, err := bc.GetBlockByHeight(0)
+	require.NoError(t, err)
+
+	// A block with the timestamp on top of the tip
+	blockAt := func(parent *Block, timestamp int64) *Block {
+		coinbase := NewCoinbaseTX(address, "", CalcBlockSubsidy(parent.Height+1, params))
+		block := NewBlockTemplate([]*Transaction{coinbase}, parent.Hash, parent.Height+1, parent.Bits)
+		block.Timestamp = timestamp
+		require.NoError(t, NewMiner().Mine(context.Background(), block))
+		return block
+	}
+
+	// Timestamps may go back as long as they stay after the median of the
+	// last eleven blocks
+	offsets := []int64{10, 20, 15, 30, 40, 50, 25, 60, 70, 80, 90, 100}
+	parent := &genesis
+	for _, offset := range offsets {
+		block := blockAt(parent, genesis.Timestamp+offset)
+		require.NoError(t, bc.AddBlock(block))
+		parent = block
+	}
+
+	err = bc.DB.View(func(tx StoreTx) error {
+		h := tx.Bucket([]byte(headersBucket))
+		node, err := getHeaderNode(h, parent.Hash)
+		require.NoError(t, err)
+
+		// The last eleven offsets sorted are 15 20 25 30 40 50 60 70 80 90 100
+		medianTime, err := calcPastMedianTime(h, node)
+		require.NoError(t, err)
+		assert.Equal(t, genesis.Timestamp+50, medianTime)
+
+		node, err = getHeaderNode(h, genesis.Hash)
+		require.NoError(t, err)
+		medianTime, err = calcPastMedianTime(h, node)
+		require.NoError(t, err)
+		assert.Equal(t, genesis.Timestamp, medianTime)
+
+		return nil
+	})
+	require.NoError(t, err)
+
+	tooOld := blockAt(parent, genesis.Timestamp+50)
+	assertRuleError(t, bc.AddBlock(tooOld), ErrTimeTooOld)
+	assertRuleError(t, bc.AddHeaders([]*BlockHeader{&tooOld.BlockHeader}), ErrTimeTooOld)
+
+	tooNew := blockAt(parent, time.Now().Unix()+maxTimeOffset+60)
+	assertRuleError(t, bc.AddBlock(tooNew), ErrTimeTooNew)
+	assertRuleError(t, bc.AddHeaders([]*BlockHeader{&tooNew.BlockHeader}), ErrTimeTooNew)
+
+	require.NoError(t, bc.AddBlock(blockAt(parent, genesis.Timestamp+51)))
+
+	// Block templates move the timestamp after the median time
+	template, err := bc.NewBlockTemplate([]*Transaction{NewCoinbaseTX(address, "", CalcBlockSubsidy(14, params))})
+	require.NoError(t, err)
+	assert.True(t, template.Timestamp > genesis.Timestamp+50)
+}
+
+func TestRejectOverflowingCoinbase(t *testing.T) {
+	bc, wallet, params := newTestChain(t)
+	address := string(wallet.GetAddress(params))
+
+	genesis, err := bc.GetBlockByHeight(0)
+	require.NoError(t, err)
+
+	// The outputs add up to 2^64 plus the subsidy, which wraps around to
+	// exactly the subsidy
+	subsidy := CalcBlockSubsidy(1, params)
+	coinbase := NewCoinbaseTX(address, "", subsidy)
+	for i := 0; i < 4; i++ {
+		coinbase.Vout = append(coinbase.Vout, *NewTXOutput(1<<62, address))
+	}
+	coinbase.ID = coinbase.Hash()
+
+	block := newBlockOn(t, &genesis, []*Transaction{coinbase})
+	assertRuleError(t, bc.AddBlock(block), ErrBadTxOutValue)
+
+	err = bc.DB.View(func(tx StoreTx) error {
+		return checkConnectBlock(tx, block, params)
+	})
+	assertRuleError(t, err, ErrBadTxOutValue)
+
+	total, err := UTXOSet{Blockchain: bc}.TotalValue()
+	require.NoError(t, err)
+	assert.Equal(t, ExpectedSupply(0, params), total)
+}
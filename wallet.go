@@ -6,13 +6,14 @@ import (
 	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/sha256"
+	"fmt"
 	"log"
+	"math/big"
 
 	"golang.org/x/crypto/ripemd160"
 )
 
 const (
-	version            = byte(0x00)
 	addressChecksumLen = 4
 	walletFile         = "wallet_%d.dat"
 )
@@ -37,20 +38,44 @@ func newKeyPair() (ecdsa.PrivateKey, []byte, error) {
 		return ecdsa.PrivateKey{}, nil, err
 	}
 
-	pubKey := append(private.PublicKey.X.Bytes(), private.PublicKey.Y.Bytes()...)
+	pubKey := append(padBytes(private.PublicKey.X, 32), padBytes(private.PublicKey.Y, 32)...)
 	return *private, pubKey, nil
 }
 
-// GetAddress returns wallet address
-func (w Wallet) GetAddress() []byte {
-	pubKeyHash := HashPubKey(w.PublicKey)
-	versionedPayload := append([]byte{version}, pubKeyHash...)
+// padBytes returns the big-endian bytes of n left-padded with zeros to size
+// bytes. Public keys and signatures are split in halves when they are
+// verified, so both halves need the same length.
+func padBytes(n *big.Int, size int) []byte {
+	b := n.Bytes()
+	if len(b) >= size {
+		return b
+	}
+
+	return append(make([]byte, size-len(b)), b...)
+}
+
+// GetAddress returns the address of the wallet on the network
+func (w Wallet) GetAddress(params *ChainParams) []byte {
+	return []byte(EncodeAddress(HashPubKey(w.PublicKey), params))
+}
+
+// EncodeAddress returns the address of the pubkey hash on the network
+func EncodeAddress(pubKeyHash []byte, params *ChainParams) string {
+	versionedPayload := append([]byte{params.AddressVersion}, pubKeyHash...)
 	checksum := checksum(versionedPayload)
 
 	fullPayload := append(versionedPayload, checksum...)
-	address := Base58Encode(fullPayload)
+	return string(Base58Encode(fullPayload))
+}
 
-	return address
+// DecodeAddress returns the pubkey hash of an address of the network
+func DecodeAddress(address string, params *ChainParams) ([]byte, error) {
+	if !ValidateAddress(address, params) {
+		return nil, fmt.Errorf("address '%s' is not valid", address)
+	}
+
+	payload := Base58Decode([]byte(address))
+	return payload[1 : len(payload)-addressChecksumLen], nil
 }
 
 // HashPubKey hashes public key
@@ -66,15 +91,19 @@ func HashPubKey(pubKey []byte) []byte {
 	return publicRIPEMD160
 }
 
-// ValidateAddress check if address if valid
-func ValidateAddress(address string) bool {
-	if address == "" {
+// ValidateAddress checks if the address is valid on the network. Addresses of
+// other networks start with a different version byte and are rejected.
+func ValidateAddress(address string, params *ChainParams) bool {
+	pubKeyHash := Base58Decode([]byte(address))
+	if len(pubKeyHash) <= 1+addressChecksumLen {
 		return false
 	}
 
-	pubKeyHash := Base58Decode([]byte(address))
 	actualChecksum := pubKeyHash[len(pubKeyHash)-addressChecksumLen:]
 	version := pubKeyHash[0]
+	if version != params.AddressVersion {
+		return false
+	}
 	pubKeyHash = pubKeyHash[1 : len(pubKeyHash)-addressChecksumLen]
 	targetChecksum := checksum(append([]byte{version}, pubKeyHash...))
 
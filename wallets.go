@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 )
 
 // Wallets stores a collection of wallets
@@ -14,22 +15,24 @@ type Wallets struct {
 	Wallets map[string]*Wallet
 }
 
-// NewWallets creates Wallets and fills it from a file if it exists
-func NewWallets(nodeID int) (*Wallets, error) {
+// NewWallets creates Wallets and fills it from the file of the node ID in
+// the directory if it exists
+func NewWallets(dir string, nodeID int) (*Wallets, error) {
 	wallets := Wallets{}
 	wallets.Wallets = make(map[string]*Wallet)
-	err := wallets.LoadFromFile(nodeID)
+	err := wallets.LoadFromFile(dir, nodeID)
 	return &wallets, err
 }
 
-// CreateWallet adds a Wallet to Wallets
-func (ws *Wallets) CreateWallet() (string, error) {
+// CreateWallet adds a Wallet to Wallets and returns its address on the
+// network
+func (ws *Wallets) CreateWallet(params *ChainParams) (string, error) {
 	wallet, err := NewWallet()
 	if err != nil {
 		return "", err
 	}
 
-	address := string(wallet.GetAddress())
+	address := string(wallet.GetAddress(params))
 	ws.Wallets[address] = wallet
 	return address, nil
 }
@@ -54,9 +57,9 @@ func (ws Wallets) GetWallet(address string) (Wallet, error) {
 	return Wallet{}, fmt.Errorf("address '%s' does not exist in your wallet", address)
 }
 
-// LoadFromFile loads wallets from the file
-func (ws *Wallets) LoadFromFile(nodeID int) error {
-	walletFile := fmt.Sprintf(walletFile, nodeID)
+// LoadFromFile loads wallets from the file of the node ID in the directory
+func (ws *Wallets) LoadFromFile(dir string, nodeID int) error {
+	walletFile := filepath.Join(dir, fmt.Sprintf(walletFile, nodeID))
 	if _, err := os.Stat(walletFile); os.IsNotExist(err) {
 		return err
 	}
@@ -78,10 +81,11 @@ func (ws *Wallets) LoadFromFile(nodeID int) error {
 	return nil
 }
 
-// SaveToFile saves wallets to a file
-func (ws Wallets) SaveToFile(nodeID int) error {
+// SaveToFile saves wallets to the file of the node ID in the directory. The
+// directory is created if it does not exist.
+func (ws Wallets) SaveToFile(dir string, nodeID int) error {
 	var content bytes.Buffer
-	walletFile := fmt.Sprintf(walletFile, nodeID)
+	walletFile := filepath.Join(dir, fmt.Sprintf(walletFile, nodeID))
 
 	gob.Register(elliptic.P256())
 	encoder := gob.NewEncoder(&content)
@@ -90,6 +94,11 @@ func (ws Wallets) SaveToFile(nodeID int) error {
 		return err
 	}
 
+	err = ensureDir(dir)
+	if err != nil {
+		return err
+	}
+
 	err = ioutil.WriteFile(walletFile, content.Bytes(), 0644)
 	return err
 }
@@ -0,0 +1,227 @@
+// Package wire implements the primitives of the binary serialization format
+// used for blocks, transactions and network messages.
+//
+// All integers are encoded big-endian with a fixed width. Byte slices and
+// strings are prefixed with their length as uint32. See SERIALIZATION.md for
+// the layout of each structure.
+package wire
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// MaxVarBytesLen limits the length of byte slices a Reader accepts
+const MaxVarBytesLen = 32 * 1024 * 1024
+
+// ErrTooLarge is returned when a length prefix exceeds MaxVarBytesLen
+var ErrTooLarge = errors.New("length prefix too large")
+
+// ErrInvalidBool is returned when a bool is encoded as a byte other than 0
+// and 1
+var ErrInvalidBool = errors.New("invalid bool")
+
+// Writer serializes values into a buffer
+type Writer struct {
+	buf bytes.Buffer
+}
+
+// Bytes returns the serialized data
+func (w *Writer) Bytes() []byte {
+	return w.buf.Bytes()
+}
+
+// WriteUint8 writes a single byte
+func (w *Writer) WriteUint8(v uint8) {
+	w.buf.WriteByte(v)
+}
+
+// WriteBool writes a bool as a single byte
+func (w *Writer) WriteBool(v bool) {
+	if v {
+		w.WriteUint8(1)
+	} else {
+		w.WriteUint8(0)
+	}
+}
+
+// WriteUint32 writes a uint32
+func (w *Writer) WriteUint32(v uint32) {
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], v)
+	w.buf.Write(b[:])
+}
+
+// WriteInt32 writes an int32 in two's complement
+func (w *Writer) WriteInt32(v int32) {
+	w.WriteUint32(uint32(v))
+}
+
+// WriteUint64 writes a uint64
+func (w *Writer) WriteUint64(v uint64) {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], v)
+	w.buf.Write(b[:])
+}
+
+// WriteInt64 writes an int64 in two's complement
+func (w *Writer) WriteInt64(v int64) {
+	w.WriteUint64(uint64(v))
+}
+
+// WriteVarBytes writes a byte slice prefixed with its length
+func (w *Writer) WriteVarBytes(v []byte) {
+	w.WriteUint32(uint32(len(v)))
+	w.buf.Write(v)
+}
+
+// WriteString writes a string prefixed with its length
+func (w *Writer) WriteString(v string) {
+	w.WriteVarBytes([]byte(v))
+}
+
+// Reader deserializes values from data. The first error is kept and all
+// following reads return zero values.
+type Reader struct {
+	r   *bytes.Reader
+	err error
+}
+
+// NewReader returns a Reader for the data
+func NewReader(data []byte) *Reader {
+	return &Reader{r: bytes.NewReader(data)}
+}
+
+// Err returns the first error that occurred while reading
+func (r *Reader) Err() error {
+	return r.err
+}
+
+// Finish returns the first read error or an error if not all data was read
+func (r *Reader) Finish() error {
+	if r.err != nil {
+		return r.err
+	}
+
+	if r.r.Len() != 0 {
+		return fmt.Errorf("%d bytes of trailing data", r.r.Len())
+	}
+
+	return nil
+}
+
+func (r *Reader) read(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+
+	if n > r.r.Len() {
+		r.err = io.ErrUnexpectedEOF
+		return nil
+	}
+
+	b := make([]byte, n)
+	_, r.err = io.ReadFull(r.r, b)
+	return b
+}
+
+// ReadUint8 reads a single byte
+func (r *Reader) ReadUint8() uint8 {
+	b := r.read(1)
+	if b == nil {
+		return 0
+	}
+
+	return b[0]
+}
+
+// ReadBool reads a bool. Bytes other than 0 and 1 are rejected.
+func (r *Reader) ReadBool() bool {
+	v := r.ReadUint8()
+	if v > 1 && r.err == nil {
+		r.err = ErrInvalidBool
+	}
+
+	return v == 1
+}
+
+// ReadUint32 reads a uint32
+func (r *Reader) ReadUint32() uint32 {
+	b := r.read(4)
+	if b == nil {
+		return 0
+	}
+
+	return binary.BigEndian.Uint32(b)
+}
+
+// ReadInt32 reads an int32
+func (r *Reader) ReadInt32() int32 {
+	return int32(r.ReadUint32())
+}
+
+// ReadUint64 reads a uint64
+func (r *Reader) ReadUint64() uint64 {
+	b := r.read(8)
+	if b == nil {
+		return 0
+	}
+
+	return binary.BigEndian.Uint64(b)
+}
+
+// ReadInt64 reads an int64
+func (r *Reader) ReadInt64() int64 {
+	return int64(r.ReadUint64())
+}
+
+// ReadVarBytes reads a byte slice prefixed with its length
+func (r *Reader) ReadVarBytes() []byte {
+	n := r.ReadUint32()
+	if r.err != nil {
+		return nil
+	}
+
+	if n > MaxVarBytesLen {
+		r.err = ErrTooLarge
+		return nil
+	}
+
+	if n == 0 {
+		return nil
+	}
+
+	return r.read(int(n))
+}
+
+// ReadString reads a string prefixed with its length
+func (r *Reader) ReadString() string {
+	return string(r.ReadVarBytes())
+}
+
+// ReadCount reads a uint32 element count. Counts larger than the remaining
+// data are rejected, as every element takes at least one byte.
+func (r *Reader) ReadCount() int {
+	n := r.ReadUint32()
+	if r.err != nil {
+		return 0
+	}
+
+	if int64(n) > int64(r.r.Len()) {
+		r.err = ErrTooLarge
+		return 0
+	}
+
+	return int(n)
+}
+
+// ReadVersion reads a version byte and checks that it is supported
+func (r *Reader) ReadVersion(supported uint8) {
+	v := r.ReadUint8()
+	if r.err == nil && v != supported {
+		r.err = fmt.Errorf("unsupported version %d", v)
+	}
+}